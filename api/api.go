@@ -8,9 +8,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/ArguableExorcist8/desvault-storage-node/audit"
+	"github.com/ArguableExorcist8/desvault-storage-node/auth"
+	"github.com/ArguableExorcist8/desvault-storage-node/encryption"
 	"github.com/ArguableExorcist8/desvault-storage-node/storage"
 
 	"github.com/gin-gonic/gin"
@@ -29,26 +33,22 @@ func getEnv(key, defaultValue string) string {
 }
 
 var (
-	authToken = getEnv("AUTH_TOKEN", "secret123")
-	port      = getEnv("PORT", "8080")
-	db        *gorm.DB
+	port       = getEnv("PORT", "8080")
+	db         *gorm.DB
+	tokenStore *auth.TokenStore
+	access     *auth.AccessControl
 )
 
 // Database Models
-type FileMetadataModel struct {
-	CID       string         `gorm:"column:cid;primaryKey;not null;size:255" json:"cid"`
-	FileName  string         `gorm:"size:255" json:"fileName"`
-	Note      string         `gorm:"size:255" json:"note"`
-	FileSize  string         `gorm:"size:255" json:"fileSize"`
-	Shards    datatypes.JSON `gorm:"type:jsonb" json:"shards"`
-	CreatedAt time.Time      `json:"createdAt"`
-}
+// FileMetadataModel is the database record of an uploaded file.
+type FileMetadataModel = storage.FileMetadataModel
 
 type FileMetadataResponse struct {
 	CID       string          `json:"cid"`
 	FileName  string          `json:"fileName"`
 	Note      string          `json:"note"`
 	FileSize  string          `json:"fileSize"`
+	Owner     string          `json:"owner"`
 	CreatedAt time.Time       `json:"createdAt"`
 	Shards    []storage.Shard `json:"shards"`
 }
@@ -63,6 +63,7 @@ func modelToResponse(model FileMetadataModel) (FileMetadataResponse, error) {
 		FileName:  model.FileName,
 		Note:      model.Note,
 		FileSize:  model.FileSize,
+		Owner:     model.Owner,
 		CreatedAt: model.CreatedAt,
 		Shards:    shards,
 	}, nil
@@ -116,24 +117,25 @@ func initDB() {
 	if err != nil {
 		log.Fatalf("failed to connect to database: %v", err)
 	}
-	if err := db.AutoMigrate(&FileMetadataModel{}); err != nil {
+	if err := db.AutoMigrate(&FileMetadataModel{}, &auth.APIToken{}, &auth.WalletSession{}, &auth.WalletMember{}, &auth.FileGrant{}, &audit.Entry{}); err != nil {
 		log.Fatalf("failed to auto-migrate database: %v", err)
 	}
+	tokenStore = auth.NewTokenStore(db)
+	access = auth.NewAccessControl(db)
+	auditLog := audit.New(db)
+	if anchor, err := encryption.AuditAnchor(); err == nil {
+		auditLog.SetAnchor(anchor)
+	} else {
+		log.Printf("[WARN] Audit log head will not be anchored: %v", err)
+	}
+	audit.SetDefault(auditLog)
+	if count, err := tokenStore.Count(); err == nil && count == 0 {
+		log.Println("[WARN] No API tokens exist; create one with 'desvault token create'.")
+	}
 	log.Println("[INFO] Database initialized successfully.")
 }
 
 // Middleware
-func authMiddleware(c *gin.Context) {
-	if c.GetHeader("Authorization") != "Bearer "+authToken {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"code":    http.StatusUnauthorized,
-			"message": "Unauthorized",
-		})
-		return
-	}
-	c.Next()
-}
-
 func secureHeadersMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("X-Content-Type-Options", "nosniff")
@@ -189,7 +191,7 @@ func main() {
 	router.Use(rateLimitMiddleware())
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
 		if c.Request.Method == "OPTIONS" {
@@ -199,9 +201,12 @@ func main() {
 		c.Next()
 	})
 
-	authorized := router.Group("/", authMiddleware)
+	siweChainID, _ := strconv.ParseInt(getEnv("SIWE_CHAIN_ID", "0"), 10, 64)
+	auth.NewSIWEService(tokenStore, os.Getenv("SIWE_DOMAIN"), siweChainID).RegisterRoutes(router)
 
-	authorized.POST("/upload", func(c *gin.Context) {
+	authorized := router.Group("/", tokenStore.ValidateRequest())
+
+	authorized.POST("/upload", auth.RequireScope(auth.ScopeUpload), func(c *gin.Context) {
 		file, err := c.FormFile("file")
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -245,6 +250,7 @@ func main() {
 		}
 		model.Note = note
 		model.FileSize = fileSizeStr
+		model.Owner = auth.PrincipalFromContext(c).ID()
 		model.CreatedAt = time.Now()
 		if err := db.Create(&model).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -253,6 +259,11 @@ func main() {
 			})
 			return
 		}
+		audit.Record(model.Owner, audit.ActionFileUpload, model.CID, map[string]interface{}{
+			"fileName": model.FileName,
+			"size":     file.Size,
+			"shards":   len(metadata.Shards),
+		})
 		c.JSON(http.StatusOK, gin.H{
 			"code":    http.StatusOK,
 			"message": "File uploaded successfully",
@@ -260,9 +271,9 @@ func main() {
 		})
 	})
 
-	authorized.GET("/files", func(c *gin.Context) {
+	authorized.GET("/files", auth.RequireScope(auth.ScopeRead), func(c *gin.Context) {
 		var models []FileMetadataModel
-		if err := db.Find(&models).Error; err != nil {
+		if err := access.Visible(db, auth.PrincipalFromContext(c)).Find(&models).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
 				"message": fmt.Sprintf("Database error: %v", err),
@@ -284,21 +295,9 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"files": responses})
 	})
 
-	authorized.GET("/download/:cid", func(c *gin.Context) {
-		cid := c.Param("cid")
-		var model FileMetadataModel
-		if err := db.First(&model, "cid = ?", cid).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{
-					"code":    http.StatusNotFound,
-					"message": "File metadata not found",
-				})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"code":    http.StatusInternalServerError,
-					"message": fmt.Sprintf("Database error: %v", err),
-				})
-			}
+	authorized.GET("/download/:cid", auth.RequireScope(auth.ScopeRead), func(c *gin.Context) {
+		model, ok := storage.LoadFileFor(c, db, access, c.Param("cid"), auth.PermRead)
+		if !ok {
 			return
 		}
 		var shards []storage.Shard
@@ -323,9 +322,49 @@ func main() {
 			return
 		}
 		defer os.Remove(outputPath)
+		audit.Record(auth.PrincipalFromContext(c).ID(), audit.ActionFileDownload, model.CID, map[string]interface{}{"fileName": model.FileName})
 		c.FileAttachment(outputPath, model.FileName)
 	})
 
+	authorized.DELETE("/files/:cid", auth.RequireScope(auth.ScopeDelete), func(c *gin.Context) {
+		model, ok := storage.LoadFileFor(c, db, access, c.Param("cid"), auth.PermWrite)
+		if !ok {
+			return
+		}
+		if err := db.Delete(&model).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": fmt.Sprintf("Database error: %v", err),
+			})
+			return
+		}
+		if err := access.RevokeAll(model.CID); err != nil {
+			log.Printf("[WARN] Failed to remove grants of %s: %v", model.CID, err)
+		}
+		storage.ReleaseShards(db, model, nil)
+		audit.Record(auth.PrincipalFromContext(c).ID(), audit.ActionFileDelete, model.CID, map[string]interface{}{
+			"fileName": model.FileName,
+			"owner":    model.Owner,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "File deleted",
+		})
+	})
+
+	access.RegisterGrantRoutes(authorized, func(cid string) (string, error) {
+		var model FileMetadataModel
+		if err := db.Select("owner").First(&model, "cid = ?", cid).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return "", auth.ErrNotFound
+			}
+			return "", err
+		}
+		return model.Owner, nil
+	})
+
+	audit.Default().RegisterRoutes(authorized.Group("/", auth.RequireScope(auth.ScopeAdmin)))
+
 	addr := ":" + port
 	log.Printf("[INFO] Server running on %s", addr)
 	if err := router.Run(addr); err != nil {
@@ -0,0 +1,426 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Audited actions.
+const (
+	ActionFileUpload   = "file.upload"
+	ActionFileDownload = "file.download"
+	ActionFileDelete   = "file.delete"
+	ActionFileGrant    = "file.grant"
+	ActionFileRevoke   = "file.revoke"
+	ActionTokenCreate  = "token.create"
+	ActionTokenRevoke  = "token.revoke"
+	ActionWalletLogin  = "wallet.login"
+	ActionKeyRotate    = "key.rotate"
+	ActionConfigChange = "config.change"
+)
+
+// genesisHash is the previous hash of the first entry.
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// maxAppendAttempts bounds retries when another process appends concurrently.
+const maxAppendAttempts = 5
+
+// Entry is a record of the audit log. Each entry's hash covers its fields and
+// the hash of the previous entry, so editing or removing any entry breaks the
+// chain from that point on. Removing the newest entries leaves a valid chain;
+// an Anchor detects that.
+type Entry struct {
+	Seq      uint64    `gorm:"primaryKey;autoIncrement:false" json:"seq"`
+	Time     time.Time `gorm:"index;not null" json:"time"`
+	Actor    string    `gorm:"size:128;index" json:"actor"`
+	Action   string    `gorm:"size:64;index" json:"action"`
+	Target   string    `gorm:"size:255" json:"target"`
+	Details  string    `gorm:"type:text" json:"details,omitempty"` // JSON object
+	PrevHash string    `gorm:"size:64;not null" json:"prevHash"`
+	Hash     string    `gorm:"size:64;not null" json:"hash"`
+}
+
+// TableName sets the database table for audit entries.
+func (Entry) TableName() string {
+	return "audit_log"
+}
+
+// computeHash returns the hash of the entry's fields and its PrevHash.
+// Times are hashed in UTC at microsecond precision, which every supported
+// database preserves.
+func (e *Entry) computeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		strconv.FormatUint(e.Seq, 10),
+		e.Time.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		e.Actor,
+		e.Action,
+		e.Target,
+		e.Details,
+	} {
+		fmt.Fprintf(h, "%d:%s|", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Log appends to and verifies the audit log in the database.
+type Log struct {
+	db     *gorm.DB
+	mu     sync.Mutex
+	anchor *Anchor
+}
+
+// SetAnchor makes the log record its head in anchor after every append and
+// check the chain against it in Verify.
+func (l *Log) SetAnchor(a *Anchor) {
+	l.mu.Lock()
+	l.anchor = a
+	l.mu.Unlock()
+}
+
+// New returns an audit log backed by db. The audit_log table must have been migrated.
+func New(db *gorm.DB) *Log {
+	return &Log{db: db}
+}
+
+// Append adds an entry to the end of the chain. details may be nil.
+func (l *Log) Append(actor, action, target string, details map[string]interface{}) (*Entry, error) {
+	var encoded string
+	if len(details) > 0 {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode audit details: %v", err)
+		}
+		encoded = string(data)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		entry := &Entry{
+			Time:    time.Now().UTC().Truncate(time.Microsecond),
+			Actor:   actor,
+			Action:  action,
+			Target:  target,
+			Details: encoded,
+		}
+		err = l.db.Transaction(func(tx *gorm.DB) error {
+			var last []Entry
+			if err := tx.Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+				return err
+			}
+			entry.Seq, entry.PrevHash = 1, genesisHash
+			if len(last) > 0 {
+				entry.Seq, entry.PrevHash = last[0].Seq+1, last[0].Hash
+			}
+			entry.Hash = entry.computeHash()
+			return tx.Create(entry).Error
+		})
+		if err == nil {
+			if l.anchor != nil {
+				if err := l.anchor.save(entry); err != nil {
+					log.Printf("[ERROR] Audit: failed to write checkpoint: %v", err)
+				}
+			}
+			return entry, nil
+		}
+		// A concurrent writer in another process took the sequence number; retry.
+	}
+	return nil, fmt.Errorf("failed to append audit entry: %v", err)
+}
+
+// Filter selects audit entries. Zero fields match everything.
+type Filter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // Defaults to 100
+}
+
+// Query returns matching entries, newest first.
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	q := l.db.Model(&Entry{})
+	if f.Actor != "" {
+		q = q.Where("actor = ?", f.Actor)
+	}
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+	if f.Target != "" {
+		q = q.Where("target = ?", f.Target)
+	}
+	if !f.Since.IsZero() {
+		q = q.Where("time >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		q = q.Where("time < ?", f.Until)
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	var entries []Entry
+	err := q.Order("seq DESC").Limit(f.Limit).Find(&entries).Error
+	return entries, err
+}
+
+// VerifyResult is the outcome of checking the hash chain.
+type VerifyResult struct {
+	Valid    bool   `json:"valid"`
+	Entries  uint64 `json:"entries"`
+	LastHash string `json:"lastHash,omitempty"`
+	BrokenAt uint64 `json:"brokenAt,omitempty"` // Sequence number of the first bad entry
+	Reason   string `json:"reason,omitempty"`
+}
+
+// verifyBatchSize is the number of entries loaded at a time during verification.
+const verifyBatchSize = 1000
+
+// Verify walks the whole chain and reports the first entry whose sequence
+// number, link or hash does not match. With an anchor, the chain must also
+// reach the checkpointed head, so entries removed from the end are detected.
+func (l *Log) Verify() (VerifyResult, error) {
+	res, err := l.verifyChain()
+	if err != nil || !res.Valid || l.anchor == nil {
+		return res, err
+	}
+	cp, err := l.anchor.load()
+	if err != nil {
+		return res, err
+	}
+	if cp == nil {
+		return res, nil
+	}
+	var head []Entry
+	if err := l.db.Where("seq = ?", cp.Seq).Limit(1).Find(&head).Error; err != nil {
+		return res, err
+	}
+	switch {
+	case len(head) == 0:
+		res.Valid = false
+		res.BrokenAt = res.Entries + 1
+		res.Reason = fmt.Sprintf("entries after %d are missing; the checkpoint records %d", res.Entries, cp.Seq)
+	case head[0].Hash != cp.Hash:
+		res.Valid = false
+		res.BrokenAt = cp.Seq
+		res.Reason = "entry hash does not match the checkpoint"
+	}
+	return res, nil
+}
+
+// verifyChain checks the links and hashes of every entry in the database.
+func (l *Log) verifyChain() (VerifyResult, error) {
+	res := VerifyResult{Valid: true}
+	prevHash := genesisHash
+	var next uint64 = 1
+	for {
+		var batch []Entry
+		if err := l.db.Where("seq >= ?", next).Order("seq").Limit(verifyBatchSize).Find(&batch).Error; err != nil {
+			return res, err
+		}
+		for i := range batch {
+			e := &batch[i]
+			switch {
+			case e.Seq != next:
+				res.Reason = fmt.Sprintf("entry %d is missing", next)
+			case e.PrevHash != prevHash:
+				res.Reason = "previous hash does not match"
+			case e.computeHash() != e.Hash:
+				res.Reason = "entry hash does not match its contents"
+			}
+			if res.Reason != "" {
+				res.Valid = false
+				res.BrokenAt = next
+				return res, nil
+			}
+			prevHash = e.Hash
+			res.Entries++
+			res.LastHash = e.Hash
+			next++
+		}
+		if len(batch) < verifyBatchSize {
+			return res, nil
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Checkpoint
+// -----------------------------------------------------------------------------
+
+// checkpointDomain separates checkpoint signatures from other uses of the signing key.
+const checkpointDomain = "desvault-audit-checkpoint:"
+
+// Checkpoint records the head of the chain outside the database.
+type Checkpoint struct {
+	Seq       uint64    `json:"seq"`
+	Hash      string    `json:"hash"`
+	Time      time.Time `json:"time"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+func (c *Checkpoint) signedBytes() []byte {
+	return []byte(fmt.Sprintf("%s%d:%s", checkpointDomain, c.Seq, c.Hash))
+}
+
+// Anchor keeps the checkpoint in a file, signed with the node identity when
+// Sign and Verify are set, so the checkpoint cannot be rewritten to match a
+// truncated chain.
+type Anchor struct {
+	Path   string
+	Sign   func(data []byte) ([]byte, error)
+	Verify func(data, sig []byte) (bool, error)
+}
+
+// load returns the checkpoint, or nil if none was written yet.
+func (a *Anchor) load() (*Checkpoint, error) {
+	data, err := os.ReadFile(a.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit checkpoint: %v", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse audit checkpoint: %v", err)
+	}
+	if a.Verify != nil {
+		if ok, err := a.Verify(cp.signedBytes(), cp.Signature); err != nil || !ok {
+			return nil, errors.New("audit checkpoint signature is invalid")
+		}
+	}
+	return &cp, nil
+}
+
+// save records e as the new head unless the checkpoint is already further along.
+func (a *Anchor) save(e *Entry) error {
+	if cp, err := a.load(); err == nil && cp != nil && cp.Seq >= e.Seq {
+		return nil
+	}
+	cp := Checkpoint{Seq: e.Seq, Hash: e.Hash, Time: e.Time}
+	if a.Sign != nil {
+		sig, err := a.Sign(cp.signedBytes())
+		if err != nil {
+			return fmt.Errorf("failed to sign checkpoint: %v", err)
+		}
+		cp.Signature = sig
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.Path), ".audit-checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.Path)
+}
+
+// -----------------------------------------------------------------------------
+// Default Log
+// -----------------------------------------------------------------------------
+
+var (
+	defaultMu  sync.RWMutex
+	defaultLog *Log
+)
+
+// SetDefault sets the log used by Record.
+func SetDefault(l *Log) {
+	defaultMu.Lock()
+	defaultLog = l
+	defaultMu.Unlock()
+}
+
+// Default returns the log used by Record, or nil if none is configured.
+func Default() *Log {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLog
+}
+
+// ErrNoLog is returned by Record when no default log is configured.
+var ErrNoLog = errors.New("audit log not configured")
+
+// Record appends an entry to the default log. Failures are logged, so callers
+// can audit actions without handling errors; the error is returned for
+// callers that must not proceed unaudited.
+func Record(actor, action, target string, details map[string]interface{}) error {
+	l := Default()
+	if l == nil {
+		return ErrNoLog
+	}
+	if _, err := l.Append(actor, action, target, details); err != nil {
+		log.Printf("[ERROR] Audit: %v", err)
+		return err
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// HTTP Routes
+// -----------------------------------------------------------------------------
+
+// RegisterRoutes adds the audit endpoints to r, which the caller must
+// restrict to administrators:
+//
+//	GET /audit          entries filtered by actor, action, target, since, until (RFC 3339) and limit
+//	GET /audit/verify   result of checking the hash chain
+func (l *Log) RegisterRoutes(r gin.IRouter) {
+	r.GET("/audit", func(c *gin.Context) {
+		f := Filter{Actor: c.Query("actor"), Action: c.Query("action"), Target: c.Query("target")}
+		for param, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+			if v := c.Query(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": fmt.Sprintf("invalid %s: %v", param, err)})
+					return
+				}
+				*dst = t
+			}
+		}
+		if v := c.Query("limit"); v != "" {
+			f.Limit, _ = strconv.Atoi(v)
+		}
+		entries, err := l.Query(f)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries})
+	})
+
+	r.GET("/audit/verify", func(c *gin.Context) {
+		res, err := l.Verify()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/audit"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// File permissions granted to principals other than the owner. PermWrite
+// includes PermRead and allows deleting the file.
+const (
+	PermRead  = "read"
+	PermWrite = "write"
+)
+
+// ErrNotFound is returned for files the caller may not see, so that the
+// existence of other users' files is not revealed.
+var ErrNotFound = errors.New("file not found")
+
+// FileGrant shares a file with a principal other than its owner.
+type FileGrant struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CID        string    `gorm:"column:cid;size:255;not null;uniqueIndex:idx_file_grant" json:"cid"`
+	Principal  string    `gorm:"size:128;not null;uniqueIndex:idx_file_grant;index" json:"principal"`
+	Permission string    `gorm:"size:16;not null" json:"permission"`
+	GrantedBy  string    `gorm:"size:128" json:"grantedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// TableName sets the database table for file grants.
+func (FileGrant) TableName() string {
+	return "file_grants"
+}
+
+// ParsePrincipalID validates a principal identifier such as "token:ci" or
+// "wallet:0xAb..." and returns it normalised.
+func ParsePrincipalID(id string) (string, error) {
+	kind, name, ok := strings.Cut(strings.TrimSpace(id), ":")
+	switch {
+	case !ok || name == "":
+		return "", fmt.Errorf("principal %q must be token:<name> or wallet:<address>", id)
+	case kind == PrincipalToken:
+		return kind + ":" + name, nil
+	case kind == PrincipalWallet && common.IsHexAddress(name):
+		return kind + ":" + common.HexToAddress(name).Hex(), nil
+	default:
+		return "", fmt.Errorf("principal %q must be token:<name> or wallet:<address>", id)
+	}
+}
+
+// AccessControl decides which principals may see, download and delete files.
+// Owners and admins have full access; other principals need a grant.
+type AccessControl struct {
+	db *gorm.DB
+}
+
+// NewAccessControl returns access control backed by db. The file_grants
+// table must have been migrated.
+func NewAccessControl(db *gorm.DB) *AccessControl {
+	return &AccessControl{db: db}
+}
+
+// Visible restricts a query on a file metadata table (with cid and owner
+// columns) to the files p owns or was granted.
+func (a *AccessControl) Visible(query *gorm.DB, p *Principal) *gorm.DB {
+	if p.HasScope(ScopeAdmin) {
+		return query
+	}
+	return query.Where("owner = ? OR cid IN (?)", p.ID(),
+		a.db.Model(&FileGrant{}).Select("cid").Where("principal = ?", p.ID()))
+}
+
+// Allowed reports whether p holds perm on the file cid owned by owner.
+func (a *AccessControl) Allowed(p *Principal, cid, owner, perm string) (bool, error) {
+	if p == nil {
+		return false, nil
+	}
+	if p.HasScope(ScopeAdmin) || (owner != "" && owner == p.ID()) {
+		return true, nil
+	}
+	var grants []FileGrant
+	if err := a.db.Where("cid = ? AND principal = ?", cid, p.ID()).Limit(1).Find(&grants).Error; err != nil {
+		return false, err
+	}
+	if len(grants) == 0 {
+		return false, nil
+	}
+	return perm == PermRead || grants[0].Permission == PermWrite, nil
+}
+
+// Grant shares cid with principal, replacing any previous grant.
+func (a *AccessControl) Grant(cid, principal, perm, grantedBy string) (*FileGrant, error) {
+	principal, err := ParsePrincipalID(principal)
+	if err != nil {
+		return nil, err
+	}
+	if perm != PermRead && perm != PermWrite {
+		return nil, fmt.Errorf("permission must be %q or %q", PermRead, PermWrite)
+	}
+	grant := &FileGrant{CID: cid, Principal: principal, Permission: perm, GrantedBy: grantedBy}
+	err = a.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cid"}, {Name: "principal"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permission", "granted_by"}),
+	}).Create(grant).Error
+	return grant, err
+}
+
+// Revoke removes principal's grant on cid.
+func (a *AccessControl) Revoke(cid, principal string) error {
+	principal, err := ParsePrincipalID(principal)
+	if err != nil {
+		return err
+	}
+	res := a.db.Where("cid = ? AND principal = ?", cid, principal).Delete(&FileGrant{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("%s has no grant on %s", principal, cid)
+	}
+	return nil
+}
+
+// Grants lists the grants on cid.
+func (a *AccessControl) Grants(cid string) ([]FileGrant, error) {
+	var grants []FileGrant
+	err := a.db.Where("cid = ?", cid).Order("created_at").Find(&grants).Error
+	return grants, err
+}
+
+// RevokeAll removes every grant on cid, e.g. after the file was deleted.
+func (a *AccessControl) RevokeAll(cid string) error {
+	return a.db.Where("cid = ?", cid).Delete(&FileGrant{}).Error
+}
+
+// RegisterGrantRoutes adds the sharing endpoints to an authenticated router.
+// Only the file's owner or an admin may manage its grants. ownerOf returns
+// the owner of a file, or ErrNotFound.
+//
+//	GET    /files/:cid/grants
+//	POST   /files/:cid/grants             {"principal": "wallet:0x...", "permission": "read"}
+//	DELETE /files/:cid/grants/:principal
+func (a *AccessControl) RegisterGrantRoutes(r gin.IRouter, ownerOf func(cid string) (string, error)) {
+	// requireOwner aborts unless the caller owns the file or is an admin.
+	requireOwner := func(c *gin.Context) {
+		p := PrincipalFromContext(c)
+		owner, err := ownerOf(c.Param("cid"))
+		if err == nil && p != nil && !p.HasScope(ScopeAdmin) && owner != p.ID() {
+			err = ErrNotFound
+		}
+		if errors.Is(err, ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": "File metadata not found"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
+			return
+		}
+		c.Next()
+	}
+
+	r.GET("/files/:cid/grants", RequireScope(ScopeRead), requireOwner, func(c *gin.Context) {
+		grants, err := a.Grants(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"grants": grants})
+	})
+
+	r.POST("/files/:cid/grants", RequireScope(ScopeUpload), requireOwner, func(c *gin.Context) {
+		var req struct {
+			Principal  string `json:"principal" binding:"required"`
+			Permission string `json:"permission" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": "principal and permission are required"})
+			return
+		}
+		actor := PrincipalFromContext(c).ID()
+		grant, err := a.Grant(c.Param("cid"), req.Principal, req.Permission, actor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": err.Error()})
+			return
+		}
+		audit.Record(actor, audit.ActionFileGrant, grant.CID, map[string]interface{}{
+			"principal":  grant.Principal,
+			"permission": grant.Permission,
+		})
+		c.JSON(http.StatusOK, gin.H{"code": http.StatusOK, "message": "Access granted", "grant": grant})
+	})
+
+	r.DELETE("/files/:cid/grants/:principal", RequireScope(ScopeUpload), requireOwner, func(c *gin.Context) {
+		if err := a.Revoke(c.Param("cid"), c.Param("principal")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": err.Error()})
+			return
+		}
+		audit.Record(PrincipalFromContext(c).ID(), audit.ActionFileRevoke, c.Param("cid"), map[string]interface{}{
+			"principal": c.Param("principal"),
+		})
+		c.JSON(http.StatusOK, gin.H{"code": http.StatusOK, "message": "Access revoked"})
+	})
+}
@@ -2,25 +2,19 @@ package auth
 
 import (
 	"bufio"
-	"crypto/rand"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"strings"
 	"sync"
 
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
-	"github.com/gin-gonic/gin"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 var (
-	// token holds the currently active authentication token.
-	token string
-	// tokenLock protects concurrent access to the token.
-	tokenLock sync.Mutex
-
 	// walletAddress holds the EVM wallet address.
 	walletAddress string
 	// walletLock protects concurrent access to the wallet address.
@@ -28,8 +22,13 @@ var (
 
 	// ks is the global keystore instance.
 	ks *keystore.KeyStore
+	// walletAccount is the keystore account unlocked by InitializeWallet.
+	walletAccount accounts.Account
 )
 
+// ErrWalletLocked is returned when signing before InitializeWallet succeeded.
+var ErrWalletLocked = errors.New("wallet keystore is not initialized")
+
 // InitializeWallet initializes the wallet using the provided configuration.
 // In production, walletConfig is the path to the keystore directory.
 // It loads the wallet, unlocks the first account using the password from the environment variable,
@@ -58,7 +57,10 @@ func InitializeWallet(walletConfig string) error {
 	}
 
 	// Store the wallet address globally.
+	walletLock.Lock()
+	walletAccount = account
 	walletAddress = account.Address.Hex()
+	walletLock.Unlock()
 	log.Println("[INFO] Wallet initialized and unlocked for account:", walletAddress)
 	return nil
 }
@@ -93,48 +95,24 @@ func LoadWalletAddress() string {
 	return walletAddress
 }
 
-// GenerateToken creates a secure authentication token for the node.
-// This token can be used to authenticate API requests.
-func GenerateToken() string {
-	tokenLock.Lock()
-	defer tokenLock.Unlock()
-
-	bytes := make([]byte, 32)
-	if _, err := rand.Read(bytes); err != nil {
-		log.Fatalf("Failed to generate token: %v", err)
+// WalletAccountAddress returns the address of the unlocked keystore account.
+func WalletAccountAddress() (common.Address, error) {
+	walletLock.Lock()
+	defer walletLock.Unlock()
+	if ks == nil || walletAccount == (accounts.Account{}) {
+		return common.Address{}, ErrWalletLocked
 	}
-	token = hex.EncodeToString(bytes)
-	log.Println("[INFO] New authentication token generated:", token)
-	return token
-}
-
-// ValidateToken checks whether a provided token exactly matches the node's token.
-func ValidateToken(providedToken string) bool {
-	tokenLock.Lock()
-	defer tokenLock.Unlock()
-	return providedToken == token
+	return walletAccount.Address, nil
 }
 
-// ValidateRequest is a Gin middleware that validates incoming requests based on the Authorization header.
-// The expected header format is: "Bearer <token>"
-func ValidateRequest(c *gin.Context) {
-	providedToken := c.GetHeader("Authorization")
-	if providedToken == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: No token provided"})
-		c.Abort()
-		return
-	}
-
-	// If the header is in "Bearer <token>" format, extract the token.
-	if len(providedToken) > 7 && strings.HasPrefix(providedToken, "Bearer ") {
-		providedToken = providedToken[7:]
-	}
-
-	if !ValidateToken(providedToken) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: Invalid token"})
-		c.Abort()
-		return
+// SignWalletHash signs a 32-byte hash with the unlocked keystore account. The
+// signature is in [R || S || V] form with V of 0 or 1.
+func SignWalletHash(hash []byte) ([]byte, error) {
+	walletLock.Lock()
+	account, store := walletAccount, ks
+	walletLock.Unlock()
+	if store == nil || account == (accounts.Account{}) {
+		return nil, ErrWalletLocked
 	}
-
-	c.Next() // Proceed if the token is valid.
+	return store.SignHash(account, hash)
 }
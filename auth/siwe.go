@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/audit"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// sessionPrefix marks wallet session tokens issued after Sign-In with Ethereum.
+	sessionPrefix = "dvs_"
+	// DefaultSessionTTL is how long a wallet session token is valid.
+	DefaultSessionTTL = time.Hour
+	// nonceTTL is how long a sign-in nonce may be used.
+	nonceTTL = 5 * time.Minute
+	// maxPendingNonces bounds the number of outstanding nonces.
+	maxPendingNonces = 10000
+)
+
+// DefaultWalletScopes are granted to allowed wallets unless the operator
+// chooses otherwise. Administration always requires an API token.
+var DefaultWalletScopes = []string{ScopeDelete, ScopeRead, ScopeUpload}
+
+// ErrWalletNotAllowed is returned when a wallet that the operator has not
+// allowed tries to sign in.
+var ErrWalletNotAllowed = errors.New("wallet is not allowed to sign in")
+
+// WalletMember is a wallet the operator allowed to sign in, together with the
+// scopes its sessions receive.
+type WalletMember struct {
+	Address   string    `gorm:"size:42;primaryKey" json:"address"`
+	Scopes    string    `gorm:"size:255" json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TableName sets the database table for allowed wallets.
+func (WalletMember) TableName() string {
+	return "wallet_members"
+}
+
+// AllowWallet lets address sign in with the given scopes, replacing any
+// scopes it had before. The admin scope cannot be granted to wallets.
+func (s *TokenStore) AllowWallet(address common.Address, scopes []string) (*WalletMember, error) {
+	if hasScope(scopes, ScopeAdmin) {
+		return nil, errors.New("wallets cannot be granted the admin scope")
+	}
+	m := &WalletMember{Address: address.Hex(), Scopes: strings.Join(scopes, ",")}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scopes"}),
+	}).Create(m).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to allow wallet %s: %v", m.Address, err)
+	}
+	return m, nil
+}
+
+// RemoveWallet stops address from signing in and ends its open sessions.
+func (s *TokenStore) RemoveWallet(address common.Address) error {
+	res := s.db.Delete(&WalletMember{}, "address = ?", address.Hex())
+	if res.Error != nil {
+		return fmt.Errorf("failed to remove wallet %s: %v", address.Hex(), res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("wallet %s is not allowed", address.Hex())
+	}
+	return s.db.Delete(&WalletSession{}, "address = ?", address.Hex()).Error
+}
+
+// Wallets returns the allowed wallets.
+func (s *TokenStore) Wallets() ([]WalletMember, error) {
+	var members []WalletMember
+	err := s.db.Order("address").Find(&members).Error
+	return members, err
+}
+
+// walletScopes returns the scopes of an allowed wallet, or ErrWalletNotAllowed.
+func (s *TokenStore) walletScopes(address string) ([]string, error) {
+	var members []WalletMember
+	if err := s.db.Where("address = ?", address).Limit(1).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, ErrWalletNotAllowed
+	}
+	return strings.Split(members[0].Scopes, ","), nil
+}
+
+// WalletSession is a short-lived session token bound to a wallet address.
+// Only the SHA-256 hash of the token is stored.
+type WalletSession struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Hash      string    `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Address   string    `gorm:"size:42;index;not null" json:"address"`
+	Scopes    string    `gorm:"size:255" json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+}
+
+// TableName sets the database table for wallet sessions.
+func (WalletSession) TableName() string {
+	return "wallet_sessions"
+}
+
+// IssueSession creates a session token for an allowed wallet valid for ttl,
+// carrying the wallet's scopes. Expired sessions are removed at the same time.
+func (s *TokenStore) IssueSession(address common.Address, ttl time.Duration) (string, *WalletSession, error) {
+	scopes, err := s.walletScopes(address.Hex())
+	if err != nil {
+		return "", nil, err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate session token: %v", err)
+	}
+	plaintext := sessionPrefix + hex.EncodeToString(raw)
+	session := &WalletSession{
+		Hash:      hashToken(plaintext),
+		Address:   address.Hex(),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.db.Create(session).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to store session: %v", err)
+	}
+	s.db.Where("expires_at < ?", time.Now()).Delete(&WalletSession{})
+	return plaintext, session, nil
+}
+
+// ValidateSession looks up an unexpired wallet session token of a wallet that
+// is still allowed. The session carries the wallet's current scopes.
+func (s *TokenStore) ValidateSession(plaintext string) (*WalletSession, error) {
+	var session WalletSession
+	if err := s.db.Where("hash = ?", hashToken(plaintext)).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	scopes, err := s.walletScopes(session.Address)
+	if errors.Is(err, ErrWalletNotAllowed) {
+		return nil, ErrTokenRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.Scopes = strings.Join(scopes, ",")
+	return &session, nil
+}
+
+// -----------------------------------------------------------------------------
+// EIP-4361 Messages
+// -----------------------------------------------------------------------------
+
+// SIWEMessage holds the fields of an EIP-4361 Sign-In with Ethereum message.
+type SIWEMessage struct {
+	Domain         string
+	Address        common.Address
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+}
+
+// String formats the message as specified by EIP-4361.
+func (m *SIWEMessage) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s wants you to sign in with your Ethereum account:\n%s\n\n", m.Domain, m.Address.Hex())
+	if m.Statement != "" {
+		fmt.Fprintf(&b, "%s\n", m.Statement)
+	}
+	fmt.Fprintf(&b, "\nURI: %s\nVersion: %s\nChain ID: %d\nNonce: %s\nIssued At: %s",
+		m.URI, m.Version, m.ChainID, m.Nonce, m.IssuedAt.UTC().Format(time.RFC3339))
+	if m.ExpirationTime != nil {
+		fmt.Fprintf(&b, "\nExpiration Time: %s", m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if m.NotBefore != nil {
+		fmt.Fprintf(&b, "\nNot Before: %s", m.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// ParseSIWEMessage parses an EIP-4361 message. Optional fields this node does
+// not use (Request ID, Resources) are accepted and ignored.
+func ParseSIWEMessage(text string) (*SIWEMessage, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) < 2 {
+		return nil, errors.New("message too short")
+	}
+	const header = " wants you to sign in with your Ethereum account:"
+	if !strings.HasSuffix(lines[0], header) {
+		return nil, errors.New("missing sign-in header")
+	}
+	m := &SIWEMessage{Domain: strings.TrimSuffix(lines[0], header)}
+	if !common.IsHexAddress(lines[1]) {
+		return nil, fmt.Errorf("invalid address %q", lines[1])
+	}
+	m.Address = common.HexToAddress(lines[1])
+
+	// The statement, if any, sits between the blank lines after the address.
+	i := 2
+	if i < len(lines) && lines[i] == "" {
+		i++
+	}
+	if i < len(lines) && !strings.HasPrefix(lines[i], "URI: ") {
+		m.Statement = lines[i]
+		i++
+	}
+	for ; i < len(lines); i++ {
+		key, value, ok := strings.Cut(lines[i], ": ")
+		if !ok {
+			continue
+		}
+		var err error
+		switch key {
+		case "URI":
+			m.URI = value
+		case "Version":
+			m.Version = value
+		case "Chain ID":
+			m.ChainID, err = strconv.ParseInt(value, 10, 64)
+		case "Nonce":
+			m.Nonce = value
+		case "Issued At":
+			m.IssuedAt, err = time.Parse(time.RFC3339, value)
+		case "Expiration Time":
+			var t time.Time
+			t, err = time.Parse(time.RFC3339, value)
+			m.ExpirationTime = &t
+		case "Not Before":
+			var t time.Time
+			t, err = time.Parse(time.RFC3339, value)
+			m.NotBefore = &t
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	if m.URI == "" || m.Nonce == "" || m.IssuedAt.IsZero() {
+		return nil, errors.New("message lacks URI, nonce or issue time")
+	}
+	if m.Version != "1" {
+		return nil, fmt.Errorf("unsupported version %q", m.Version)
+	}
+	return m, nil
+}
+
+// RecoverPersonalSign returns the address that produced a personal_sign
+// (EIP-191) signature over message.
+func RecoverPersonalSign(message string, signature []byte) (common.Address, error) {
+	if len(signature) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("signature must be %d bytes", crypto.SignatureLength)
+	}
+	sig := make([]byte, len(signature))
+	copy(sig, signature)
+	// Wallets return V as 27 or 28; go-ethereum expects 0 or 1.
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to recover signer: %v", err)
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// -----------------------------------------------------------------------------
+// Sign-In Service
+// -----------------------------------------------------------------------------
+
+// SIWEService implements the Sign-In with Ethereum flow: it hands out
+// single-use nonces, verifies signed messages and issues session tokens.
+type SIWEService struct {
+	store *TokenStore
+	// Domain must match the message domain. It is required: the request's
+	// Host header is chosen by the client and cannot bind messages to the node.
+	Domain string
+	// ChainID, if not zero, must match the message chain ID.
+	ChainID    int64
+	SessionTTL time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewSIWEService creates a sign-in service issuing sessions from store.
+func NewSIWEService(store *TokenStore, domain string, chainID int64) *SIWEService {
+	return &SIWEService{
+		store:      store,
+		Domain:     domain,
+		ChainID:    chainID,
+		SessionTTL: DefaultSessionTTL,
+		nonces:     make(map[string]time.Time),
+	}
+}
+
+// NewNonce returns a fresh nonce valid for nonceTTL.
+func (s *SIWEService) NewNonce() (string, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(raw)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for n, expires := range s.nonces {
+		if now.After(expires) {
+			delete(s.nonces, n)
+		}
+	}
+	if len(s.nonces) >= maxPendingNonces {
+		return "", errors.New("too many pending sign-in requests")
+	}
+	s.nonces[nonce] = now.Add(nonceTTL)
+	return nonce, nil
+}
+
+// consumeNonce removes the nonce and reports whether it was valid.
+func (s *SIWEService) consumeNonce(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires, ok := s.nonces[nonce]
+	delete(s.nonces, nonce)
+	return ok && time.Now().Before(expires)
+}
+
+// ErrNoDomain is returned when sign-in is attempted without a configured domain.
+var ErrNoDomain = errors.New("sign-in domain is not configured")
+
+// Verify checks a signed sign-in message for the service's domain and returns
+// its signer, which must be an allowed wallet. The nonce is consumed even if
+// verification fails.
+func (s *SIWEService) Verify(message string, signature []byte) (common.Address, error) {
+	if s.Domain == "" {
+		return common.Address{}, ErrNoDomain
+	}
+	m, err := ParseSIWEMessage(message)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("malformed sign-in message: %v", err)
+	}
+	if !s.consumeNonce(m.Nonce) {
+		return common.Address{}, errors.New("unknown or expired nonce")
+	}
+	if m.Domain != s.Domain {
+		return common.Address{}, fmt.Errorf("message is for domain %q, expected %q", m.Domain, s.Domain)
+	}
+	if s.ChainID != 0 && m.ChainID != s.ChainID {
+		return common.Address{}, fmt.Errorf("message is for chain %d, expected %d", m.ChainID, s.ChainID)
+	}
+	now := time.Now()
+	if m.IssuedAt.After(now.Add(time.Minute)) {
+		return common.Address{}, errors.New("message issued in the future")
+	}
+	if m.ExpirationTime != nil && now.After(*m.ExpirationTime) {
+		return common.Address{}, errors.New("message expired")
+	}
+	if m.NotBefore != nil && now.Before(*m.NotBefore) {
+		return common.Address{}, errors.New("message not yet valid")
+	}
+	signer, err := RecoverPersonalSign(message, signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if signer != m.Address {
+		return common.Address{}, fmt.Errorf("message signed by %s, not %s", signer.Hex(), m.Address.Hex())
+	}
+	if _, err := s.store.walletScopes(signer.Hex()); err != nil {
+		return common.Address{}, fmt.Errorf("%s: %w", signer.Hex(), err)
+	}
+	return signer, nil
+}
+
+// RegisterRoutes adds the unauthenticated sign-in endpoints to r:
+//
+//	GET  /auth/nonce  returns a nonce to embed in the message
+//	POST /auth/siwe   exchanges {"message", "signature"} for a session token
+//
+// Only wallets allowed with TokenStore.AllowWallet can sign in. Without a
+// configured domain no routes are added and sign-in is disabled.
+func (s *SIWEService) RegisterRoutes(r gin.IRouter) {
+	if s.Domain == "" {
+		log.Println("[WARN] Sign-in with Ethereum disabled; set SIWE_DOMAIN to enable it.")
+		return
+	}
+	r.GET("/auth/nonce", func(c *gin.Context) {
+		nonce, err := s.NewNonce()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"code": http.StatusServiceUnavailable, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"nonce": nonce, "expiresIn": int(nonceTTL.Seconds())})
+	})
+
+	r.POST("/auth/siwe", func(c *gin.Context) {
+		var req struct {
+			Message   string `json:"message" binding:"required"`
+			Signature string `json:"signature" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": "message and signature are required"})
+			return
+		}
+		sig, err := hex.DecodeString(strings.TrimPrefix(req.Signature, "0x"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": "signature is not hex encoded"})
+			return
+		}
+		address, err := s.Verify(req.Message, sig)
+		if err != nil {
+			log.Printf("[WARN] Sign-in with Ethereum rejected: %v", err)
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrWalletNotAllowed) {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, gin.H{"code": status, "message": err.Error()})
+			return
+		}
+		token, session, err := s.store.IssueSession(address, s.SessionTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": err.Error()})
+			return
+		}
+		log.Printf("[INFO] Wallet %s signed in", session.Address)
+		audit.Record(PrincipalWallet+":"+session.Address, audit.ActionWalletLogin, session.Address, map[string]interface{}{
+			"domain":    s.Domain,
+			"expiresAt": session.ExpiresAt,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"token":     token,
+			"address":   session.Address,
+			"scopes":    session.Scopes,
+			"expiresAt": session.ExpiresAt,
+		})
+	})
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// API token scopes. ScopeAdmin grants every other scope.
+const (
+	ScopeRead   = "read"
+	ScopeUpload = "upload"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin"
+)
+
+// AllScopes lists the valid token scopes.
+var AllScopes = []string{ScopeRead, ScopeUpload, ScopeDelete, ScopeAdmin}
+
+const (
+	// tokenPrefix marks DesVault API tokens so they are easy to recognise in configs and logs.
+	tokenPrefix = "dv_"
+	// lastUsedResolution limits how often the last-used timestamp is written.
+	lastUsedResolution = time.Minute
+	// contextPrincipalKey is the gin context key holding the authenticated principal.
+	contextPrincipalKey = "auth.principal"
+)
+
+// Errors returned by token validation.
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// APIToken is a named API token. Only the SHA-256 hash of the token is stored.
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"size:64;uniqueIndex;not null" json:"name"`
+	Hash       string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Hint       string     `gorm:"size:16" json:"hint"` // First characters of the token, for identification
+	Scopes     string     `gorm:"size:255" json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// TableName sets the database table for API tokens.
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// ScopeList returns the token's scopes.
+func (t *APIToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return nil
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// HasScope reports whether the token grants scope.
+func (t *APIToken) HasScope(scope string) bool {
+	return hasScope(t.ScopeList(), scope)
+}
+
+// hasScope reports whether scopes grant scope.
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the token is neither revoked nor expired.
+func (t *APIToken) Active() bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt))
+}
+
+// hashToken returns the hex-encoded SHA-256 hash under which a token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseScopes validates a comma-separated scope list and returns it normalised.
+func ParseScopes(list string) ([]string, error) {
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" || seen[s] {
+			continue
+		}
+		valid := false
+		for _, known := range AllScopes {
+			valid = valid || s == known
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown scope %q (valid: %s)", s, strings.Join(AllScopes, ", "))
+		}
+		seen[s] = true
+		scopes = append(scopes, s)
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// -----------------------------------------------------------------------------
+// Token Store
+// -----------------------------------------------------------------------------
+
+// TokenStore manages API tokens in the database.
+type TokenStore struct {
+	db *gorm.DB
+}
+
+// NewTokenStore returns a token store backed by db. The api_tokens table must
+// have been migrated.
+func NewTokenStore(db *gorm.DB) *TokenStore {
+	return &TokenStore{db: db}
+}
+
+// Create issues a new token with the given scopes. A ttl of zero creates a
+// token that never expires. The plaintext token is returned only here.
+func (s *TokenStore) Create(name string, scopes []string, ttl time.Duration) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errors.New("token name is required")
+	}
+	scopes, err := ParseScopes(strings.Join(scopes, ","))
+	if err != nil {
+		return "", nil, err
+	}
+	var count int64
+	if err := s.db.Model(&APIToken{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return "", nil, err
+	}
+	if count > 0 {
+		return "", nil, fmt.Errorf("a token named %q already exists", name)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+	plaintext := tokenPrefix + hex.EncodeToString(raw)
+	record := &APIToken{
+		Name:   name,
+		Hash:   hashToken(plaintext),
+		Hint:   plaintext[:len(tokenPrefix)+6],
+		Scopes: strings.Join(scopes, ","),
+	}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		record.ExpiresAt = &expires
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to store token: %v", err)
+	}
+	return plaintext, record, nil
+}
+
+// List returns every token, including revoked and expired ones, oldest first.
+func (s *TokenStore) List() ([]APIToken, error) {
+	var tokens []APIToken
+	err := s.db.Order("created_at").Find(&tokens).Error
+	return tokens, err
+}
+
+// Count returns the number of active tokens.
+func (s *TokenStore) Count() (int64, error) {
+	var count int64
+	err := s.db.Model(&APIToken{}).
+		Where("revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now()).
+		Count(&count).Error
+	return count, err
+}
+
+// Revoke revokes the named token.
+func (s *TokenStore) Revoke(name string) error {
+	res := s.db.Model(&APIToken{}).
+		Where("name = ? AND revoked_at IS NULL", name).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("no active token named %q", name)
+	}
+	return nil
+}
+
+// Validate looks up a plaintext token and records its use.
+func (s *TokenStore) Validate(plaintext string) (*APIToken, error) {
+	if !strings.HasPrefix(plaintext, tokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	var t APIToken
+	if err := s.db.Where("hash = ?", hashToken(plaintext)).First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if t.RevokedAt != nil {
+		return nil, ErrTokenRevoked
+	}
+	if !t.Active() {
+		return nil, ErrTokenExpired
+	}
+	now := time.Now()
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > lastUsedResolution {
+		s.db.Model(&APIToken{}).Where("id = ?", t.ID).Update("last_used_at", now)
+		t.LastUsedAt = &now
+	}
+	return &t, nil
+}
+
+// -----------------------------------------------------------------------------
+// Principals and Middleware
+// -----------------------------------------------------------------------------
+
+// Principal kinds.
+const (
+	PrincipalToken  = "token"
+	PrincipalWallet = "wallet"
+)
+
+// Principal is an authenticated API caller: a named API token or a wallet
+// address that signed in with Ethereum.
+type Principal struct {
+	Kind   string
+	Name   string // Token name or checksummed wallet address
+	Scopes []string
+}
+
+// ID returns the principal's stable identifier, e.g. "token:ci" or "wallet:0xAb...".
+func (p *Principal) ID() string {
+	return p.Kind + ":" + p.Name
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	return hasScope(p.Scopes, scope)
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// Authenticate resolves a bearer credential, either an API token or a wallet
+// session token, to a principal.
+func (s *TokenStore) Authenticate(credential string) (*Principal, error) {
+	if strings.HasPrefix(credential, sessionPrefix) {
+		session, err := s.ValidateSession(credential)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{Kind: PrincipalWallet, Name: session.Address, Scopes: strings.Split(session.Scopes, ",")}, nil
+	}
+	t, err := s.Validate(credential)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Kind: PrincipalToken, Name: t.Name, Scopes: t.ScopeList()}, nil
+}
+
+// ValidateRequest returns a Gin middleware that authenticates the bearer
+// credential against the store and makes the caller available through
+// PrincipalFromContext.
+func (s *TokenStore) ValidateRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := bearerToken(c)
+		if provided == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    http.StatusUnauthorized,
+				"message": "Invalid or missing Authorization header",
+			})
+			return
+		}
+		principal, err := s.Authenticate(provided)
+		if err != nil {
+			status := http.StatusUnauthorized
+			message := "Invalid or expired token"
+			if !errors.Is(err, ErrInvalidToken) && !errors.Is(err, ErrTokenExpired) && !errors.Is(err, ErrTokenRevoked) {
+				status = http.StatusInternalServerError
+				message = "Token lookup failed"
+			}
+			c.AbortWithStatusJSON(status, gin.H{"code": status, "message": message})
+			return
+		}
+		c.Set(contextPrincipalKey, principal)
+		c.Next()
+	}
+}
+
+// RequireScope returns a Gin middleware that rejects requests whose principal
+// lacks scope. It must run after ValidateRequest.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := PrincipalFromContext(c)
+		if p == nil || !p.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": fmt.Sprintf("Caller lacks the %q scope", scope),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// PrincipalFromContext returns the caller authenticated by ValidateRequest, or nil.
+func PrincipalFromContext(c *gin.Context) *Principal {
+	if v, ok := c.Get(contextPrincipalKey); ok {
+		if p, ok := v.(*Principal); ok {
+			return p
+		}
+	}
+	return nil
+}
@@ -3,7 +3,13 @@ package cli
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"log"
 	"math/rand"
@@ -18,16 +24,22 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ArguableExorcist8/desvault-storage-node/audit"
 	"github.com/ArguableExorcist8/desvault-storage-node/auth"
 	"github.com/ArguableExorcist8/desvault-storage-node/encryption"
+	"github.com/ArguableExorcist8/desvault-storage-node/localapi"
 	"github.com/ArguableExorcist8/desvault-storage-node/network"
+	"github.com/ArguableExorcist8/desvault-storage-node/p2p"
+	"github.com/ArguableExorcist8/desvault-storage-node/reputation"
 	"github.com/ArguableExorcist8/desvault-storage-node/rewards"
 	"github.com/ArguableExorcist8/desvault-storage-node/setup"
 	"github.com/ArguableExorcist8/desvault-storage-node/storage"
 	"github.com/ArguableExorcist8/desvault-storage-node/utils"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/gin-gonic/gin"
-	"github.com/quic-go/quic-go"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/spf13/cobra"
 	"golang.org/x/time/rate"
 	"gorm.io/driver/postgres"
@@ -47,28 +59,30 @@ const (
 )
 
 var (
-	port = getEnv("PORT", "8080")
-	db   *gorm.DB
+	port         = getEnv("PORT", "8080")
+	localAPIAddr = getEnv("LOCAL_API_ADDR", "127.0.0.1:8081")
+	db           *gorm.DB
+	repairer     *p2p.Repairer
+	tokenStore   *auth.TokenStore
+	access       *auth.AccessControl
+	ledger       *rewards.Ledger
+
+	reputationService *reputation.Service
 )
 
 // -----------------------------------------------------------------------------
 // Database Models and Converters
 // -----------------------------------------------------------------------------
 
-type FileMetadataModel struct {
-	CID       string         `gorm:"column:cid;primaryKey;not null;size:255" json:"cid"`
-	FileName  string         `gorm:"size:255" json:"fileName"`
-	Note      string         `gorm:"size:255" json:"note"`
-	FileSize  string         `gorm:"size:255" json:"fileSize"`
-	Shards    datatypes.JSON `gorm:"type:jsonb" json:"shards"`
-	CreatedAt time.Time      `json:"createdAt"`
-}
+// FileMetadataModel is the database record of an uploaded file.
+type FileMetadataModel = storage.FileMetadataModel
 
 type FileMetadataResponse struct {
 	CID       string          `json:"cid"`
 	FileName  string          `json:"fileName"`
 	Note      string          `json:"note"`
 	FileSize  string          `json:"fileSize"`
+	Owner     string          `json:"owner"`
 	CreatedAt time.Time       `json:"createdAt"`
 	Shards    []storage.Shard `json:"shards"`
 }
@@ -83,6 +97,7 @@ func modelToResponse(model FileMetadataModel) (FileMetadataResponse, error) {
 		FileName:  model.FileName,
 		Note:      model.Note,
 		FileSize:  model.FileSize,
+		Owner:     model.Owner,
 		CreatedAt: model.CreatedAt,
 		Shards:    shards,
 	}, nil
@@ -111,15 +126,6 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func generateAuthToken() string {
-	token, err := utils.GenerateSecureToken(32)
-	if err != nil {
-		log.Printf("[ERROR] Failed to generate auth token: %v", err)
-		return fmt.Sprintf("%x", time.Now().UnixNano())
-	}
-	return token
-}
-
 func generateCID() string {
 	cid, err := utils.GenerateCID()
 	if err != nil {
@@ -152,18 +158,70 @@ func formatFileSize(size int64) string {
 // -----------------------------------------------------------------------------
 
 func initDB() {
+	if err := openDB(); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	log.Println("[INFO] Database initialized successfully for storage node.")
+}
+
+// openDB connects to and migrates the database and sets up the stores and
+// the audit log that depend on it.
+func openDB() error {
 	dbURL := getEnv("DATABASE_URL", "host=localhost user=postgres password=postgres dbname=desvault_node port=5432 sslmode=disable")
-	var err error
-	db, err = gorm.Open(postgres.Open(dbURL), &gorm.Config{
+	conn, err := gorm.Open(postgres.Open(dbURL), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 	})
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to connect to database: %v", err)
+		return fmt.Errorf("failed to connect to database: %v", err)
 	}
-	if err := db.AutoMigrate(&FileMetadataModel{}); err != nil {
-		log.Fatalf("[ERROR] Failed to auto-migrate database: %v", err)
+	if err := conn.AutoMigrate(&FileMetadataModel{}, &reputation.PeerScore{}, &auth.APIToken{}, &auth.WalletSession{}, &auth.WalletMember{}, &auth.FileGrant{}, &audit.Entry{}, &rewards.Epoch{}); err != nil {
+		return fmt.Errorf("failed to auto-migrate database: %v", err)
+	}
+	db = conn
+	tokenStore = auth.NewTokenStore(db)
+	access = auth.NewAccessControl(db)
+	auditLog := audit.New(db)
+	if anchor, err := encryption.AuditAnchor(); err == nil {
+		auditLog.SetAnchor(anchor)
+	} else {
+		log.Printf("[WARN] Audit log head will not be anchored: %v", err)
+	}
+	audit.SetDefault(auditLog)
+	nodeID, err := encryption.NodePeerID()
+	if err != nil {
+		return fmt.Errorf("failed to load node identity: %v", err)
+	}
+	ledger = rewards.NewLedger(db, nodeID.String())
+	return nil
+}
+
+// localActor identifies the operator running a CLI command in the audit log.
+func localActor() string {
+	name := os.Getenv("USER")
+	if name == "" {
+		name = "unknown"
+	}
+	return "local:" + name
+}
+
+// openAuditLog connects commands that do not otherwise need the database to
+// it, so their changes can be audited. If it is unavailable the change is
+// applied anyway and a warning is printed.
+func openAuditLog() bool {
+	if db == nil {
+		if err := openDB(); err != nil {
+			log.Printf("[WARN] Changes will not be recorded in the audit log: %v", err)
+			return false
+		}
+	}
+	return true
+}
+
+// recordLocal audits a change made from the command line.
+func recordLocal(action, target string, details map[string]interface{}) {
+	if openAuditLog() {
+		audit.Record(localActor(), action, target, details)
 	}
-	log.Println("[INFO] Database initialized successfully for storage node.")
 }
 
 // -----------------------------------------------------------------------------
@@ -208,24 +266,26 @@ func secureHeadersMiddleware() gin.HandlerFunc {
 	}
 }
 
-func authMiddleware(c *gin.Context) {
-	token := c.GetHeader("Authorization")
-	if !strings.HasPrefix(token, "Bearer ") {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"code":    http.StatusUnauthorized,
-			"message": "Invalid or missing Authorization header",
-		})
+// ensureAdminToken creates an admin token on first start, when no active
+// token exists, and prints it once. It cannot be retrieved later.
+func ensureAdminToken() {
+	count, err := tokenStore.Count()
+	if err != nil {
+		log.Printf("[ERROR] Failed to count API tokens: %v", err)
 		return
 	}
-	token = strings.TrimPrefix(token, "Bearer ")
-	if !auth.ValidateToken(token) {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"code":    http.StatusUnauthorized,
-			"message": "Invalid or expired token",
-		})
+	if count > 0 {
+		log.Printf("[INFO] %d active API tokens. Manage them with 'desvault token'.", count)
 		return
 	}
-	c.Next()
+	name := fmt.Sprintf("admin-%d", time.Now().Unix())
+	plaintext, t, err := tokenStore.Create(name, []string{auth.ScopeAdmin}, 0)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create admin token: %v", err)
+		return
+	}
+	audit.Record("node", audit.ActionTokenCreate, t.Name, map[string]interface{}{"scopes": t.Scopes})
+	fmt.Printf("Created admin API token %q (shown only once): %s\n", name, plaintext)
 }
 
 // -----------------------------------------------------------------------------
@@ -328,9 +388,8 @@ func startIPFSDaemon() error {
 // Node Startup and Service Functions
 // -----------------------------------------------------------------------------
 
-func startNode(ctx context.Context) {
-	authToken := generateAuthToken()
-	log.Printf("[INFO] New authentication token generated: %s", authToken)
+func startNode(ctx context.Context, ads *network.AutoDiscoveryService) {
+	ensureAdminToken()
 	fmt.Println("Node ONLINE")
 	fmt.Printf("Region: %s\n", setup.GetRegion())
 	fmt.Printf("Uptime: %s\n", setup.GetUptime())
@@ -350,10 +409,6 @@ func startNode(ctx context.Context) {
 		log.Println("[INFO] Running as regular node. Discovering seed nodes...")
 	}
 
-	ads, err := network.InitializeNode(ctx)
-	if err != nil {
-		log.Fatalf("[ERROR] Failed to initialize networking: %v", err)
-	}
 	log.Printf("[INFO] Node started with Peer ID: %s", ads.Host.ID().String())
 
 	// Wait for seed nodes if necessary.
@@ -382,15 +437,15 @@ func startNode(ctx context.Context) {
 	fmt.Printf("Allocated Storage: %d GB\n", storageGB)
 	fmt.Printf("Total Uptime: %s\n", setup.GetUptime())
 
-	// Announce storage contribution.
-	if err := ads.AnnounceStorage(storageGB); err != nil {
-		log.Printf("[ERROR] Failed to announce storage: %v", err)
-	} else {
-		log.Printf("[INFO] Announced %d GB of storage to the network.", storageGB)
+	// Track other nodes' storage offers and announce our own contribution.
+	if err := ads.SubscribeStorageAnnouncements(ctx); err != nil {
+		log.Printf("[ERROR] Failed to subscribe to storage announcements: %v", err)
 	}
+	ads.StartStorageAnnouncements(ctx)
+	log.Printf("[INFO] Announcing %d GB of storage to the network.", storageGB)
 
 	log.Println("[INFO] Storage service started.")
-	log.Printf("[INFO] Node fully operational. Auth Token: %s", authToken)
+	log.Println("[INFO] Node fully operational.")
 }
 
 func startAPIServer() {
@@ -401,7 +456,7 @@ func startAPIServer() {
 	router.Use(rateLimitMiddleware())
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
 		if c.Request.Method == "OPTIONS" {
@@ -411,10 +466,13 @@ func startAPIServer() {
 		c.Next()
 	})
 
-	authorized := router.Group("/", authMiddleware)
+	siweChainID, _ := strconv.ParseInt(getEnv("SIWE_CHAIN_ID", "0"), 10, 64)
+	auth.NewSIWEService(tokenStore, os.Getenv("SIWE_DOMAIN"), siweChainID).RegisterRoutes(router)
+
+	authorized := router.Group("/", tokenStore.ValidateRequest())
 
 	// API endpoints
-	authorized.POST("/upload", func(c *gin.Context) {
+	authorized.POST("/upload", auth.RequireScope(auth.ScopeUpload), func(c *gin.Context) {
 		file, err := c.FormFile("file")
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": fmt.Sprintf("File not provided: %v", err)})
@@ -451,11 +509,31 @@ func startAPIServer() {
 		}
 		model.Note = note
 		model.FileSize = fileSizeStr
+		model.Owner = auth.PrincipalFromContext(c).ID()
 		model.CreatedAt = time.Now()
 		if err := db.Create(&model).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
 			return
 		}
+		audit.Record(model.Owner, audit.ActionFileUpload, model.CID, map[string]interface{}{
+			"fileName": model.FileName,
+			"size":     file.Size,
+			"shards":   len(metadata.Shards),
+		})
+		// This node holds every shard it uploaded; announce them and let the repair loop replicate them.
+		if ads := network.GetAutoDiscoveryService(); ads != nil {
+			for _, shard := range metadata.Shards {
+				if err := ads.ProvideShard(c.Request.Context(), shard.ID); err != nil {
+					log.Printf("[WARN] %v", err)
+				}
+			}
+		}
+		if repairer != nil {
+			selfID := network.GetNodePeerID()
+			for _, shard := range metadata.Shards {
+				repairer.Track(shard.ID, shard.CID, []string{selfID})
+			}
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"code":          http.StatusOK,
 			"message":       "File uploaded successfully",
@@ -466,9 +544,13 @@ func startAPIServer() {
 		})
 	})
 
-	authorized.GET("/files", func(c *gin.Context) {
+	authorized.GET("/peers/reputation", auth.RequireScope(auth.ScopeRead), func(c *gin.Context) {
+		c.JSON(http.StatusOK, reputationService.Snapshot())
+	})
+
+	authorized.GET("/files", auth.RequireScope(auth.ScopeRead), func(c *gin.Context) {
 		var models []FileMetadataModel
-		if err := db.Find(&models).Error; err != nil {
+		if err := access.Visible(db, auth.PrincipalFromContext(c)).Find(&models).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
 			return
 		}
@@ -484,15 +566,9 @@ func startAPIServer() {
 		c.JSON(http.StatusOK, gin.H{"files": responses})
 	})
 
-	authorized.GET("/download/:cid", func(c *gin.Context) {
-		cid := c.Param("cid")
-		var model FileMetadataModel
-		if err := db.First(&model, "cid = ?", cid).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": "File metadata not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
-			}
+	authorized.GET("/download/:cid", auth.RequireScope(auth.ScopeRead), func(c *gin.Context) {
+		model, ok := storage.LoadFileFor(c, db, access, c.Param("cid"), auth.PermRead)
+		if !ok {
 			return
 		}
 		var shards []storage.Shard
@@ -511,9 +587,47 @@ func startAPIServer() {
 			return
 		}
 		defer os.Remove(outputPath)
+		audit.Record(auth.PrincipalFromContext(c).ID(), audit.ActionFileDownload, model.CID, map[string]interface{}{"fileName": model.FileName})
 		c.FileAttachment(outputPath, model.FileName)
 	})
 
+	authorized.DELETE("/files/:cid", auth.RequireScope(auth.ScopeDelete), func(c *gin.Context) {
+		model, ok := storage.LoadFileFor(c, db, access, c.Param("cid"), auth.PermWrite)
+		if !ok {
+			return
+		}
+		if err := db.Delete(&model).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
+			return
+		}
+		if err := access.RevokeAll(model.CID); err != nil {
+			log.Printf("[WARN] Failed to remove grants of %s: %v", model.CID, err)
+		}
+		var untrack func(string)
+		if repairer != nil {
+			untrack = repairer.Untrack
+		}
+		storage.ReleaseShards(db, model, untrack)
+		audit.Record(auth.PrincipalFromContext(c).ID(), audit.ActionFileDelete, model.CID, map[string]interface{}{
+			"fileName": model.FileName,
+			"owner":    model.Owner,
+		})
+		c.JSON(http.StatusOK, gin.H{"code": http.StatusOK, "message": "File deleted"})
+	})
+
+	access.RegisterGrantRoutes(authorized, func(cid string) (string, error) {
+		var model FileMetadataModel
+		if err := db.Select("owner").First(&model, "cid = ?", cid).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return "", auth.ErrNotFound
+			}
+			return "", err
+		}
+		return model.Owner, nil
+	})
+
+	audit.Default().RegisterRoutes(authorized.Group("/", auth.RequireScope(auth.ScopeAdmin)))
+
 	addr := ":" + port
 	log.Printf("[INFO] Starting API server on %s", addr)
 	if err := router.Run(addr); err != nil {
@@ -551,8 +665,12 @@ func ShowNodeStatus() {
 	}
 	uptime := setup.GetUptime()
 	status := getNodeStatus()
-	pointsPerHour := storageGB * 100
-	totalPoints := int(time.Since(setup.GetStartTimeOrNow()).Hours()) * pointsPerHour
+	totalPoints := "unavailable"
+	if db != nil || openDB() == nil {
+		if _, provisional, err := ledger.TotalPoints(); err == nil {
+			totalPoints = fmt.Sprintf("%d pts", provisional)
+		}
+	}
 
 	fmt.Println("\n=====================")
 	fmt.Println(" DesVault Node Status")
@@ -560,7 +678,47 @@ func ShowNodeStatus() {
 	fmt.Printf("Status: %s\n", status)
 	fmt.Printf("Total Uptime: %s\n", uptime)
 	fmt.Printf("Storage Contributed: %d GB\n", storageGB)
-	fmt.Printf("Total Points: %d pts\n", totalPoints)
+	fmt.Printf("Total Points: %s\n", totalPoints)
+
+	var local localapi.StatusResponse
+	if err := fetchLocalAPI("/status", &local); err != nil {
+		fmt.Println("Node Process: not reachable (is the node running?)")
+		return
+	}
+	fmt.Printf("Peer ID: %s\n", local.PeerID)
+	fmt.Printf("Reachability: %s\n", local.Reachability)
+	fmt.Printf("Connected Peers: %d\n", len(local.Peers))
+	if r := local.Resources; r != nil {
+		fmt.Printf("Connections: %d in / %d out (watermarks %d-%d)\n", r.ConnsInbound, r.ConnsOutbound, r.LowWater, r.HighWater)
+		fmt.Printf("Streams: %d in / %d out, FDs: %d, Memory: %.1f MB\n", r.StreamsInbound, r.StreamsOutbound, r.FileDescriptors, float64(r.MemoryBytes)/(1<<20))
+	}
+	connected := 0
+	for _, b := range local.Bootstrap {
+		if b.Connected {
+			connected++
+		}
+	}
+	var gossip []network.TopicValidationStats
+	if err := fetchLocalAPI("/pubsub/metrics", &gossip); err == nil {
+		for _, t := range gossip {
+			var rejected uint64
+			for _, n := range t.Rejected {
+				rejected += n
+			}
+			fmt.Printf("PubSub %s: %d accepted, %d rejected %v\n", t.Topic, t.Accepted, rejected, t.Rejected)
+		}
+	}
+	fmt.Printf("Bootstrap Peers: %d/%d connected\n", connected, len(local.Bootstrap))
+	for _, b := range local.Bootstrap {
+		state := "connected"
+		if !b.Connected {
+			state = fmt.Sprintf("not connected after %d attempts", b.Attempts)
+			if b.LastError != "" {
+				state += ": " + b.LastError
+			}
+		}
+		fmt.Printf("  - %s (%s)\n", b.PeerID, state)
+	}
 }
 
 // -----------------------------------------------------------------------------
@@ -580,13 +738,9 @@ var runCmd = &cobra.Command{
 		printCLIBanner()
 		setup.FirstTimeSetup()
 
-		home, err := os.UserHomeDir()
+		desvaultDir, err := setup.GetDesVaultDir()
 		if err != nil {
-			log.Fatalf("[ERROR] Could not determine home directory: %v", err)
-		}
-		desvaultDir := filepath.Join(home, ".desvault")
-		if err := os.MkdirAll(desvaultDir, 0755); err != nil {
-			log.Fatalf("[ERROR] Failed to create directory: %v", err)
+			log.Fatalf("[ERROR] %v", err)
 		}
 		pidFile := filepath.Join(desvaultDir, "node.pid")
 		pid := os.Getpid()
@@ -605,13 +759,103 @@ var runCmd = &cobra.Command{
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		ads, err := network.InitializeNode(ctx)
+		cfg, err := setup.LoadConfig()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load configuration: %v", err)
+		}
+		extraPeers, _ := cmd.Flags().GetStringSlice("bootstrap")
+		cfg.BootstrapPeers = append(cfg.BootstrapPeers, extraPeers...)
+		if relay, _ := cmd.Flags().GetBool("relay-service"); relay {
+			cfg.EnableRelayService = true
+		}
+		if portMap, _ := cmd.Flags().GetBool("nat-portmap"); portMap {
+			cfg.EnableNATPortMap = true
+		}
+		if cmd.Flags().Changed("tcp-port") {
+			cfg.TCPPort, _ = cmd.Flags().GetInt("tcp-port")
+		}
+		if cmd.Flags().Changed("quic-port") {
+			cfg.QUICPort, _ = cmd.Flags().GetInt("quic-port")
+		}
+		if webTransport, _ := cmd.Flags().GetBool("webtransport"); webTransport {
+			cfg.EnableWebTransport = true
+		}
+		if noIPv6, _ := cmd.Flags().GetBool("no-ipv6"); noIPv6 {
+			cfg.EnableIPv6 = false
+		}
+		if cmd.Flags().Changed("conn-low") {
+			cfg.ConnLowWater, _ = cmd.Flags().GetInt("conn-low")
+		}
+		if cmd.Flags().Changed("conn-high") {
+			cfg.ConnHighWater, _ = cmd.Flags().GetInt("conn-high")
+		}
+		if private, _ := cmd.Flags().GetBool("private"); private {
+			cfg.PrivateNetwork = true
+		}
+
+		reputationService, err = reputation.NewService(db)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load peer reputation: %v", err)
+		}
+		reputationService.Start(ctx)
+
+		// Generate TLS material on first run and keep it renewed.
+		certs, err := encryption.NewCertManager(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load TLS certificate: %v", err)
+		}
+		certs.Start(ctx)
+
+		ads, err := network.InitializeNode(ctx, cfg, reputationService)
 		if err != nil {
 			log.Fatalf("[ERROR] Failed to initialize network: %v", err)
 		}
+		ads.ServeQUICShards(ctx, cfg.ShardServicePort, certs)
+		go ads.Start(ctx)
+		go ads.WatchACL(ctx)
+		ads.StartHeartbeats(ctx)
 
-		go startNode(ctx)
+		repairer, err = p2p.NewRepairer(ads.Host, nil, p2p.DefaultRepairConfig())
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to initialize shard repair: %v", err)
+		}
+		repairer.Providers = ads
+		repairer.Transport = ads
+		repairer.Health = ads.Health
+		repairer.Candidates = func() []p2p.PeerCandidate {
+			return p2p.CandidatesFromCapacity(ads.Capacity.Snapshot(), reputationService)
+		}
+		repairer.Start(ctx)
+
+		// Record reward epochs and, when a wallet keystore is configured, sign
+		// a claim for each epoch as it is finalized.
+		var onFinalize func(*rewards.Epoch)
+		if cfg.WalletConfig.KeystoreDir != "" {
+			domain, err := claimDomain(cfg)
+			if err == nil {
+				err = auth.InitializeWallet(cfg.WalletConfig.KeystoreDir)
+			}
+			if err != nil {
+				log.Printf("[WARN] Reward claims disabled: %v", err)
+			} else {
+				onFinalize = func(e *rewards.Epoch) { issueClaim(e, domain) }
+			}
+		}
+		ledger.Start(ctx, func() rewards.Sample {
+			storageGB, _ := setup.ReadStorageAllocation()
+			return rewards.Sample{StorageGB: storageGB, UsedBytes: storage.GetUsedBytes(), NodeType: cfg.NodeType}
+		}, onFinalize)
+
+		ads.StartReprovider(ctx)
+		storage.SetRemoteShardFetcher(func(shardID string) ([]byte, error) {
+			fetchCtx, fetchCancel := context.WithTimeout(ctx, 2*time.Minute)
+			defer fetchCancel()
+			return ads.FetchShardFromProviders(fetchCtx, shardID)
+		})
+
+		go startNode(ctx, ads)
 		go startAPIServer()
+		go localapi.StartServer(localAPIAddr)
 
 		// Register with master API
 		if err := registerWithMasterAPI(ads); err != nil {
@@ -624,6 +868,9 @@ var runCmd = &cobra.Command{
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		<-sigChan
 		log.Println("[INFO] Shutting down node gracefully...")
+		if err := reputationService.Persist(); err != nil {
+			log.Printf("[ERROR] Failed to persist reputation: %v", err)
+		}
 	},
 }
 
@@ -687,6 +934,7 @@ var storageCmd = &cobra.Command{
 		if newStorage > 0 {
 			setup.SetStorageAllocation(newStorage)
 			fmt.Printf("[INFO] Storage allocation updated to %d GB\n", newStorage)
+			recordLocal(audit.ActionConfigChange, "storage", map[string]interface{}{"from": storageGB, "to": newStorage})
 		}
 	},
 }
@@ -748,54 +996,832 @@ var rewardsCmd = &cobra.Command{
 	Short: "Check earned rewards",
 	Run: func(cmd *cobra.Command, args []string) {
 		printCLIBanner()
-		nodeID := utils.GetNodeID()
-		CheckRewards(nodeID)
+		nodeID, err := encryption.NodePeerID()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load node identity: %v", err)
+		}
+		limit, _ := cmd.Flags().GetInt("epochs")
+		CheckRewards(nodeID.String(), limit)
+	},
+}
+
+var rewardsReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Recompute the points of unfinalized epochs from their recorded inputs",
+	Run: func(cmd *cobra.Command, args []string) {
+		initDB()
+		changed, drifted, err := ledger.Replay()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to replay reward ledger: %v", err)
+		}
+		fmt.Printf("[INFO] Replayed reward ledger: %d epochs changed.\n", changed)
+		if len(drifted) > 0 {
+			fmt.Printf("[WARN] Finalized epochs %v would earn different points under the current formula; their recorded points and claims are kept.\n", drifted)
+		}
+		if changed > 0 {
+			recordLocal(audit.ActionConfigChange, "reward-ledger", map[string]interface{}{"op": "replay", "changed": changed})
+		}
 	},
 }
 
-func CheckRewards(nodeID string) {
-	rewardsData, err := rewards.LoadRewards()
+// claimDomain returns the EIP-712 domain reward claims are signed in.
+func claimDomain(cfg *setup.Config) (rewards.ClaimDomain, error) {
+	domain := rewards.ClaimDomain{ChainID: cfg.WalletConfig.ChainID}
+	if contract := cfg.WalletConfig.RewardsContract; contract != "" {
+		if !common.IsHexAddress(contract) {
+			return domain, fmt.Errorf("rewardsContract %q is not an address", contract)
+		}
+		domain.VerifyingContract = common.HexToAddress(contract)
+	}
+	return domain, nil
+}
+
+// issueClaim signs and saves the claim for a finalized epoch unless it
+// already exists.
+func issueClaim(e *rewards.Epoch, domain rewards.ClaimDomain) {
+	if path, err := rewards.ClaimPath(e.Epoch); err == nil {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+	}
+	st, err := e.Statement()
+	var identity libp2pcrypto.PrivKey
+	if err == nil {
+		identity, err = encryption.LoadOrCreateIdentity()
+	}
+	if err == nil {
+		var path string
+		if _, path, err = rewards.IssueClaim(st, domain, identity); err == nil {
+			log.Printf("[INFO] Signed reward claim for epoch %d to %s", e.Epoch, path)
+			return
+		}
+	}
+	log.Printf("[ERROR] Failed to issue reward claim for epoch %d: %v", e.Epoch, err)
+}
+
+var rewardsClaimCmd = &cobra.Command{
+	Use:   "claim",
+	Short: "Sign a reward claim with the node wallet",
+	Long:  "Sign the reward claim of a finalized ledger epoch as EIP-712 typed data with the first account of the configured wallet keystore (walletConfig.keystoreDir or DESVAULT_WALLET_KEYSTORE, unlocked with WALLET_PASSWORD). The node identity signs the wallet address, so the claim ties the wallet to this node.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := setup.LoadConfig()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load configuration: %v", err)
+		}
+		domain, err := claimDomain(cfg)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		if cfg.WalletConfig.KeystoreDir == "" {
+			log.Fatalf("[ERROR] No wallet keystore configured; set walletConfig.keystoreDir or DESVAULT_WALLET_KEYSTORE")
+		}
+		if err := auth.InitializeWallet(cfg.WalletConfig.KeystoreDir); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		epoch := rewards.EpochAt(time.Now()) - 1
+		if cmd.Flags().Changed("epoch") {
+			epoch, _ = cmd.Flags().GetUint64("epoch")
+		}
+		initDB()
+		e, err := ledger.Get(epoch)
+		if err != nil {
+			log.Fatalf("[ERROR] No reward epoch %d in the ledger: %v", epoch, err)
+		}
+		st, err := e.Statement()
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		identity, err := encryption.LoadOrCreateIdentity()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load node identity: %v", err)
+		}
+		claim, path, err := rewards.IssueClaim(st, domain, identity)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		if out, _ := cmd.Flags().GetString("out"); out != "" {
+			if err := rewards.SaveClaim(claim, out); err != nil {
+				log.Fatalf("[ERROR] %v", err)
+			}
+			path = out
+		}
+		printClaim(claim)
+		fmt.Printf("[INFO] Claim written to %s\n", path)
+	},
+}
+
+var rewardsVerifyCmd = &cobra.Command{
+	Use:   "verify [file]",
+	Short: "Verify a signed reward claim",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		claim, err := rewards.LoadClaim(args[0])
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		if _, err := rewards.VerifyClaim(claim); err != nil {
+			fmt.Printf("[ERROR] %v\n", err)
+			os.Exit(1)
+		}
+		printClaim(claim)
+		fmt.Println("[INFO] Signature valid.")
+	},
+}
+
+// printClaim prints the statement of a reward claim.
+func printClaim(c *rewards.Claim) {
+	st := c.Statement
+	start, end := rewards.EpochBounds(st.Epoch)
+	fmt.Printf("Node ID:       %s\n", st.NodeID)
+	fmt.Printf("Wallet:        %s\n", st.Wallet.Hex())
+	fmt.Printf("Epoch:         %d (%s to %s)\n", st.Epoch, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	fmt.Printf("Total Points:  %d\n", st.Points)
+	fmt.Printf("Shards Held:   %d (%s)\n", st.Proofs.Shards, formatFileSize(int64(st.Proofs.Bytes)))
+	fmt.Printf("Shards Root:   %s\n", st.Proofs.ShardsRoot.Hex())
+	fmt.Printf("Proofs:        %d passed, %d failed\n", st.Proofs.AuditsPassed, st.Proofs.AuditsFailed)
+	fmt.Printf("Digest:        %s\n", c.Digest.Hex())
+}
+
+func CheckRewards(nodeID string, limit int) {
+	initDB()
+	nodeLedger := rewards.NewLedger(db, nodeID)
+	finalized, provisional, err := nodeLedger.TotalPoints()
 	if err != nil {
 		log.Printf("[ERROR] Failed to load rewards: %v", err)
 		fmt.Println("[ERROR] Could not retrieve rewards.")
 		return
 	}
-	reward, exists := rewardsData[nodeID]
-	if !exists {
+	epochs, err := nodeLedger.Epochs(limit)
+	if err != nil || len(epochs) == 0 {
 		fmt.Println("[INFO] No rewards found for this node.")
 		return
 	}
-	fmt.Printf("\n🎖️ [Reward Summary for Node %s]\n", reward.NodeID)
-	fmt.Printf("🔹 Node Type: %s\n", reward.NodeType)
-	fmt.Printf("🔹 Base Points: %d\n", reward.BasePoints)
-	fmt.Printf("🔹 Multiplier: %.1fx\n", reward.Multiplier)
-	fmt.Printf("🏆 Total Points: %.2f\n", reward.TotalPoints)
+	latest := epochs[0]
+	fmt.Printf("\n🎖️ [Reward Summary for Node %s]\n", nodeID)
+	fmt.Printf("🔹 Node Type: %s\n", latest.NodeType)
+	fmt.Printf("🔹 Multiplier: %.1fx\n", latest.Multiplier)
+	fmt.Printf("🏆 Total Points: %d (%d including the current epoch)\n", finalized, provisional)
+	fmt.Printf("\n%-8s %-12s %-10s %-10s %-10s %-8s %-10s %-10s %s\n", "EPOCH", "DATE", "UPTIME", "STORAGE", "AUDITS", "MULT", "BASE", "POINTS", "TOTAL")
+	for _, e := range epochs {
+		start, _ := rewards.EpochBounds(e.Epoch)
+		date := start.Format("2006-01-02")
+		if !e.Finalized() {
+			date += "*"
+		}
+		fmt.Printf("%-8d %-12s %-10s %-10s %-10s %-8.1f %-10d %-10d %d\n",
+			e.Epoch, date, (time.Duration(e.UptimeSeconds) * time.Second).String(), fmt.Sprintf("%d GB", e.StorageGB),
+			fmt.Sprintf("%d/%d", e.AuditsPassed, e.AuditsPassed+e.AuditsFailed), e.Multiplier, e.BasePoints, e.Points, e.CumulativePoints)
+	}
+	fmt.Println("* epoch still running; its points are provisional")
+}
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "Show storage capacity announced by peers",
+	Run: func(cmd *cobra.Command, args []string) {
+		printCLIBanner()
+		var peers []network.PeerCapacity
+		if err := fetchLocalAPI("/peers/capacity", &peers); err != nil {
+			fmt.Printf("[ERROR] Could not reach the running node: %v\n", err)
+			return
+		}
+		if len(peers) == 0 {
+			fmt.Println("[INFO] No storage announcements received yet.")
+			return
+		}
+		fmt.Printf("%-54s %-10s %12s %12s %-8s %s\n", "PEER", "REGION", "CAPACITY", "USED", "VERSION", "LAST SEEN")
+		for _, p := range peers {
+			fmt.Printf("%-54s %-10s %12s %12s %-8s %s ago\n",
+				p.PeerID, p.Region, formatFileSize(p.CapacityBytes), formatFileSize(p.UsedBytes),
+				p.SoftwareVersion, time.Since(p.ReceivedAt).Round(time.Second))
+		}
+	},
+}
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Manage the peer allow/deny list",
+	Long:  "Manage the peer allow/deny list. Entries are peer IDs or CIDR ranges; a running node applies changes within a few seconds.",
+}
+
+// updateACL loads the ACL, applies fn and saves and audits the result. op
+// names the change to entry.
+func updateACL(op, entry string, fn func(acl *network.ACL) error) {
+	acl, err := network.LoadACL()
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if err := fn(acl); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if err := network.SaveACL(acl); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	recordLocal(audit.ActionConfigChange, "acl", map[string]interface{}{"op": op, "entry": entry})
+}
+
+var aclAllowCmd = &cobra.Command{
+	Use:   "allow [peer-id|cidr]",
+	Short: "Add a peer ID or CIDR range to the allow list",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		updateACL("allow", args[0], func(acl *network.ACL) error { return acl.Allow(args[0]) })
+		fmt.Printf("[INFO] Allowed %s\n", args[0])
+	},
+}
+
+var aclDenyCmd = &cobra.Command{
+	Use:   "deny [peer-id|cidr]",
+	Short: "Add a peer ID or CIDR range to the deny list",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		updateACL("deny", args[0], func(acl *network.ACL) error { return acl.Deny(args[0]) })
+		fmt.Printf("[INFO] Denied %s\n", args[0])
+	},
+}
+
+var aclRemoveCmd = &cobra.Command{
+	Use:   "remove [peer-id|cidr]",
+	Short: "Remove an entry from the allow and deny lists",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		updateACL("remove", args[0], func(acl *network.ACL) error {
+			if !acl.Remove(args[0]) {
+				return fmt.Errorf("%s is not in the ACL", args[0])
+			}
+			return nil
+		})
+		fmt.Printf("[INFO] Removed %s\n", args[0])
+	},
+}
+
+var aclListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show the allow/deny list and active temporary bans",
+	Run: func(cmd *cobra.Command, args []string) {
+		acl, err := network.LoadACL()
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		printList := func(title string, entries []string) {
+			fmt.Printf("%s:\n", title)
+			if len(entries) == 0 {
+				fmt.Println("  (none)")
+			}
+			for _, e := range entries {
+				fmt.Printf("  - %s\n", e)
+			}
+		}
+		printList("Allowed peers", acl.AllowPeers)
+		printList("Allowed CIDRs", acl.AllowCIDRs)
+		printList("Denied peers", acl.DenyPeers)
+		printList("Denied CIDRs", acl.DenyCIDRs)
+
+		var bans []network.TempBan
+		if err := fetchLocalAPI("/peers/bans", &bans); err != nil {
+			fmt.Println("Temporary bans: unavailable (is the node running?)")
+			return
+		}
+		fmt.Println("Temporary bans:")
+		if len(bans) == 0 {
+			fmt.Println("  (none)")
+		}
+		for _, b := range bans {
+			fmt.Printf("  - %s for %s (%s)\n", b.PeerID, time.Until(b.Until).Round(time.Second), b.Reason)
+		}
+	},
+}
+
+var peersReputationCmd = &cobra.Command{
+	Use:   "reputation",
+	Short: "Show reputation scores of known peers",
+	Run: func(cmd *cobra.Command, args []string) {
+		printCLIBanner()
+		var scores []reputation.PeerScore
+		if err := fetchLocalAPI("/peers/reputation", &scores); err != nil {
+			fmt.Printf("[ERROR] Could not reach the running node: %v\n", err)
+			return
+		}
+		if len(scores) == 0 {
+			fmt.Println("[INFO] No peer observations recorded yet.")
+			return
+		}
+		fmt.Printf("%-54s %6s %8s %8s %9s %9s %5s\n", "PEER", "SCORE", "UPTIME", "AUDITS", "TRANSFERS", "LATENCY", "VIOL")
+		for _, s := range scores {
+			fmt.Printf("%-54s %6.3f %4.0f/%-3.0f %4.0f/%-3.0f %5.0f/%-3.0f %7.0fms %5.1f\n",
+				s.PeerID, s.Score, s.HeartbeatsOK, s.Heartbeats,
+				s.AuditsPassed, s.AuditsPassed+s.AuditsFailed,
+				s.TransfersOK, s.TransfersOK+s.TransfersFailed,
+				s.AvgLatencyMillis, s.Violations)
+		}
+	},
+}
+
+var peersHealthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "Show liveness and round-trip times of known peers",
+	Run: func(cmd *cobra.Command, args []string) {
+		printCLIBanner()
+		var health []network.PeerHealth
+		if err := fetchLocalAPI("/peers/health", &health); err != nil {
+			fmt.Printf("[ERROR] Could not reach the running node: %v\n", err)
+			return
+		}
+		if len(health) == 0 {
+			fmt.Println("[INFO] No peers seen yet.")
+			return
+		}
+		fmt.Printf("%-54s %-12s %10s %10s %8s %s\n", "PEER", "STATE", "RTT", "AVG RTT", "FAILURES", "LAST SEEN")
+		for _, h := range health {
+			fmt.Printf("%-54s %-12s %10s %10s %8d %s ago\n",
+				h.PeerID, h.State, h.LastRTT.Round(time.Millisecond), h.AvgRTT.Round(time.Millisecond),
+				h.Failures, time.Since(h.LastSeen).Round(time.Second))
+		}
+	},
+}
+
+// fetchLocalAPI queries the running node's local API and decodes the JSON response into out.
+func fetchLocalAPI(path string, out interface{}) error {
+	resp, err := http.Get("http://" + localAPIAddr + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("local API returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens",
+	Long:  "Manage API tokens. Tokens are stored hashed in the node database and carry scopes: read, upload, delete and admin.",
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a named API token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		scopeList, _ := cmd.Flags().GetString("scopes")
+		ttl, _ := cmd.Flags().GetDuration("expires")
+		scopes, err := auth.ParseScopes(scopeList)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		initDB()
+		plaintext, t, err := tokenStore.Create(args[0], scopes, ttl)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to create token: %v", err)
+		}
+		audit.Record(localActor(), audit.ActionTokenCreate, t.Name, map[string]interface{}{"scopes": t.Scopes, "expiresAt": t.ExpiresAt})
+		fmt.Printf("Token %q created with scopes %s.\n", t.Name, t.Scopes)
+		if t.ExpiresAt != nil {
+			fmt.Printf("Expires: %s\n", t.ExpiresAt.Format(time.RFC3339))
+		}
+		fmt.Println("Store it now; it cannot be shown again:")
+		fmt.Println(plaintext)
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Run: func(cmd *cobra.Command, args []string) {
+		initDB()
+		tokens, err := tokenStore.List()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to list tokens: %v", err)
+		}
+		if len(tokens) == 0 {
+			fmt.Println("[INFO] No API tokens.")
+			return
+		}
+		formatTime := func(t *time.Time) string {
+			if t == nil {
+				return "-"
+			}
+			return t.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-24s %-12s %-26s %-8s %-16s %-16s\n", "NAME", "HINT", "SCOPES", "STATUS", "EXPIRES", "LAST USED")
+		for _, t := range tokens {
+			status := "active"
+			switch {
+			case t.RevokedAt != nil:
+				status = "revoked"
+			case !t.Active():
+				status = "expired"
+			}
+			fmt.Printf("%-24s %-12s %-26s %-8s %-16s %-16s\n", t.Name, t.Hint+"...", t.Scopes, status, formatTime(t.ExpiresAt), formatTime(t.LastUsedAt))
+		}
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke [name]",
+	Short: "Revoke an API token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		initDB()
+		if err := tokenStore.Revoke(args[0]); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		audit.Record(localActor(), audit.ActionTokenRevoke, args[0], nil)
+		fmt.Printf("[INFO] Revoked token %s\n", args[0])
+	},
+}
+
+var walletCmd = &cobra.Command{
+	Use:   "wallet",
+	Short: "Manage wallets allowed to sign in with Ethereum",
+	Long:  "Manage the wallets allowed to sign in with Ethereum. Only listed wallets receive sessions, with the scopes granted here; the admin scope requires an API token.",
+}
+
+var walletAllowCmd = &cobra.Command{
+	Use:   "allow [address]",
+	Short: "Allow a wallet to sign in, or change its scopes",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !common.IsHexAddress(args[0]) {
+			log.Fatalf("[ERROR] %q is not a wallet address", args[0])
+		}
+		scopeList, _ := cmd.Flags().GetString("scopes")
+		scopes, err := auth.ParseScopes(scopeList)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		initDB()
+		m, err := tokenStore.AllowWallet(common.HexToAddress(args[0]), scopes)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		recordLocal(audit.ActionConfigChange, "wallet:"+m.Address, map[string]interface{}{"op": "allow", "scopes": m.Scopes})
+		fmt.Printf("[INFO] Wallet %s may sign in with scopes %s\n", m.Address, m.Scopes)
+	},
+}
+
+var walletRemoveCmd = &cobra.Command{
+	Use:   "remove [address]",
+	Short: "Stop a wallet from signing in and end its sessions",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !common.IsHexAddress(args[0]) {
+			log.Fatalf("[ERROR] %q is not a wallet address", args[0])
+		}
+		initDB()
+		address := common.HexToAddress(args[0])
+		if err := tokenStore.RemoveWallet(address); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		recordLocal(audit.ActionConfigChange, "wallet:"+address.Hex(), map[string]interface{}{"op": "remove"})
+		fmt.Printf("[INFO] Wallet %s removed\n", address.Hex())
+	},
+}
+
+var walletListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List wallets allowed to sign in",
+	Run: func(cmd *cobra.Command, args []string) {
+		initDB()
+		members, err := tokenStore.Wallets()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to list wallets: %v", err)
+		}
+		if len(members) == 0 {
+			fmt.Println("[INFO] No wallets are allowed to sign in.")
+			return
+		}
+		fmt.Printf("%-44s %-20s %-16s\n", "ADDRESS", "SCOPES", "ADDED")
+		for _, m := range members {
+			fmt.Printf("%-44s %-20s %-16s\n", m.Address, m.Scopes, m.CreatedAt.Format("2006-01-02 15:04"))
+		}
+	},
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query and verify the audit log",
+	Long:  "Query and verify the audit log. Every upload, download, deletion, token change, key rotation and configuration change is recorded in a hash chain whose head is kept in a checkpoint signed by the node identity, so edited, removed or truncated entries are detected by 'desvault audit verify'.",
+}
+
+var auditListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List audit entries, newest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		f := audit.Filter{}
+		f.Actor, _ = cmd.Flags().GetString("actor")
+		f.Action, _ = cmd.Flags().GetString("action")
+		f.Target, _ = cmd.Flags().GetString("target")
+		f.Limit, _ = cmd.Flags().GetInt("limit")
+		if since, _ := cmd.Flags().GetDuration("since"); since > 0 {
+			f.Since = time.Now().Add(-since)
+		}
+		initDB()
+		entries, err := audit.Default().Query(f)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to query audit log: %v", err)
+		}
+		if len(entries) == 0 {
+			fmt.Println("[INFO] No matching audit entries.")
+			return
+		}
+		fmt.Printf("%-6s %-20s %-28s %-16s %-30s %s\n", "SEQ", "TIME", "ACTOR", "ACTION", "TARGET", "DETAILS")
+		for _, e := range entries {
+			fmt.Printf("%-6d %-20s %-28s %-16s %-30s %s\n", e.Seq, e.Time.Local().Format("2006-01-02 15:04:05"), e.Actor, e.Action, e.Target, e.Details)
+		}
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit log's hash chain for tampering",
+	Run: func(cmd *cobra.Command, args []string) {
+		initDB()
+		res, err := audit.Default().Verify()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to verify audit log: %v", err)
+		}
+		if !res.Valid {
+			fmt.Printf("[ERROR] Audit log is broken at entry %d: %s\n", res.BrokenAt, res.Reason)
+			fmt.Printf("[INFO] %d entries before it are intact.\n", res.Entries)
+			os.Exit(1)
+		}
+		fmt.Printf("[INFO] Audit log intact: %d entries.\n", res.Entries)
+		if res.LastHash != "" {
+			fmt.Printf("[INFO] Head hash: %s\n", res.LastHash)
+		}
+	},
+}
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Manage the node's libp2p identity",
+}
+
+var identityShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the node's peer ID and public key",
+	Run: func(cmd *cobra.Command, args []string) {
+		priv, err := encryption.LoadOrCreateIdentity()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load identity: %v", err)
+		}
+		id, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to derive peer ID: %v", err)
+		}
+		pubBytes, err := libp2pcrypto.MarshalPublicKey(priv.GetPublic())
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to marshal public key: %v", err)
+		}
+		path, _ := encryption.IdentityPath()
+		fmt.Printf("Peer ID:    %s\n", id)
+		fmt.Printf("Key Type:   %s\n", priv.Type())
+		fmt.Printf("Public Key: %s\n", base64.StdEncoding.EncodeToString(pubBytes))
+		fmt.Printf("Key File:   %s\n", path)
+	},
+}
+
+var identityExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the node's private key (unencrypted, base64)",
+	Run: func(cmd *cobra.Command, args []string) {
+		raw, err := encryption.ExportIdentity()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to export identity: %v", err)
+		}
+		encoded := base64.StdEncoding.EncodeToString(raw)
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			fmt.Println(encoded)
+			return
+		}
+		if err := os.WriteFile(out, []byte(encoded+"\n"), 0600); err != nil {
+			log.Fatalf("[ERROR] Failed to write %s: %v", out, err)
+		}
+		fmt.Printf("[INFO] Identity exported to %s. Keep this file secret.\n", out)
+	},
+}
+
+var identityRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the node's identity with a new key",
+	Run: func(cmd *cobra.Command, args []string) {
+		priv, err := encryption.RotateIdentity()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to rotate identity: %v", err)
+		}
+		id, _ := peer.IDFromPrivateKey(priv)
+		recordLocal(audit.ActionKeyRotate, "identity", map[string]interface{}{"peerId": id.String()})
+		fmt.Printf("[INFO] New Peer ID: %s\n", id)
+		fmt.Println("[INFO] Rewards are recorded per Peer ID; points earned so far stay with the old one.")
+		fmt.Println("[INFO] Restart the node for the new identity to take effect.")
+	},
+}
+
+var swarmCmd = &cobra.Command{
+	Use:   "swarm",
+	Short: "Manage the private swarm pre-shared key",
+}
+
+var swarmKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate a new swarm key for a private DesVault network",
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := network.GenerateSwarmKey()
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		force, _ := cmd.Flags().GetBool("force")
+		path, err := network.InstallSwarmKey(data, force)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		recordLocal(audit.ActionKeyRotate, "swarm-key", map[string]interface{}{"source": "generated"})
+		fmt.Printf("[INFO] Swarm key written to %s\n", path)
+		fmt.Println("[INFO] Copy it to every node with 'desvault swarm export' / 'desvault swarm import',")
+		fmt.Println("[INFO] then set \"privateNetwork\": true in config.json (or DESVAULT_PRIVATE_NETWORK=1).")
+	},
+}
+
+var swarmExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Print the swarm key so it can be installed on other nodes",
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := network.ReadSwarmKey()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to read swarm key: %v", err)
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			fmt.Print(string(data))
+			return
+		}
+		if err := os.WriteFile(out, data, 0600); err != nil {
+			log.Fatalf("[ERROR] Failed to write %s: %v", out, err)
+		}
+		fmt.Printf("[INFO] Swarm key exported to %s. Share it only over a secure channel.\n", out)
+	},
+}
+
+var swarmImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Install a swarm key generated on another node",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to read %s: %v", args[0], err)
+		}
+		force, _ := cmd.Flags().GetBool("force")
+		path, err := network.InstallSwarmKey(data, force)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		recordLocal(audit.ActionKeyRotate, "swarm-key", map[string]interface{}{"source": args[0]})
+		fmt.Printf("[INFO] Swarm key installed at %s\n", path)
+	},
 }
 
 var tlsCmd = &cobra.Command{
 	Use:   "tls",
-	Short: "Start a secure QUIC channel using TLS",
+	Short: "Serve shards over QUIC with mutual TLS",
+	Long:  "Run the QUIC shard service on its own. 'desvault run' already serves it on shardServicePort; use this command only on hosts that serve shards without the node. Shards stored here are announced in the DHT when the node next reprovides.",
 	Run: func(cmd *cobra.Command, args []string) {
-		certFile := "server.crt"
-		keyFile := "server.key"
-		tlsConfig, err := encryption.CreateTLSConfig(certFile, keyFile)
+		cfg, err := setup.LoadConfig()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load configuration: %v", err)
+		}
+		openAuditLog()
+		certs, err := encryption.NewCertManager(cfg.TLSCertFile, cfg.TLSKeyFile)
 		if err != nil {
-			log.Fatalf("[ERROR] Failed to create TLS config: %v", err)
+			log.Fatalf("[ERROR] Failed to load TLS certificate: %v", err)
 		}
-		quicConfig := &quic.Config{
-			EnableDatagrams: true,
-			KeepAlivePeriod: 30 * time.Second,
+		certs.Start(context.Background())
+		gater, err := network.NewGater()
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load ACL: %v", err)
+		}
+		tlsConfig := encryption.ServerTLSConfig(certs, func(id peer.ID) error {
+			if !gater.PeerAllowed(id) {
+				return fmt.Errorf("peer %s is not allowed by the ACL", id)
+			}
+			return nil
+		})
+		allowStream := func(id peer.ID) error {
+			return gater.CheckStream(id, network.ShardProtocolID)
 		}
-		addr := "0.0.0.0:4242"
-		if err := encryption.SecureChannelWithTLS(addr, tlsConfig, quicConfig); err != nil {
+		go gater.Watch(context.Background(), nil)
+		addr, _ := cmd.Flags().GetString("addr")
+		if err := encryption.SecureChannelWithTLS(addr, tlsConfig, encryption.DefaultQUICConfig(), allowStream); err != nil {
 			log.Fatalf("[ERROR] Secure channel failed: %v", err)
 		}
 	},
 }
 
+var tlsGenCmd = &cobra.Command{
+	Use:   "gen",
+	Short: "Create the node's TLS certificate, or inspect an existing one",
+	Run: func(cmd *cobra.Command, args []string) {
+		if file, _ := cmd.Flags().GetString("inspect"); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				log.Fatalf("[ERROR] Failed to read certificate: %v", err)
+			}
+			block, _ := pem.Decode(data)
+			if block == nil {
+				log.Fatalf("[ERROR] %s does not contain a PEM certificate", file)
+			}
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				log.Fatalf("[ERROR] Failed to parse certificate: %v", err)
+			}
+			printCertificate(leaf, file)
+			return
+		}
+
+		openAuditLog()
+		var cert tls.Certificate
+		var err error
+		if force, _ := cmd.Flags().GetBool("force"); force {
+			var priv libp2pcrypto.PrivKey
+			if priv, err = encryption.LoadOrCreateIdentity(); err != nil {
+				log.Fatalf("[ERROR] Failed to load identity: %v", err)
+			}
+			cert, err = encryption.CreateNodeCertificate(priv)
+		} else {
+			cert, err = encryption.LoadOrCreateNodeCertificate()
+		}
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to create TLS certificate: %v", err)
+		}
+		path, _ := encryption.NodeCertPath()
+		printCertificate(cert.Leaf, path)
+	},
+}
+
+// printCertificate shows the identity and validity of a node certificate.
+func printCertificate(leaf *x509.Certificate, path string) {
+	fingerprint := sha256.Sum256(leaf.Raw)
+	fmt.Printf("Certificate: %s\n", path)
+	fmt.Printf("Subject:     %s\n", leaf.Subject.CommonName)
+	if id, err := encryption.PeerIDFromCertificate(leaf); err == nil {
+		fmt.Printf("Peer ID:     %s (verified)\n", id)
+	} else {
+		fmt.Printf("Peer ID:     invalid (%v)\n", err)
+	}
+	fmt.Printf("Serial:      %x\n", leaf.SerialNumber)
+	fmt.Printf("Not Before:  %s\n", leaf.NotBefore.Format(time.RFC3339))
+	fmt.Printf("Not After:   %s\n", leaf.NotAfter.Format(time.RFC3339))
+	if left := time.Until(leaf.NotAfter); left > 0 {
+		fmt.Printf("Expires In:  %d days\n", int(left.Hours()/24))
+	} else {
+		fmt.Println("Expires In:  expired")
+	}
+	fmt.Printf("SHA-256:     %s\n", hex.EncodeToString(fingerprint[:]))
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
-	rootCmd.AddCommand(runCmd, stopCmd, statusCmd, storageCmd, memeCmd, chatCmd, rewardsCmd, tlsCmd)
+	runCmd.Flags().StringSlice("bootstrap", nil, "additional bootstrap peer multiaddrs (repeatable or comma-separated)")
+	runCmd.Flags().Bool("relay-service", false, "relay traffic for peers behind NAT (for publicly reachable nodes)")
+	runCmd.Flags().Bool("nat-portmap", false, "open the listen port on the local router via UPnP/NAT-PMP")
+	runCmd.Flags().Int("tcp-port", 4001, "libp2p TCP listen port")
+	runCmd.Flags().Int("quic-port", 4001, "libp2p QUIC-v1 listen port (0 disables QUIC)")
+	runCmd.Flags().Bool("webtransport", false, "also listen for WebTransport connections")
+	runCmd.Flags().Bool("no-ipv6", false, "do not listen on IPv6 interfaces")
+	runCmd.Flags().Int("conn-low", 100, "connection manager low watermark")
+	runCmd.Flags().Int("conn-high", 400, "connection manager high watermark")
+	runCmd.Flags().Bool("private", false, "join the private swarm defined by ~/.desvault/swarm.key")
+	identityExportCmd.Flags().String("out", "", "write the exported key to this file instead of stdout")
+	identityCmd.AddCommand(identityShowCmd, identityExportCmd, identityRotateCmd)
+	swarmKeygenCmd.Flags().Bool("force", false, "overwrite an existing swarm key")
+	swarmExportCmd.Flags().String("out", "", "write the key to this file instead of stdout")
+	swarmImportCmd.Flags().Bool("force", false, "overwrite an existing swarm key")
+	swarmCmd.AddCommand(swarmKeygenCmd, swarmExportCmd, swarmImportCmd)
+	peersCmd.AddCommand(peersReputationCmd, peersHealthCmd)
+	aclCmd.AddCommand(aclAllowCmd, aclDenyCmd, aclRemoveCmd, aclListCmd)
+	tlsCmd.Flags().String("addr", "0.0.0.0:4242", "QUIC listen address of the shard service")
+	tlsGenCmd.Flags().Bool("force", false, "replace the existing certificate")
+	tlsGenCmd.Flags().String("inspect", "", "show the details of this PEM certificate instead")
+	tlsCmd.AddCommand(tlsGenCmd)
+	tokenCreateCmd.Flags().String("scopes", auth.ScopeRead, "comma-separated scopes: read, upload, delete, admin")
+	tokenCreateCmd.Flags().Duration("expires", 0, "token lifetime, e.g. 720h (0 never expires)")
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenRevokeCmd)
+	walletAllowCmd.Flags().String("scopes", strings.Join(auth.DefaultWalletScopes, ","), "comma-separated scopes: read, upload, delete")
+	walletCmd.AddCommand(walletAllowCmd, walletRemoveCmd, walletListCmd)
+	auditListCmd.Flags().String("actor", "", "only entries by this actor, e.g. token:ci or local:alice")
+	auditListCmd.Flags().String("action", "", "only entries with this action, e.g. file.delete")
+	auditListCmd.Flags().String("target", "", "only entries for this target, e.g. a file CID")
+	auditListCmd.Flags().Duration("since", 0, "only entries from the last duration, e.g. 24h")
+	auditListCmd.Flags().Int("limit", 50, "maximum number of entries")
+	auditCmd.AddCommand(auditListCmd, auditVerifyCmd)
+	rewardsClaimCmd.Flags().Uint64("epoch", 0, "epoch to claim (default: the last completed epoch)")
+	rewardsClaimCmd.Flags().String("out", "", "also write the claim to this file")
+	rewardsCmd.Flags().Int("epochs", 14, "number of recent epochs to list")
+	rewardsCmd.AddCommand(rewardsClaimCmd, rewardsVerifyCmd, rewardsReplayCmd)
+	rootCmd.AddCommand(runCmd, stopCmd, statusCmd, storageCmd, memeCmd, chatCmd, rewardsCmd, peersCmd, aclCmd, identityCmd, swarmCmd, tlsCmd, tokenCmd, walletCmd, auditCmd)
 	if err := rootCmd.Execute(); err != nil {
 		log.Printf("[ERROR] CLI execution failed: %v", err)
 		os.Exit(1)
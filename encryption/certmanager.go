@@ -0,0 +1,177 @@
+package encryption
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// certCheckInterval is how often the certificate manager checks for expiry
+// and changed operator files.
+const certCheckInterval = time.Hour
+
+// CertManager owns the TLS certificate of the QUIC shard service. It either
+// manages a certificate derived from the node identity, renewing it within
+// CertRenewBefore of expiry, or serves operator-supplied PEM files and reloads
+// them when they change. Operator certificates must be self-signed with the
+// node identity key, as peers verify nothing else. Certificates are handed out through GetCertificate,
+// so a renewal applies to new handshakes without dropping open connections.
+type CertManager struct {
+	certFile, keyFile string // Operator-supplied files; empty in managed mode
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time // Modification times of the loaded operator files
+	keyMod  time.Time
+}
+
+// NewCertManager loads the certificate the service should present. With
+// empty certFile and keyFile the node's own certificate is used, generating
+// it on first run.
+func NewCertManager(certFile, keyFile string) (*CertManager, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("both a TLS certificate and key file must be configured")
+	}
+	m := &CertManager{certFile: certFile, keyFile: keyFile}
+	if err := m.refresh(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Managed reports whether the certificate is derived from the node identity
+// rather than supplied by the operator.
+func (m *CertManager) Managed() bool {
+	return m.certFile == ""
+}
+
+// Certificate returns the current certificate.
+func (m *CertManager) Certificate() *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert
+}
+
+// Leaf returns the parsed current certificate.
+func (m *CertManager) Leaf() *x509.Certificate {
+	return m.Certificate().Leaf
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.Certificate(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (m *CertManager) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return m.Certificate(), nil
+}
+
+// refresh renews the managed certificate when needed, or reloads the
+// operator files when either of them changed since the last load.
+func (m *CertManager) refresh() error {
+	if m.Managed() {
+		current := m.Certificate()
+		if current != nil && time.Until(current.Leaf.NotAfter) > CertRenewBefore {
+			return nil
+		}
+		cert, err := LoadOrCreateNodeCertificate()
+		if err != nil {
+			return err
+		}
+		m.set(&cert, time.Time{}, time.Time{})
+		return nil
+	}
+
+	certInfo, err := os.Stat(m.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read TLS certificate: %v", err)
+	}
+	keyInfo, err := os.Stat(m.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read TLS key: %v", err)
+	}
+	m.mu.RLock()
+	unchanged := m.cert != nil && certInfo.ModTime().Equal(m.certMod) && keyInfo.ModTime().Equal(m.keyMod)
+	m.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate and key: %v", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse TLS certificate: %v", err)
+		}
+	}
+	if err := checkOperatorCertificate(cert.Leaf); err != nil {
+		return fmt.Errorf("TLS certificate %s cannot be used: %v", m.certFile, err)
+	}
+	m.set(&cert, certInfo.ModTime(), keyInfo.ModTime())
+	log.Printf("[INFO] Loaded TLS certificate %s, valid until %s", m.certFile, cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// checkOperatorCertificate ensures an operator certificate passes the peer
+// verification every node applies: it must be self-signed with the node's
+// Ed25519 identity key. CA-issued, RSA and ECDSA certificates are rejected
+// here rather than by every peer at handshake time.
+func checkOperatorCertificate(leaf *x509.Certificate) error {
+	id, err := PeerIDFromCertificate(leaf)
+	if err != nil {
+		return err
+	}
+	priv, err := LoadOrCreateIdentity()
+	if err != nil {
+		return err
+	}
+	self, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	if id != self {
+		return fmt.Errorf("certificate key belongs to %s, not the node identity %s", id, self)
+	}
+	return nil
+}
+
+func (m *CertManager) set(cert *tls.Certificate, certMod, keyMod time.Time) {
+	m.mu.Lock()
+	m.cert = cert
+	m.certMod = certMod
+	m.keyMod = keyMod
+	m.mu.Unlock()
+}
+
+// Start checks the certificate every certCheckInterval until ctx is cancelled.
+// Operator certificates cannot be renewed by the node, so a warning is logged
+// once they are within CertRenewBefore of expiry.
+func (m *CertManager) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(certCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.refresh(); err != nil {
+					log.Printf("[ERROR] Failed to refresh TLS certificate: %v", err)
+					continue
+				}
+				if left := time.Until(m.Leaf().NotAfter); !m.Managed() && left < CertRenewBefore {
+					log.Printf("[WARN] TLS certificate %s expires in %s", m.certFile, left.Round(time.Hour))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertManagerRenewsManagedCertificate(t *testing.T) {
+	testHome(t)
+	m, err := NewCertManager("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Managed() {
+		t.Fatal("manager without files is not managed")
+	}
+	// Nothing to do while the certificate is far from expiry.
+	serial := m.Leaf().SerialNumber
+	if err := m.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Leaf().SerialNumber.Cmp(serial) != 0 {
+		t.Fatal("certificate replaced although it is not due for renewal")
+	}
+
+	// Replace it with one inside the renewal window, on disk and in memory.
+	priv, err := LoadOrCreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring, err := GenerateNodeCertificate(priv, CertRenewBefore/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveNodeCertificate(expiring); err != nil {
+		t.Fatal(err)
+	}
+	m.set(&expiring, time.Time{}, time.Time{})
+
+	if err := m.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	renewed := m.Leaf()
+	if renewed.SerialNumber.Cmp(expiring.Leaf.SerialNumber) == 0 {
+		t.Fatal("expiring certificate was not renewed")
+	}
+	if time.Until(renewed.NotAfter) <= CertRenewBefore {
+		t.Errorf("renewed certificate expires %s, still within the renewal window", renewed.NotAfter)
+	}
+	stored, err := LoadNodeCertificate(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Leaf.SerialNumber.Cmp(renewed.SerialNumber) != 0 {
+		t.Error("renewed certificate was not stored")
+	}
+}
+
+// writeOperatorFiles writes a certificate for the node identity and its key
+// as PEM files and returns the certificate.
+func writeOperatorFiles(t *testing.T, certFile, keyFile string) tls.Certificate {
+	t.Helper()
+	priv, err := LoadOrCreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := GenerateNodeCertificate(priv, DefaultCertValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertManagerReloadsOperatorFiles(t *testing.T) {
+	testHome(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key")
+	first := writeOperatorFiles(t, certFile, keyFile)
+
+	m, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Managed() {
+		t.Fatal("manager with operator files is managed")
+	}
+	if m.Leaf().SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Fatal("operator certificate not loaded")
+	}
+
+	// A changed certificate file is picked up.
+	past := time.Now().Add(-time.Hour)
+	second := writeOperatorFiles(t, certFile, keyFile)
+	os.Chtimes(keyFile, past, past)
+	if err := m.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Leaf().SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("changed certificate file was not reloaded")
+	}
+
+	// So is a changed key file, even if the certificate keeps its mtime.
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third := writeOperatorFiles(t, certFile, keyFile)
+	os.Chtimes(certFile, certInfo.ModTime(), certInfo.ModTime())
+	if err := m.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Leaf().SerialNumber.Cmp(third.Leaf.SerialNumber) != 0 {
+		t.Fatal("changed key file was not reloaded")
+	}
+
+	// A certificate for another identity is refused and the current one kept.
+	otherPriv, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := GenerateNodeCertificate(otherPriv, DefaultCertValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(foreign.PrivateKey)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: foreign.Certificate[0]}), 0644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	os.Chtimes(keyFile, time.Now(), time.Now())
+	if err := m.refresh(); err == nil {
+		t.Fatal("certificate for another identity accepted")
+	}
+	if m.Leaf().SerialNumber.Cmp(third.Leaf.SerialNumber) != 0 {
+		t.Error("refused certificate replaced the current one")
+	}
+}
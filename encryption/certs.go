@@ -0,0 +1,287 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/audit"
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+)
+
+const (
+	// tlsDirName is the directory in the DesVault directory holding TLS material.
+	tlsDirName = "tls"
+	// nodeCertFileName is the node's self-signed certificate. Its private key is
+	// the identity key, so no separate key file is written.
+	nodeCertFileName = "node.crt"
+	// DefaultCertValidity is how long a generated node certificate is valid.
+	DefaultCertValidity = 90 * 24 * time.Hour
+	// CertRenewBefore is the grace window before expiry in which a generated
+	// certificate is replaced, so peers never see an expired certificate.
+	CertRenewBefore = 30 * 24 * time.Hour
+	// certClockSkew backdates certificates to tolerate clock differences between peers.
+	certClockSkew = time.Hour
+)
+
+// TLSDir returns the directory holding the node's TLS material, creating it if needed.
+func TLSDir() (string, error) {
+	dir, err := setup.GetDesVaultDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, tlsDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create TLS directory: %v", err)
+	}
+	return dir, nil
+}
+
+// NodeCertPath returns the location of the node's certificate.
+func NodeCertPath() (string, error) {
+	dir, err := TLSDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, nodeCertFileName), nil
+}
+
+// stdIdentityKey converts the libp2p identity key to a standard Ed25519 key.
+func stdIdentityKey(priv crypto.PrivKey) (ed25519.PrivateKey, error) {
+	if priv.Type() != crypto.Ed25519 {
+		return nil, fmt.Errorf("identity key type %s is not Ed25519", priv.Type())
+	}
+	raw, err := priv.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity key: %v", err)
+	}
+	if len(raw) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("unexpected identity key length %d", len(raw))
+	}
+	return ed25519.PrivateKey(raw), nil
+}
+
+// GenerateNodeCertificate creates a self-signed certificate whose key is the
+// node's Ed25519 identity key, so the certificate proves the peer ID.
+func GenerateNodeCertificate(priv crypto.PrivKey, validity time.Duration) (tls.Certificate, error) {
+	key, err := stdIdentityKey(priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: id.String(), Organization: []string{"DesVault"}},
+		NotBefore:             now.Add(-certClockSkew),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// SaveNodeCertificate writes the certificate to the TLS directory in PEM format.
+func SaveNodeCertificate(cert tls.Certificate) error {
+	path, err := NodeCertPath()
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %v", err)
+	}
+	return nil
+}
+
+// LoadNodeCertificate reads the stored certificate and pairs it with the
+// identity key. It fails if the certificate was issued for another identity.
+func LoadNodeCertificate(priv crypto.PrivKey) (tls.Certificate, error) {
+	path, err := NodeCertPath()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return tls.Certificate{}, fmt.Errorf("%s does not contain a PEM certificate", path)
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	key, err := stdIdentityKey(priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	pub, ok := leaf.PublicKey.(ed25519.PublicKey)
+	if !ok || !bytes.Equal(pub, key.Public().(ed25519.PublicKey)) {
+		return tls.Certificate{}, errors.New("certificate does not match the node identity")
+	}
+	return tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// LoadOrCreateNodeCertificate returns the node's certificate, generating and
+// storing a new one if none exists, the identity changed or the certificate
+// expires within CertRenewBefore.
+func LoadOrCreateNodeCertificate() (tls.Certificate, error) {
+	priv, err := LoadOrCreateIdentity()
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load node identity: %v", err)
+	}
+	cert, err := LoadNodeCertificate(priv)
+	if err == nil && time.Until(cert.Leaf.NotAfter) > CertRenewBefore {
+		return cert, nil
+	}
+	switch {
+	case err == nil:
+		log.Printf("[INFO] Node certificate expires %s, renewing", cert.Leaf.NotAfter.Format(time.RFC3339))
+	case !os.IsNotExist(err):
+		log.Printf("[WARN] Replacing node certificate: %v", err)
+	}
+	return CreateNodeCertificate(priv)
+}
+
+// CreateNodeCertificate generates a new certificate for the identity and
+// stores it. The previous certificate is kept with a .prev suffix.
+func CreateNodeCertificate(priv crypto.PrivKey) (tls.Certificate, error) {
+	cert, err := GenerateNodeCertificate(priv, DefaultCertValidity)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if path, err := NodeCertPath(); err == nil {
+		if _, err := os.Stat(path); err == nil {
+			os.Rename(path, path+".prev")
+		}
+	}
+	if err := SaveNodeCertificate(cert); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Printf("[INFO] Generated TLS certificate for %s, valid until %s", cert.Leaf.Subject.CommonName, cert.Leaf.NotAfter.Format(time.RFC3339))
+	audit.Record("node", audit.ActionKeyRotate, "tls-certificate", map[string]interface{}{
+		"peerId":   cert.Leaf.Subject.CommonName,
+		"serial":   cert.Leaf.SerialNumber.String(),
+		"notAfter": cert.Leaf.NotAfter,
+	})
+	return cert, nil
+}
+
+// -----------------------------------------------------------------------------
+// Peer Verification
+// -----------------------------------------------------------------------------
+
+// PeerIDFromCertificate checks that the certificate is self-signed and
+// currently valid and returns the peer ID of its key.
+func PeerIDFromCertificate(cert *x509.Certificate) (peer.ID, error) {
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return "", fmt.Errorf("certificate is not valid at %s", now.Format(time.RFC3339))
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return "", fmt.Errorf("certificate is not self-signed: %v", err)
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return "", errors.New("certificate key is not Ed25519")
+	}
+	key, err := crypto.UnmarshalEd25519PublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPublicKey(key)
+}
+
+// PeerIDFromConnection returns the verified peer ID of the remote side of a TLS connection.
+func PeerIDFromConnection(state tls.ConnectionState) (peer.ID, error) {
+	if len(state.PeerCertificates) == 0 {
+		return "", errors.New("peer presented no certificate")
+	}
+	return PeerIDFromCertificate(state.PeerCertificates[0])
+}
+
+// verifyPeerCertificate returns a tls.Config callback that accepts a single
+// node certificate whose peer ID satisfies allow. Chain verification is
+// replaced by this check because node certificates are self-signed.
+func verifyPeerCertificate(allow func(peer.ID) error) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) != 1 {
+			return fmt.Errorf("expected one peer certificate, got %d", len(rawCerts))
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse peer certificate: %v", err)
+		}
+		id, err := PeerIDFromCertificate(cert)
+		if err != nil {
+			return err
+		}
+		if allow != nil {
+			return allow(id)
+		}
+		return nil
+	}
+}
+
+// ServerTLSConfig returns a mutual TLS configuration for the QUIC shard
+// service presenting the manager's current certificate. Clients must present a
+// node certificate; if allow is not nil it decides which peer IDs may connect.
+func ServerTLSConfig(certs *CertManager, allow func(peer.ID) error) *tls.Config {
+	return &tls.Config{
+		GetCertificate:        certs.GetCertificate,
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: verifyPeerCertificate(allow),
+		NextProtos:            []string{QUICALPN},
+		MinVersion:            tls.VersionTLS13,
+	}
+}
+
+// ClientTLSConfig returns a mutual TLS configuration for dialing a node whose
+// certificate must prove the expected peer ID.
+func ClientTLSConfig(certs *CertManager, expected peer.ID) *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: certs.GetClientCertificate,
+		// Node certificates are self-signed; the peer ID check below replaces chain verification.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: verifyPeerCertificate(func(id peer.ID) error {
+			if id != expected {
+				return fmt.Errorf("server identity %s does not match expected %s", id, expected)
+			}
+			return nil
+		}),
+		NextProtos: []string{QUICALPN},
+		MinVersion: tls.VersionTLS13,
+	}
+}
@@ -0,0 +1,194 @@
+package encryption
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newTestCertManager returns a manager serving cert, as if loaded from disk.
+func newTestCertManager(cert tls.Certificate) *CertManager {
+	m := &CertManager{}
+	m.set(&cert, time.Time{}, time.Time{})
+	return m
+}
+
+// newTestNode returns an identity key and a certificate for it valid for validity.
+func newTestNode(t *testing.T, validity time.Duration) (crypto.PrivKey, peer.ID, tls.Certificate) {
+	t.Helper()
+	priv, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := GenerateNodeCertificate(priv, validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, id, cert
+}
+
+// handshake runs a mutual TLS handshake over loopback TCP and returns the
+// client and server errors.
+func handshake(t *testing.T, clientConfig, serverConfig *tls.Config) (clientErr, serverErr error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		server := tls.Server(conn, serverConfig)
+		err = server.Handshake()
+		if err == nil {
+			// TLS 1.3 servers verify the client certificate after sending
+			// their last flight; reading the client's byte confirms success.
+			_, err = server.Read(make([]byte, 1))
+		}
+		done <- err
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := tls.Client(conn, clientConfig)
+	clientErr = client.Handshake()
+	if clientErr == nil {
+		_, clientErr = client.Write([]byte{0})
+	}
+	return clientErr, <-done
+}
+
+func TestMutualTLSExpectedPeer(t *testing.T) {
+	_, serverID, serverCert := newTestNode(t, time.Hour)
+	_, clientID, clientCert := newTestNode(t, time.Hour)
+
+	var seen peer.ID
+	serverConfig := ServerTLSConfig(newTestCertManager(serverCert), func(id peer.ID) error {
+		seen = id
+		return nil
+	})
+	clientErr, serverErr := handshake(t, ClientTLSConfig(newTestCertManager(clientCert), serverID), serverConfig)
+	if clientErr != nil {
+		t.Fatalf("client handshake failed: %v", clientErr)
+	}
+	if serverErr != nil {
+		t.Fatalf("server handshake failed: %v", serverErr)
+	}
+	if seen != clientID {
+		t.Errorf("server saw client %s, want %s", seen, clientID)
+	}
+}
+
+func TestMutualTLSWrongPeer(t *testing.T) {
+	_, _, serverCert := newTestNode(t, time.Hour)
+	_, _, clientCert := newTestNode(t, time.Hour)
+	_, otherID, _ := newTestNode(t, time.Hour)
+
+	clientErr, _ := handshake(t, ClientTLSConfig(newTestCertManager(clientCert), otherID),
+		ServerTLSConfig(newTestCertManager(serverCert), nil))
+	if clientErr == nil || !strings.Contains(clientErr.Error(), "does not match expected") {
+		t.Fatalf("client accepted a server that is not the expected peer: %v", clientErr)
+	}
+
+	// The server's allow callback can refuse clients by peer ID.
+	_, serverID, serverCert := newTestNode(t, time.Hour)
+	errDenied := errors.New("denied")
+	_, serverErr := handshake(t, ClientTLSConfig(newTestCertManager(clientCert), serverID),
+		ServerTLSConfig(newTestCertManager(serverCert), func(peer.ID) error { return errDenied }))
+	if !errors.Is(serverErr, errDenied) {
+		t.Fatalf("server returned %v, want %v", serverErr, errDenied)
+	}
+}
+
+func TestMutualTLSExpiredCertificate(t *testing.T) {
+	_, serverID, serverCert := newTestNode(t, time.Hour)
+	_, _, expired := newTestNode(t, -time.Hour)
+
+	if _, err := PeerIDFromCertificate(expired.Leaf); err == nil {
+		t.Error("PeerIDFromCertificate accepted an expired certificate")
+	}
+	_, serverErr := handshake(t, ClientTLSConfig(newTestCertManager(expired), serverID),
+		ServerTLSConfig(newTestCertManager(serverCert), nil))
+	if serverErr == nil || !strings.Contains(serverErr.Error(), "not valid at") {
+		t.Fatalf("server accepted an expired client certificate: %v", serverErr)
+	}
+}
+
+func TestPeerIDFromCertificate(t *testing.T) {
+	_, id, cert := newTestNode(t, time.Hour)
+	got, err := PeerIDFromCertificate(cert.Leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("got peer %s, want %s", got, id)
+	}
+
+	// A certificate signed by another key is not self-signed.
+	otherPriv, _, _ := newTestNode(t, time.Hour)
+	forged := *cert.Leaf
+	otherCert, err := GenerateNodeCertificate(otherPriv, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Signature = otherCert.Leaf.Signature
+	if _, err := PeerIDFromCertificate(&forged); err == nil {
+		t.Error("certificate with a foreign signature accepted")
+	}
+}
+
+func TestLoadNodeCertificateIdentityMismatch(t *testing.T) {
+	testHome(t)
+	priv, _, cert := newTestNode(t, time.Hour)
+	if err := SaveNodeCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNodeCertificate(priv); err != nil {
+		t.Fatalf("certificate rejected for its own identity: %v", err)
+	}
+	other, _, _ := newTestNode(t, time.Hour)
+	if _, err := LoadNodeCertificate(other); err == nil || !strings.Contains(err.Error(), "does not match the node identity") {
+		t.Fatalf("certificate accepted for another identity: %v", err)
+	}
+}
+
+func TestDialShardServiceWrongPeer(t *testing.T) {
+	testHome(t)
+	certs, err := NewCertManager("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := ListenShardService("127.0.0.1:0", ServerTLSConfig(certs, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ServeShardService(listener, nil)
+	defer listener.Close()
+
+	_, otherID, _ := newTestNode(t, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if c, err := DialShardService(ctx, listener.Addr().String(), ClientTLSConfig(certs, otherID), nil); err == nil {
+		c.Close()
+		t.Fatal("client connected to a server with an unexpected peer ID")
+	}
+}
@@ -0,0 +1,211 @@
+package encryption
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/audit"
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+)
+
+// identityFileName is the file in the DesVault directory holding the node key.
+// Like an SSH host key it is protected by its 0600 mode alone: encrypting it
+// with a key stored next to it, readable by everyone, would add nothing.
+const identityFileName = "identity.key"
+
+// IdentityPath returns the location of the node identity key.
+func IdentityPath() (string, error) {
+	dir, err := setup.GetDesVaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, identityFileName), nil
+}
+
+// LoadOrCreateIdentity returns the node's persistent Ed25519 identity key,
+// generating and storing it on first use.
+func LoadOrCreateIdentity() (crypto.PrivKey, error) {
+	priv, err := LoadIdentity()
+	if err == nil {
+		return priv, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	priv, _, err = GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	if err := saveIdentity(priv); err != nil {
+		return nil, err
+	}
+	id, _ := peer.IDFromPrivateKey(priv)
+	log.Printf("[INFO] Generated new node identity: %s", id)
+	return priv, nil
+}
+
+// NodePeerID returns the peer ID of the node's persistent identity, creating
+// the identity on first use.
+func NodePeerID() (peer.ID, error) {
+	priv, err := LoadOrCreateIdentity()
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPrivateKey(priv)
+}
+
+// LoadIdentity reads the stored identity key. The returned error satisfies
+// os.IsNotExist when no identity has been created yet. A key file written by
+// earlier versions, encrypted with the storage key manager, is rewritten in
+// the current format.
+func LoadIdentity() (crypto.PrivKey, error) {
+	path, err := IdentityPath()
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		log.Printf("[WARN] Identity key %s is accessible by other users; restricting it to the owner", path)
+		if err := os.Chmod(path, 0600); err != nil {
+			return nil, fmt.Errorf("failed to restrict identity key permissions: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if priv, err := crypto.UnmarshalPrivateKey(data); err == nil {
+		return priv, nil
+	}
+	priv, err := loadLegacyIdentity(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveIdentity(priv); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Migrated identity key %s out of the storage key manager", path)
+	return priv, nil
+}
+
+// loadLegacyIdentity decrypts an identity key encrypted with the storage key manager.
+func loadLegacyIdentity(data []byte) (crypto.PrivKey, error) {
+	km, err := storage.GetDefaultKeyManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key manager: %v", err)
+	}
+	raw, err := storage.DecryptDataWithKeyManager(km, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt identity key: %v", err)
+	}
+	priv, err := crypto.UnmarshalPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal identity key: %v", err)
+	}
+	return priv, nil
+}
+
+// ExportIdentity returns the identity key in libp2p's protobuf encoding,
+// unencrypted, so it can be backed up or moved to another machine.
+func ExportIdentity() ([]byte, error) {
+	priv, err := LoadIdentity()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.MarshalPrivateKey(priv)
+}
+
+// RotateIdentity replaces the node identity with a newly generated key. The
+// previous key file is kept next to it with a timestamped .bak suffix.
+func RotateIdentity() (crypto.PrivKey, error) {
+	path, err := IdentityPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		backup := fmt.Sprintf("%s.%d.bak", path, time.Now().Unix())
+		if err := os.Rename(path, backup); err != nil {
+			return nil, fmt.Errorf("failed to back up identity key: %v", err)
+		}
+		log.Printf("[INFO] Previous identity key moved to %s", backup)
+	}
+
+	priv, _, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	if err := saveIdentity(priv); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// saveIdentity writes the key to a private temporary file and renames it over
+// the identity file, so the key is never readable by others or half written.
+func saveIdentity(priv crypto.PrivKey) error {
+	path, err := IdentityPath()
+	if err != nil {
+		return err
+	}
+	raw, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("failed to marshal identity key: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".identity-*")
+	if err != nil {
+		return fmt.Errorf("failed to write identity key: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write identity key: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write identity key: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write identity key: %v", err)
+	}
+	return nil
+}
+
+// auditCheckpointFile is the file in the DesVault directory anchoring the audit log head.
+const auditCheckpointFile = "audit_checkpoint.json"
+
+// AuditAnchor returns an anchor that keeps the audit log checkpoint in the
+// DesVault directory, signed with the current node identity. The identity is
+// loaded on every use, so the anchor follows key rotations.
+func AuditAnchor() (*audit.Anchor, error) {
+	dir, err := setup.GetDesVaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return &audit.Anchor{
+		Path: filepath.Join(dir, auditCheckpointFile),
+		Sign: func(data []byte) ([]byte, error) {
+			priv, err := LoadOrCreateIdentity()
+			if err != nil {
+				return nil, err
+			}
+			return priv.Sign(data)
+		},
+		Verify: func(data, sig []byte) (bool, error) {
+			priv, err := LoadOrCreateIdentity()
+			if err != nil {
+				return false, err
+			}
+			return priv.GetPublic().Verify(data, sig)
+		},
+	}, nil
+}
@@ -0,0 +1,87 @@
+package encryption
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+)
+
+func TestIdentityFileIsPrivate(t *testing.T) {
+	testHome(t)
+	priv, err := LoadOrCreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := IdentityPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("identity key has mode %o, want 600", perm)
+	}
+
+	// A key file readable by others is restricted on load.
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equals(priv) {
+		t.Error("loaded a different identity than was created")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("identity key left with mode %o", info.Mode().Perm())
+	}
+}
+
+func TestLegacyIdentityMigrated(t *testing.T) {
+	testHome(t)
+	priv, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	km, err := storage.GetDefaultKeyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := storage.EncryptDataWithKeyManager(km, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := IdentityPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadIdentity()
+	if err != nil {
+		t.Fatalf("legacy identity not loaded: %v", err)
+	}
+	if !loaded.Equals(priv) {
+		t.Fatal("legacy identity decoded to a different key")
+	}
+	stored, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, raw) {
+		t.Error("legacy identity was not rewritten in the current format")
+	}
+}
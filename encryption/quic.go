@@ -58,43 +58,3 @@ func DialAddrContextWithRetry(ctx context.Context, addr string, tlsConfig *tls.C
 	}
 	return nil, fmt.Errorf("all dial attempts failed: last error: %v", err)
 }
-
-// StartQUICClient connects to a QUIC server using our custom dialer with retry logic,
-// sends a test message over a new stream, and logs the echoed response.
-func StartQUICClient(addr string, tlsConfig *tls.Config, quicConfig *quic.Config) error {
-	// Create a context with a timeout to avoid hanging indefinitely.
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Attempt to dial the QUIC server with retries.
-	conn, err := DialAddrContextWithRetry(ctx, addr, tlsConfig, quicConfig, 3, 1*time.Second)
-	if err != nil {
-		return fmt.Errorf("failed to dial QUIC server at %s: %v", addr, err)
-	}
-	defer conn.CloseWithError(0, "client closing session")
-	log.Printf("[INFO] Connected to QUIC server at %s", conn.RemoteAddr())
-
-	// Open a stream on the connection.
-	stream, err := conn.OpenStreamSync(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to open QUIC stream: %v", err)
-	}
-	defer stream.Close()
-
-	message := "Hello, QUIC Server!"
-	_, err = stream.Write([]byte(message))
-	if err != nil {
-		return fmt.Errorf("failed to write message to stream: %v", err)
-	}
-	log.Printf("[INFO] Sent message: %s", message)
-
-	// Read the response.
-	buf := make([]byte, 1024)
-	n, err := stream.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read response from stream: %v", err)
-	}
-	log.Printf("[INFO] Received response: %s", string(buf[:n]))
-
-	return nil
-}
@@ -0,0 +1,318 @@
+package encryption
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+)
+
+// QUICALPN is the TLS application protocol negotiated by the QUIC shard service.
+const QUICALPN = "desvault-quic"
+
+// Shard service wire format. Every shard uses its own bidirectional stream.
+// A request header is:
+//
+//	version (1) | op (1) | shard ID length (1) | shard ID | payload length (8, PUT only)
+//
+// followed for PUT by the encrypted shard bytes. The response is a status
+// byte, followed for successful GETs by an 8-byte length and the shard bytes.
+const (
+	quicShardVersion byte = 1
+
+	quicOpGet byte = 'G'
+	quicOpPut byte = 'P'
+
+	quicStatusOK       byte = 0
+	quicStatusNotFound byte = 1
+	quicStatusError    byte = 2
+	quicStatusBadReq   byte = 3
+
+	// maxQUICShardSize bounds a shard accepted over QUIC, matching the libp2p shard protocol.
+	maxQUICShardSize = 128 << 20
+	// quicStreamTimeout bounds the time spent transferring a single shard.
+	quicStreamTimeout = 2 * time.Minute
+)
+
+// Flow control and keepalive settings for the shard service.
+const (
+	// quicMaxStreams is the number of shards a peer may transfer concurrently per connection.
+	quicMaxStreams = 16
+	// QUICKeepaliveInterval is how often each side sends a keepalive datagram.
+	QUICKeepaliveInterval = 15 * time.Second
+	// quicKeepaliveTimeout closes a connection that sent no datagram for this long.
+	quicKeepaliveTimeout = 3 * QUICKeepaliveInterval
+
+	quicPing byte = 'p'
+	quicPong byte = 'o'
+)
+
+// Application error codes used when closing connections or resetting streams.
+const (
+	quicCodeNoError       quic.ApplicationErrorCode = 0
+	quicCodeKeepalive     quic.ApplicationErrorCode = 1
+	quicCodeRefused       quic.ApplicationErrorCode = 2
+	quicCodeBadStream     quic.StreamErrorCode      = 1
+	quicCodeRefusedStream quic.StreamErrorCode      = 2
+)
+
+var (
+	// ErrShardNotFound is returned by GetShard when the server does not hold the shard.
+	ErrShardNotFound = errors.New("shard not found")
+	// ErrCorruptShard is returned by GetShard when the served bytes do not match the shard ID.
+	ErrCorruptShard = errors.New("corrupt shard")
+)
+
+// DefaultQUICConfig returns the QUIC configuration for the shard service.
+// Stream windows are sized so that a connection buffers at most a few shards
+// in flight, and the stream limit caps concurrent transfers per peer.
+func DefaultQUICConfig() *quic.Config {
+	return &quic.Config{
+		EnableDatagrams:                true,
+		KeepAlivePeriod:                QUICKeepaliveInterval,
+		MaxIdleTimeout:                 quicKeepaliveTimeout,
+		MaxIncomingStreams:             quicMaxStreams,
+		MaxIncomingUniStreams:          -1,
+		InitialStreamReceiveWindow:     512 << 10,
+		MaxStreamReceiveWindow:         4 << 20,
+		InitialConnectionReceiveWindow: 1 << 20,
+		MaxConnectionReceiveWindow:     32 << 20,
+	}
+}
+
+// shardRequest is a decoded request header.
+type shardRequest struct {
+	op      byte
+	shardID string
+	size    uint64
+}
+
+// writeShardRequest encodes a request header.
+func writeShardRequest(w io.Writer, req shardRequest) error {
+	if len(req.shardID) == 0 || len(req.shardID) > 255 {
+		return fmt.Errorf("invalid shard ID length %d", len(req.shardID))
+	}
+	header := []byte{quicShardVersion, req.op, byte(len(req.shardID))}
+	header = append(header, req.shardID...)
+	if req.op == quicOpPut {
+		header = binary.BigEndian.AppendUint64(header, req.size)
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// readShardRequest decodes a request header.
+func readShardRequest(r io.Reader) (shardRequest, error) {
+	var req shardRequest
+	var fixed [3]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return req, err
+	}
+	if fixed[0] != quicShardVersion {
+		return req, fmt.Errorf("unsupported shard protocol version %d", fixed[0])
+	}
+	if fixed[2] == 0 {
+		return req, errors.New("empty shard ID")
+	}
+	id := make([]byte, fixed[2])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return req, err
+	}
+	req.op = fixed[1]
+	req.shardID = string(id)
+	if req.op == quicOpPut {
+		if err := binary.Read(r, binary.BigEndian, &req.size); err != nil {
+			return req, err
+		}
+		if req.size > maxQUICShardSize {
+			return req, fmt.Errorf("shard of %d bytes exceeds limit of %d bytes", req.size, maxQUICShardSize)
+		}
+	}
+	return req, nil
+}
+
+// -----------------------------------------------------------------------------
+// Keepalives
+// -----------------------------------------------------------------------------
+
+// runKeepalive sends a ping datagram every QUICKeepaliveInterval, answers the
+// peer's pings and closes the connection once no datagram has arrived for
+// quicKeepaliveTimeout. It returns when the connection is closed. Peers that
+// did not negotiate datagrams rely on the transport keepalive only.
+func runKeepalive(conn quic.Connection) {
+	if !conn.ConnectionState().SupportsDatagrams {
+		return
+	}
+	ctx := conn.Context()
+	received := make(chan struct{}, 1)
+
+	go func() {
+		for {
+			msg, err := conn.ReceiveDatagram(ctx)
+			if err != nil {
+				return
+			}
+			if len(msg) == 0 {
+				continue
+			}
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+			if msg[0] == quicPing {
+				msg[0] = quicPong
+				conn.SendDatagram(msg)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(QUICKeepaliveInterval)
+	defer ticker.Stop()
+	lastSeen := time.Now()
+	var seq uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-received:
+			lastSeen = time.Now()
+		case <-ticker.C:
+			if time.Since(lastSeen) > quicKeepaliveTimeout {
+				log.Printf("[WARN] QUIC peer %s missed keepalives, closing connection", conn.RemoteAddr())
+				conn.CloseWithError(quicCodeKeepalive, "keepalive timeout")
+				return
+			}
+			seq++
+			ping := binary.BigEndian.AppendUint64([]byte{quicPing}, seq)
+			if err := conn.SendDatagram(ping); err != nil {
+				log.Printf("[WARN] Failed to send QUIC keepalive to %s: %v", conn.RemoteAddr(), err)
+			}
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Client
+// -----------------------------------------------------------------------------
+
+// QUICShardClient fetches and stores shards on a remote QUIC shard service.
+// It is safe for concurrent use; every call opens its own stream.
+type QUICShardClient struct {
+	conn quic.Connection
+}
+
+// DialShardService connects to the shard service at addr, retrying with
+// backoff. tlsConfig is cloned with its ALPN set to desvault-quic, and
+// quicConfig defaults to DefaultQUICConfig.
+func DialShardService(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*QUICShardClient, error) {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{QUICALPN}
+	if quicConfig == nil {
+		quicConfig = DefaultQUICConfig()
+	}
+	conn, err := DialAddrContextWithRetry(ctx, addr, tlsConfig, quicConfig, 3, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial QUIC shard service at %s: %v", addr, err)
+	}
+	go runKeepalive(conn)
+	log.Printf("[INFO] Connected to QUIC shard service at %s", conn.RemoteAddr())
+	return &QUICShardClient{conn: conn}, nil
+}
+
+// Close closes the connection to the shard service.
+func (c *QUICShardClient) Close() error {
+	return c.conn.CloseWithError(quicCodeNoError, "client closing")
+}
+
+// openStream opens a stream for a single shard transfer.
+func (c *QUICShardClient) openStream(ctx context.Context) (quic.Stream, error) {
+	stream, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open QUIC stream: %v", err)
+	}
+	deadline := time.Now().Add(quicStreamTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	stream.SetDeadline(deadline)
+	return stream, nil
+}
+
+// GetShard retrieves the encrypted bytes of a shard and verifies them against the shard ID.
+func (c *QUICShardClient) GetShard(ctx context.Context, shardID string) ([]byte, error) {
+	stream, err := c.openStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CancelRead(quicCodeBadStream)
+	if err := writeShardRequest(stream, shardRequest{op: quicOpGet, shardID: shardID}); err != nil {
+		stream.CancelWrite(quicCodeBadStream)
+		return nil, fmt.Errorf("failed to send shard request: %v", err)
+	}
+	stream.Close()
+
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(stream, status); err != nil {
+		return nil, fmt.Errorf("failed to read shard response: %v", err)
+	}
+	if status[0] == quicStatusNotFound {
+		return nil, fmt.Errorf("%w: server does not hold shard %s", ErrShardNotFound, shardID)
+	}
+	if status[0] != quicStatusOK {
+		return nil, fmt.Errorf("server refused shard %s (status %d)", shardID, status[0])
+	}
+	var length uint64
+	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("failed to read shard length: %v", err)
+	}
+	if length > maxQUICShardSize {
+		return nil, fmt.Errorf("shard of %d bytes exceeds limit of %d bytes", length, maxQUICShardSize)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(stream, data); err != nil {
+		return nil, fmt.Errorf("failed to read shard data: %v", err)
+	}
+	if err := storage.VerifyShard(shardID, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptShard, err)
+	}
+	return data, nil
+}
+
+// PutShard stores the encrypted bytes of a shard on the server.
+func (c *QUICShardClient) PutShard(ctx context.Context, shardID string, data []byte) error {
+	if len(data) > maxQUICShardSize {
+		return fmt.Errorf("shard of %d bytes exceeds limit of %d bytes", len(data), maxQUICShardSize)
+	}
+	stream, err := c.openStream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.CancelRead(quicCodeBadStream)
+	req := shardRequest{op: quicOpPut, shardID: shardID, size: uint64(len(data))}
+	if err := writeShardRequest(stream, req); err != nil {
+		stream.CancelWrite(quicCodeBadStream)
+		return fmt.Errorf("failed to send shard request: %v", err)
+	}
+	if _, err := stream.Write(data); err != nil {
+		stream.CancelWrite(quicCodeBadStream)
+		return fmt.Errorf("failed to send shard data: %v", err)
+	}
+	stream.Close()
+
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(stream, status); err != nil {
+		return fmt.Errorf("failed to read shard response: %v", err)
+	}
+	if status[0] != quicStatusOK {
+		return fmt.Errorf("server rejected shard %s (status %d)", shardID, status[0])
+	}
+	return nil
+}
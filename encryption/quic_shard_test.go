@@ -0,0 +1,117 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+)
+
+// testHome points the DesVault directory at a temporary home for the test.
+func testHome(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	storage.InitializeStorage()
+}
+
+// newTestShardService serves the node's shards on a loopback QUIC listener
+// and returns a client connected to it.
+func newTestShardService(t *testing.T) *QUICShardClient {
+	t.Helper()
+	testHome(t)
+	certs, err := NewCertManager("", "")
+	if err != nil {
+		t.Fatalf("failed to create certificate manager: %v", err)
+	}
+	listener, err := ListenShardService("127.0.0.1:0", ServerTLSConfig(certs, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ServeShardService(listener, nil)
+	t.Cleanup(func() { listener.Close() })
+
+	self, err := PeerIDFromCertificate(certs.Leaf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := DialShardService(ctx, listener.Addr().String(), ClientTLSConfig(certs, self), nil)
+	if err != nil {
+		t.Fatalf("failed to dial shard service: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestQUICShardPutGet(t *testing.T) {
+	client := newTestShardService(t)
+	ctx := context.Background()
+
+	id, data, err := storage.EncryptShard([]byte("shard content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetShard(ctx, id); !errors.Is(err, ErrShardNotFound) {
+		t.Fatalf("GET before PUT returned %v, want %v", err, ErrShardNotFound)
+	}
+	if err := client.PutShard(ctx, id, data); err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	if !storage.HeldForPeers(id) {
+		t.Error("shard pushed over QUIC is not marked as held for peers")
+	}
+	got, err := client.GetShard(ctx, id)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("GET returned different bytes than were stored")
+	}
+
+	// The server verifies shards against their ID before storing them.
+	otherID, _, err := storage.EncryptShard([]byte("other content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PutShard(ctx, otherID, data); err == nil {
+		t.Error("server stored a shard that does not match its ID")
+	}
+}
+
+func TestQUICShardSizeLimit(t *testing.T) {
+	client := newTestShardService(t)
+	ctx := context.Background()
+	id, _, err := storage.EncryptShard([]byte("shard content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.PutShard(ctx, id, make([]byte, maxQUICShardSize+1)); err == nil {
+		t.Error("client sent a shard over the size limit")
+	}
+
+	// A request declaring an oversized shard is refused before any data is read.
+	stream, err := client.openStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.CancelRead(quicCodeBadStream)
+	if err := writeShardRequest(stream, shardRequest{op: quicOpPut, shardID: id, size: maxQUICShardSize + 1}); err != nil {
+		t.Fatal(err)
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(stream, status); err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if status[0] != quicStatusBadReq {
+		t.Errorf("oversized PUT got status %d, want %d", status[0], quicStatusBadReq)
+	}
+	if storage.HasLocalShard(id) {
+		t.Error("oversized shard was stored")
+	}
+}
@@ -1,13 +1,21 @@
 package encryption
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/quic-go/quic-go"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
 )
 
 // CreateTLSConfig loads a TLS certificate and key from files and returns a TLS configuration.
@@ -19,65 +27,190 @@ func CreateTLSConfig(certFile, keyFile string) (*tls.Config, error) {
 	}
 	return &tls.Config{
 		Certificates: []tls.Certificate{cert},
-		NextProtos:   []string{"desvault-quic"},
+		NextProtos:   []string{QUICALPN},
 	}, nil
 }
 
-// SecureChannelWithTLS starts a QUIC listener on the given address using the provided TLS and QUIC configurations.
-// It accepts incoming connections and handles streams concurrently, echoing received data back.
-func SecureChannelWithTLS(addr string, tlsConfig *tls.Config, quicConfig *quic.Config) error {
+// SecureChannelWithTLS starts the QUIC shard service on the given address using
+// the provided TLS and QUIC configurations. quicConfig defaults to
+// DefaultQUICConfig. Each connection may transfer several shards concurrently,
+// one stream per shard. If allow is not nil it is consulted for every stream,
+// so peers banned or rate limited after the handshake are refused.
+func SecureChannelWithTLS(addr string, tlsConfig *tls.Config, quicConfig *quic.Config, allow func(peer.ID) error) error {
+	listener, err := ListenShardService(addr, tlsConfig, quicConfig)
+	if err != nil {
+		return err
+	}
+	return ServeShardService(listener, allow)
+}
+
+// ListenShardService opens the UDP listener of the QUIC shard service.
+// quicConfig defaults to DefaultQUICConfig.
+func ListenShardService(addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Listener, error) {
+	if quicConfig == nil {
+		quicConfig = DefaultQUICConfig()
+	}
 	listener, err := quic.ListenAddr(addr, tlsConfig, quicConfig)
 	if err != nil {
-		return fmt.Errorf("failed to create QUIC listener on %s: %v", addr, err)
+		return nil, fmt.Errorf("failed to create QUIC listener on %s: %v", addr, err)
 	}
-	defer listener.Close()
-	log.Printf("[INFO] QUIC listener established on %s", addr)
+	log.Printf("[INFO] QUIC shard service listening on %s", listener.Addr())
+	return listener, nil
+}
 
-	// Continuously accept incoming connections.
+// ServeShardService accepts connections on listener until it is closed. If
+// allow is not nil it is consulted for every stream.
+func ServeShardService(listener *quic.Listener, allow func(peer.ID) error) error {
+	defer listener.Close()
 	for {
 		conn, err := listener.Accept(context.Background())
 		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) {
+				return nil
+			}
 			log.Printf("[ERROR] Failed to accept QUIC connection: %v", err)
 			continue
 		}
-		go handleConnection(conn)
+		go handleConnection(conn, allow)
 	}
 }
 
-// handleConnection handles an individual QUIC connection by continuously accepting streams.
-func handleConnection(conn quic.Connection) {
-	log.Printf("[INFO] Accepted connection from %v", conn.RemoteAddr())
-	defer conn.CloseWithError(0, "connection closed")
+// handleConnection keeps the connection alive and serves each incoming stream
+// as a single shard request.
+func handleConnection(conn quic.Connection, allow func(peer.ID) error) {
+	id, err := PeerIDFromConnection(conn.ConnectionState().TLS)
+	if err != nil {
+		log.Printf("[WARN] Rejected connection from %v: %v", conn.RemoteAddr(), err)
+		conn.CloseWithError(quicCodeRefused, "unidentified peer")
+		return
+	}
+	log.Printf("[INFO] Accepted connection from %s (%v)", id, conn.RemoteAddr())
+	defer conn.CloseWithError(quicCodeNoError, "connection closed")
+	go runKeepalive(conn)
 	for {
-		stream, err := conn.AcceptStream(context.Background())
+		stream, err := conn.AcceptStream(conn.Context())
 		if err != nil {
-			log.Printf("[ERROR] Failed to accept stream: %v", err)
+			var appErr *quic.ApplicationError
+			if !errors.As(err, &appErr) && !errors.Is(err, context.Canceled) {
+				log.Printf("[WARN] QUIC connection from %v ended: %v", conn.RemoteAddr(), err)
+			}
 			return
 		}
-		go handleStream(stream)
+		if allow != nil {
+			if err := allow(id); err != nil {
+				log.Printf("[WARN] Refused QUIC stream from %s: %v", id, err)
+				stream.CancelRead(quicCodeRefusedStream)
+				stream.CancelWrite(quicCodeRefusedStream)
+				continue
+			}
+		}
+		go handleStream(conn, stream)
+	}
+}
+
+// quicPutBudget bounds the shard bytes buffered by in-progress QUIC uploads
+// across all connections.
+var quicPutBudget = newByteBudget(4 * maxQUICShardSize)
+
+// byteBudget is a non-blocking counter of bytes in use.
+type byteBudget struct {
+	mu    sync.Mutex
+	used  int64
+	limit int64
+}
+
+func newByteBudget(limit int64) *byteBudget {
+	return &byteBudget{limit: limit}
+}
+
+// charge reserves n bytes, reporting false if the budget would be exceeded.
+func (b *byteBudget) charge(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit {
+		return false
 	}
+	b.used += n
+	return true
+}
+
+// refund returns n previously charged bytes.
+func (b *byteBudget) refund(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
 }
 
-// handleStream processes a single QUIC stream by reading data and echoing it back.
-func handleStream(stream quic.Stream) {
-	log.Printf("[INFO] Accepted new stream (ID: %d)", stream.StreamID())
+// handleStream serves a single shard GET or PUT request.
+func handleStream(conn quic.Connection, stream quic.Stream) {
 	defer stream.Close()
-	buf := make([]byte, 1024)
-	for {
-		n, err := stream.Read(buf)
+	stream.SetDeadline(time.Now().Add(quicStreamTimeout))
+	remote := conn.RemoteAddr()
+
+	req, err := readShardRequest(stream)
+	if err != nil {
+		log.Printf("[ERROR] Invalid shard request from %v: %v", remote, err)
+		stream.Write([]byte{quicStatusBadReq})
+		stream.CancelRead(quicCodeBadStream)
+		return
+	}
+
+	switch req.op {
+	case quicOpGet:
+		data, err := storage.ReadLocalShard(req.shardID)
 		if err != nil {
-			if err == io.EOF {
-				log.Println("[INFO] Stream closed by client")
-				return
-			}
-			log.Printf("[ERROR] Error reading from stream: %v", err)
+			stream.Write([]byte{quicStatusNotFound})
+			return
+		}
+		response := binary.BigEndian.AppendUint64([]byte{quicStatusOK}, uint64(len(data)))
+		if _, err := stream.Write(response); err != nil {
+			log.Printf("[ERROR] Failed to send shard %s to %v: %v", req.shardID, remote, err)
 			return
 		}
-		data := buf[:n]
-		log.Printf("[INFO] Received: %s", data)
 		if _, err := stream.Write(data); err != nil {
-			log.Printf("[ERROR] Error writing to stream: %v", err)
+			log.Printf("[ERROR] Failed to send shard %s to %v: %v", req.shardID, remote, err)
+			return
+		}
+		log.Printf("[INFO] Served shard %s to %v over QUIC", req.shardID, remote)
+	case quicOpPut:
+		size := int64(req.size)
+		release, err := storage.ReserveSpace(size)
+		if err != nil {
+			log.Printf("[WARN] Refused shard %s from %v: %v", req.shardID, remote, err)
+			stream.Write([]byte{quicStatusError})
+			stream.CancelRead(quicCodeRefusedStream)
+			return
+		}
+		defer release()
+		if !quicPutBudget.charge(size) {
+			log.Printf("[WARN] Refused shard %s from %v: too many uploads in progress", req.shardID, remote)
+			stream.Write([]byte{quicStatusError})
+			stream.CancelRead(quicCodeRefusedStream)
+			return
+		}
+		defer quicPutBudget.refund(size)
+		// Grow the buffer as data arrives rather than allocating the declared size up front.
+		var buf bytes.Buffer
+		n, err := buf.ReadFrom(io.LimitReader(stream, size))
+		if err == nil && n != size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to receive shard %s from %v: %v", req.shardID, remote, err)
+			stream.Write([]byte{quicStatusError})
+			return
+		}
+		data := buf.Bytes()
+		if err := storage.WriteLocalShard(req.shardID, data); err != nil {
+			log.Printf("[ERROR] Rejected shard %s from %v: %v", req.shardID, remote, err)
+			stream.Write([]byte{quicStatusError})
 			return
 		}
+		stream.Write([]byte{quicStatusOK})
+		log.Printf("[INFO] Stored shard %s from %v over QUIC", req.shardID, remote)
+	default:
+		log.Printf("[WARN] Unknown QUIC shard operation %q from %v", req.op, remote)
+		stream.Write([]byte{quicStatusBadReq})
+		stream.CancelRead(quicCodeBadStream)
 	}
 }
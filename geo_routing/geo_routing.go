@@ -1,9 +1,5 @@
 package geo_routing
-//
-//
-//Uhhhh i am not using this file right now
-//
-//
+
 import (
 	"fmt"
 	"log"
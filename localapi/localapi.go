@@ -6,19 +6,29 @@ import (
 	"net/http"
 
 	"github.com/ArguableExorcist8/desvault-storage-node/network"
+	"github.com/ArguableExorcist8/desvault-storage-node/reputation"
 )
 
 // StatusResponse defines the JSON structure for /status.
 type StatusResponse struct {
-	PeerID string   `json:"peer_id"`
-	Peers  []string `json:"peers"`
+	PeerID       string                        `json:"peer_id"`
+	Peers        []string                      `json:"peers"`
+	Reachability string                        `json:"reachability"`
+	Bootstrap    []network.BootstrapPeerStatus `json:"bootstrap"`
+	Resources    *network.ResourceUsage        `json:"resources,omitempty"`
 }
 
 // statusHandler returns the node's status.
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	response := StatusResponse{
-		PeerID: network.GetNodePeerID(),
-		Peers:  network.GetConnectedPeers(),
+		PeerID:       network.GetNodePeerID(),
+		Peers:        network.GetConnectedPeers(),
+		Reachability: network.GetReachability(),
+	}
+	if ads := network.GetAutoDiscoveryService(); ads != nil {
+		response.Bootstrap = ads.BootstrapStatus()
+		usage := ads.ResourceUsage()
+		response.Resources = &usage
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -26,9 +36,74 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// capacityHandler returns the peer capacity table built from storage announcements.
+func capacityHandler(w http.ResponseWriter, r *http.Request) {
+	peers := []network.PeerCapacity{}
+	if ads := network.GetAutoDiscoveryService(); ads != nil {
+		peers = ads.Capacity.Snapshot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(peers); err != nil {
+		log.Printf("[ERROR] Failed to encode capacity response: %v", err)
+	}
+}
+
+// bansHandler returns the temporary peer bans currently in force.
+func bansHandler(w http.ResponseWriter, r *http.Request) {
+	bans := []network.TempBan{}
+	if ads := network.GetAutoDiscoveryService(); ads != nil {
+		bans = ads.Gater.Bans()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(bans); err != nil {
+		log.Printf("[ERROR] Failed to encode bans response: %v", err)
+	}
+}
+
+// reputationHandler returns the reputation scores of known peers, best first.
+func reputationHandler(w http.ResponseWriter, r *http.Request) {
+	scores := []reputation.PeerScore{}
+	if ads := network.GetAutoDiscoveryService(); ads != nil && ads.Reputation != nil {
+		scores = ads.Reputation.Snapshot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(scores); err != nil {
+		log.Printf("[ERROR] Failed to encode reputation response: %v", err)
+	}
+}
+
+// pubsubMetricsHandler returns per-topic counts of accepted and rejected PubSub messages.
+func pubsubMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics := []network.TopicValidationStats{}
+	if ads := network.GetAutoDiscoveryService(); ads != nil {
+		metrics = ads.GossipMetrics.Snapshot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+		log.Printf("[ERROR] Failed to encode pubsub metrics response: %v", err)
+	}
+}
+
+// healthHandler returns the peer health table maintained by heartbeats.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	health := []network.PeerHealth{}
+	if ads := network.GetAutoDiscoveryService(); ads != nil {
+		health = ads.Health.Snapshot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		log.Printf("[ERROR] Failed to encode health response: %v", err)
+	}
+}
+
 // StartServer launches a simple HTTP server.
 func StartServer(port string) {
 	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/peers/capacity", capacityHandler)
+	http.HandleFunc("/peers/health", healthHandler)
+	http.HandleFunc("/peers/bans", bansHandler)
+	http.HandleFunc("/peers/reputation", reputationHandler)
+	http.HandleFunc("/pubsub/metrics", pubsubMetricsHandler)
 	log.Printf("[INFO] Local API server listening on %s", port)
 	if err := http.ListenAndServe(port, nil); err != nil {
 		log.Fatalf("[ERROR] Local API server failed: %v", err)
@@ -0,0 +1,283 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+)
+
+const (
+	// StorageAnnouncementTopic is the PubSub topic carrying signed storage announcements.
+	StorageAnnouncementTopic = "storage-announcements"
+	// AnnouncementVersion is the current StorageAnnouncement schema version.
+	AnnouncementVersion = 1
+	// AnnounceInterval is how often the node re-publishes its announcement.
+	AnnounceInterval = 5 * time.Minute
+	// announcementTTL is how long an announcement stays in the capacity table.
+	announcementTTL = 3 * AnnounceInterval
+	// announcementDomain separates announcement signatures from other uses of the node key.
+	announcementDomain = "desvault-storage-announcement:"
+)
+
+// ErrInvalidAnnouncementSignature is returned by VerifyAnnouncement when the
+// signature does not match the announcing peer's key.
+var ErrInvalidAnnouncementSignature = errors.New("invalid announcement signature")
+
+// StorageAnnouncement describes the storage a node offers to the network.
+type StorageAnnouncement struct {
+	Version         int      `json:"version"`
+	PeerID          string   `json:"peerId"`
+	CapacityBytes   int64    `json:"capacityBytes"`
+	UsedBytes       int64    `json:"usedBytes"`
+	Region          string   `json:"region"`
+	SoftwareVersion string   `json:"softwareVersion"`
+	ListenAddrs     []string `json:"listenAddrs"`
+	Reachability    string   `json:"reachability,omitempty"` // "public", "private" or "unknown"
+	// ShardServicePort is the UDP port of the peer's QUIC shard service, or 0.
+	ShardServicePort int   `json:"shardServicePort,omitempty"`
+	Timestamp        int64 `json:"timestamp"` // Unix seconds
+}
+
+// SignedAnnouncement is the wire format published on StorageAnnouncementTopic.
+// Payload is the JSON-encoded StorageAnnouncement, signed with the node's libp2p key.
+type SignedAnnouncement struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+}
+
+// PeerCapacity is an entry of the capacity table.
+type PeerCapacity struct {
+	StorageAnnouncement
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// FreeBytes returns the capacity the peer has not yet used.
+func (p PeerCapacity) FreeBytes() int64 {
+	if free := p.CapacityBytes - p.UsedBytes; free > 0 {
+		return free
+	}
+	return 0
+}
+
+// CapacityTable holds the most recent valid announcement of every peer.
+type CapacityTable struct {
+	mu      sync.RWMutex
+	entries map[string]PeerCapacity
+}
+
+// NewCapacityTable returns an empty capacity table.
+func NewCapacityTable() *CapacityTable {
+	return &CapacityTable{entries: make(map[string]PeerCapacity)}
+}
+
+// Update stores an announcement unless a newer one is already known.
+func (t *CapacityTable) Update(a StorageAnnouncement) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing, ok := t.entries[a.PeerID]; ok && existing.Timestamp > a.Timestamp {
+		return
+	}
+	t.entries[a.PeerID] = PeerCapacity{StorageAnnouncement: a, ReceivedAt: time.Now()}
+}
+
+// Get returns the live entry for a peer, if any.
+func (t *CapacityTable) Get(peerID string) (PeerCapacity, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	entry, ok := t.entries[peerID]
+	if !ok || time.Since(entry.ReceivedAt) > announcementTTL {
+		return PeerCapacity{}, false
+	}
+	return entry, true
+}
+
+// Snapshot returns all live entries sorted by peer ID and drops expired ones.
+func (t *CapacityTable) Snapshot() []PeerCapacity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := make([]PeerCapacity, 0, len(t.entries))
+	for id, entry := range t.entries {
+		if time.Since(entry.ReceivedAt) > announcementTTL {
+			delete(t.entries, id)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].PeerID < entries[j].PeerID })
+	return entries
+}
+
+// -----------------------------------------------------------------------------
+// Signing and Validation
+// -----------------------------------------------------------------------------
+
+// signAnnouncement encodes and signs an announcement with the host's private key.
+func (s *AutoDiscoveryService) signAnnouncement(a StorageAnnouncement) ([]byte, error) {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal announcement: %v", err)
+	}
+	priv := s.Host.Peerstore().PrivKey(s.Host.ID())
+	if priv == nil {
+		return nil, fmt.Errorf("no private key for host %s", s.Host.ID())
+	}
+	sig, err := priv.Sign(append([]byte(announcementDomain), payload...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign announcement: %v", err)
+	}
+	return json.Marshal(SignedAnnouncement{Payload: payload, Signature: sig})
+}
+
+// VerifyAnnouncement decodes a signed announcement and checks its schema,
+// freshness and signature against the announcing peer's public key.
+func VerifyAnnouncement(data []byte) (StorageAnnouncement, error) {
+	var signed SignedAnnouncement
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return StorageAnnouncement{}, fmt.Errorf("malformed announcement: %v", err)
+	}
+	var a StorageAnnouncement
+	if err := json.Unmarshal(signed.Payload, &a); err != nil {
+		return StorageAnnouncement{}, fmt.Errorf("malformed announcement payload: %v", err)
+	}
+	if a.Version != AnnouncementVersion {
+		return StorageAnnouncement{}, fmt.Errorf("unsupported announcement version %d", a.Version)
+	}
+	if a.CapacityBytes < 0 || a.UsedBytes < 0 {
+		return StorageAnnouncement{}, fmt.Errorf("negative capacity in announcement")
+	}
+	if a.ShardServicePort < 0 || a.ShardServicePort > 65535 {
+		return StorageAnnouncement{}, fmt.Errorf("invalid shard service port %d in announcement", a.ShardServicePort)
+	}
+	age := time.Since(time.Unix(a.Timestamp, 0))
+	if age > announcementTTL || age < -time.Minute {
+		return StorageAnnouncement{}, fmt.Errorf("announcement timestamp out of range (age %s)", age)
+	}
+
+	pid, err := peer.Decode(a.PeerID)
+	if err != nil {
+		return StorageAnnouncement{}, fmt.Errorf("invalid peer ID in announcement: %v", err)
+	}
+	pub, err := pid.ExtractPublicKey()
+	if err != nil {
+		return StorageAnnouncement{}, fmt.Errorf("cannot extract public key from %s: %v", pid, err)
+	}
+	ok, err := pub.Verify(append([]byte(announcementDomain), signed.Payload...), signed.Signature)
+	if err != nil || !ok {
+		return StorageAnnouncement{}, fmt.Errorf("%w from %s", ErrInvalidAnnouncementSignature, pid)
+	}
+	return a, nil
+}
+
+// -----------------------------------------------------------------------------
+// Publishing and Subscribing
+// -----------------------------------------------------------------------------
+
+// announcementTopic joins the announcement topic once and returns it.
+func (s *AutoDiscoveryService) announcementTopic() (*pubsub.Topic, error) {
+	s.topicOnce.Do(func() {
+		s.storageTopic, s.topicErr = s.PubSub.Join(StorageAnnouncementTopic)
+	})
+	if s.topicErr != nil {
+		return nil, fmt.Errorf("failed to join pubsub topic: %v", s.topicErr)
+	}
+	return s.storageTopic, nil
+}
+
+// buildAnnouncement describes this node's current storage offer.
+func (s *AutoDiscoveryService) buildAnnouncement(storageGB int) StorageAnnouncement {
+	var addrs []string
+	for _, addr := range s.Host.Addrs() {
+		addrs = append(addrs, addr.String())
+	}
+	return StorageAnnouncement{
+		Version:          AnnouncementVersion,
+		PeerID:           s.Host.ID().String(),
+		CapacityBytes:    int64(storageGB) << 30,
+		UsedBytes:        storage.GetUsedBytes(),
+		Region:           setup.GetRegion(),
+		SoftwareVersion:  setup.Version,
+		ListenAddrs:      addrs,
+		Reachability:     s.Reachability(),
+		ShardServicePort: s.shardServicePort(),
+		Timestamp:        time.Now().Unix(),
+	}
+}
+
+// SubscribeStorageAnnouncements validates incoming announcements and keeps the
+// capacity table up to date until ctx is cancelled.
+func (s *AutoDiscoveryService) SubscribeStorageAnnouncements(ctx context.Context) error {
+	topic, err := s.announcementTopic()
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", StorageAnnouncementTopic, err)
+	}
+	go func() {
+		defer sub.Cancel()
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			// The topic validator has already verified the announcement.
+			a, ok := msg.ValidatorData.(StorageAnnouncement)
+			if !ok {
+				continue
+			}
+			s.Capacity.Update(a)
+			s.rememberAddrs(a)
+		}
+	}()
+	return nil
+}
+
+// rememberAddrs adds the announced listen addresses to the peerstore so the peer can be dialed.
+func (s *AutoDiscoveryService) rememberAddrs(a StorageAnnouncement) {
+	pid, err := peer.Decode(a.PeerID)
+	if err != nil || pid == s.Host.ID() {
+		return
+	}
+	var addrs []ma.Multiaddr
+	for _, str := range a.ListenAddrs {
+		if addr, err := ma.NewMultiaddr(str); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	s.Host.Peerstore().AddAddrs(pid, addrs, peerstore.TempAddrTTL)
+}
+
+// StartStorageAnnouncements publishes the node's announcement now and every AnnounceInterval.
+func (s *AutoDiscoveryService) StartStorageAnnouncements(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(AnnounceInterval)
+		defer ticker.Stop()
+		for {
+			storageGB, err := setup.ReadStorageAllocation()
+			if err != nil {
+				log.Printf("[ERROR] Failed to read storage allocation: %v", err)
+			} else if err := s.AnnounceStorage(storageGB); err != nil {
+				log.Printf("[ERROR] Failed to announce storage: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,163 @@
+package network
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	gonetwork "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	bootstrapMaxAttempts  = 6
+	bootstrapInitialDelay = 2 * time.Second
+	bootstrapMaxDelay     = time.Minute
+	bootstrapDialTimeout  = 30 * time.Second
+)
+
+// BootstrapPeerStatus reports the connection state of a configured bootstrap peer.
+type BootstrapPeerStatus struct {
+	PeerID      string    `json:"peerId"`
+	Addrs       []string  `json:"addrs"`
+	Connected   bool      `json:"connected"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt,omitempty"`
+}
+
+// bootstrapTracker holds the bootstrap peers and their dial status.
+type bootstrapTracker struct {
+	mu     sync.Mutex
+	peers  []peer.AddrInfo
+	status map[peer.ID]*BootstrapPeerStatus
+}
+
+// ParseBootstrapPeers converts multiaddr strings into AddrInfos, merging
+// addresses of the same peer. Invalid entries are logged and skipped.
+func ParseBootstrapPeers(addrs []string) []peer.AddrInfo {
+	var infos []peer.AddrInfo
+	index := make(map[peer.ID]int)
+	for _, s := range addrs {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			log.Printf("[WARN] Ignoring invalid bootstrap address %q: %v", s, err)
+			continue
+		}
+		info, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			log.Printf("[WARN] Ignoring bootstrap address %q without a valid peer ID: %v", s, err)
+			continue
+		}
+		if i, ok := index[info.ID]; ok {
+			infos[i].Addrs = append(infos[i].Addrs, info.Addrs...)
+			continue
+		}
+		index[info.ID] = len(infos)
+		infos = append(infos, *info)
+	}
+	return infos
+}
+
+func newBootstrapTracker(peers []peer.AddrInfo) *bootstrapTracker {
+	t := &bootstrapTracker{
+		peers:  peers,
+		status: make(map[peer.ID]*BootstrapPeerStatus),
+	}
+	for _, info := range peers {
+		var addrs []string
+		for _, a := range info.Addrs {
+			addrs = append(addrs, a.String())
+		}
+		t.status[info.ID] = &BootstrapPeerStatus{PeerID: info.ID.String(), Addrs: addrs}
+	}
+	return t
+}
+
+// ConnectBootstrapPeers dials every bootstrap peer concurrently, retrying with
+// exponential backoff, and refreshes the DHT routing table once any succeed.
+// It blocks until all peers are connected or have exhausted their attempts.
+func (s *AutoDiscoveryService) ConnectBootstrapPeers(ctx context.Context) int {
+	if len(s.bootstrap.peers) == 0 {
+		log.Println("[INFO] No bootstrap peers configured")
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	connected := 0
+	for _, info := range s.bootstrap.peers {
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			if s.dialBootstrapPeer(ctx, info) {
+				mu.Lock()
+				connected++
+				mu.Unlock()
+			}
+		}(info)
+	}
+	wg.Wait()
+
+	log.Printf("[INFO] Connected to %d of %d bootstrap peers", connected, len(s.bootstrap.peers))
+	if connected > 0 {
+		// Connected DHT servers are added to the routing table; refresh it from them.
+		<-s.DHT.RefreshRoutingTable()
+	}
+	return connected
+}
+
+// dialBootstrapPeer connects to a single bootstrap peer with retry and backoff.
+func (s *AutoDiscoveryService) dialBootstrapPeer(ctx context.Context, info peer.AddrInfo) bool {
+	delay := bootstrapInitialDelay
+	for attempt := 1; attempt <= bootstrapMaxAttempts; attempt++ {
+		dialCtx, cancel := context.WithTimeout(ctx, bootstrapDialTimeout)
+		err := s.Host.Connect(dialCtx, info)
+		cancel()
+
+		s.bootstrap.mu.Lock()
+		st := s.bootstrap.status[info.ID]
+		st.Attempts = attempt
+		if err == nil {
+			st.Connected = true
+			st.LastError = ""
+			st.ConnectedAt = time.Now()
+		} else {
+			st.LastError = err.Error()
+		}
+		s.bootstrap.mu.Unlock()
+
+		if err == nil {
+			s.Host.ConnManager().Protect(info.ID, BootstrapPeerTag)
+			log.Printf("[INFO] Connected to bootstrap peer %s", info.ID)
+			return true
+		}
+		log.Printf("[WARN] Bootstrap dial %d/%d to %s failed: %v", attempt, bootstrapMaxAttempts, info.ID, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > bootstrapMaxDelay {
+			delay = bootstrapMaxDelay
+		}
+	}
+	return false
+}
+
+// BootstrapStatus returns the current state of every configured bootstrap peer.
+func (s *AutoDiscoveryService) BootstrapStatus() []BootstrapPeerStatus {
+	s.bootstrap.mu.Lock()
+	defer s.bootstrap.mu.Unlock()
+	statuses := make([]BootstrapPeerStatus, 0, len(s.bootstrap.peers))
+	for _, info := range s.bootstrap.peers {
+		st := *s.bootstrap.status[info.ID]
+		// Report the live connection state; the peer may have disconnected since.
+		st.Connected = st.Connected && s.Host.Network().Connectedness(info.ID) == gonetwork.Connected
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
@@ -0,0 +1,483 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	gonetwork "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/time/rate"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+)
+
+const (
+	// aclFileName is the persisted allow/deny list in the DesVault directory.
+	aclFileName = "acl.json"
+	// aclReloadInterval is how often the running node checks the ACL file for changes.
+	aclReloadInterval = 5 * time.Second
+
+	// auditFailureThreshold failed audits within auditFailureWindow trigger a temporary ban.
+	auditFailureThreshold = 3
+	auditFailureWindow    = 24 * time.Hour
+	auditBanDuration      = time.Hour
+
+	// floodBanDuration is how long a peer exceeding a protocol's stream rate is banned.
+	floodBanDuration = 10 * time.Minute
+)
+
+// streamRateLimits bounds how many streams per second a single peer may open
+// for each protocol, with the given burst.
+var streamRateLimits = map[string]struct {
+	perSecond rate.Limit
+	burst     int
+}{
+	ShardProtocolID: {perSecond: 10, burst: 50},
+	ChatProtocolID:  {perSecond: 2, burst: 20},
+}
+
+// -----------------------------------------------------------------------------
+// Persisted Allow/Deny List
+// -----------------------------------------------------------------------------
+
+// ACL is the persisted peer access list. Deny entries always win. When any
+// allow peers are listed only those peers may connect, and when any allow
+// CIDRs are listed only addresses inside them may be used.
+type ACL struct {
+	AllowPeers []string `json:"allowPeers"`
+	DenyPeers  []string `json:"denyPeers"`
+	AllowCIDRs []string `json:"allowCidrs"`
+	DenyCIDRs  []string `json:"denyCidrs"`
+}
+
+// ACLPath returns the location of the ACL file.
+func ACLPath() (string, error) {
+	dir, err := setup.GetDesVaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, aclFileName), nil
+}
+
+// LoadACL reads the ACL file, returning an empty list if it does not exist.
+func LoadACL() (*ACL, error) {
+	path, err := ACLPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &ACL{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL: %v", err)
+	}
+	var acl ACL
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL: %v", err)
+	}
+	return &acl, nil
+}
+
+// SaveACL writes the ACL file. A running node picks up the change automatically.
+func SaveACL(acl *ACL) error {
+	path, err := ACLPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(acl, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal ACL: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write ACL: %v", err)
+	}
+	return nil
+}
+
+// Allow adds a peer ID or CIDR range to the allow list.
+func (a *ACL) Allow(entry string) error {
+	return a.add(entry, &a.AllowPeers, &a.AllowCIDRs)
+}
+
+// Deny adds a peer ID or CIDR range to the deny list.
+func (a *ACL) Deny(entry string) error {
+	return a.add(entry, &a.DenyPeers, &a.DenyCIDRs)
+}
+
+// Remove deletes an entry from every list and reports whether it was present.
+func (a *ACL) Remove(entry string) bool {
+	removed := false
+	for _, list := range []*[]string{&a.AllowPeers, &a.DenyPeers, &a.AllowCIDRs, &a.DenyCIDRs} {
+		kept := (*list)[:0]
+		for _, e := range *list {
+			if e == entry {
+				removed = true
+				continue
+			}
+			kept = append(kept, e)
+		}
+		*list = kept
+	}
+	return removed
+}
+
+func (a *ACL) add(entry string, peers, cidrs *[]string) error {
+	list := peers
+	if strings.Contains(entry, "/") {
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q: %v", entry, err)
+		}
+		entry = ipnet.String()
+		list = cidrs
+	} else if _, err := peer.Decode(entry); err != nil {
+		return fmt.Errorf("invalid peer ID %q: %v", entry, err)
+	}
+	for _, e := range *list {
+		if e == entry {
+			return nil
+		}
+	}
+	*list = append(*list, entry)
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Connection Gater
+// -----------------------------------------------------------------------------
+
+// TempBan is a temporary ban imposed on a misbehaving peer.
+type TempBan struct {
+	PeerID string    `json:"peerId"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// Gater is a libp2p connection gater enforcing the ACL and temporary bans.
+type Gater struct {
+	mu         sync.RWMutex
+	allowPeers map[peer.ID]bool
+	denyPeers  map[peer.ID]bool
+	allowNets  []*net.IPNet
+	denyNets   []*net.IPNet
+	bans       map[peer.ID]TempBan
+	modTime    time.Time
+
+	failMu   sync.Mutex
+	failures map[peer.ID][]time.Time
+
+	floodMu  sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewGater creates a gater loaded from the ACL file.
+func NewGater() (*Gater, error) {
+	g := &Gater{
+		bans:     make(map[peer.ID]TempBan),
+		failures: make(map[peer.ID][]time.Time),
+		limiters: make(map[string]*rate.Limiter),
+	}
+	if _, err := g.Reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Reload re-reads the ACL file if it changed since the last load.
+func (g *Gater) Reload() (bool, error) {
+	path, err := ACLPath()
+	if err != nil {
+		return false, err
+	}
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	g.mu.RLock()
+	unchanged := modTime.Equal(g.modTime) && g.allowPeers != nil
+	g.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	acl, err := LoadACL()
+	if err != nil {
+		return false, err
+	}
+	allowPeers, denyPeers := make(map[peer.ID]bool), make(map[peer.ID]bool)
+	for _, s := range acl.AllowPeers {
+		if p, err := peer.Decode(s); err == nil {
+			allowPeers[p] = true
+		}
+	}
+	for _, s := range acl.DenyPeers {
+		if p, err := peer.Decode(s); err == nil {
+			denyPeers[p] = true
+		}
+	}
+	allowNets, denyNets := parseCIDRs(acl.AllowCIDRs), parseCIDRs(acl.DenyCIDRs)
+
+	g.mu.Lock()
+	g.allowPeers, g.denyPeers = allowPeers, denyPeers
+	g.allowNets, g.denyNets = allowNets, denyNets
+	g.modTime = modTime
+	g.mu.Unlock()
+	return true, nil
+}
+
+func parseCIDRs(entries []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range entries {
+		if _, ipnet, err := net.ParseCIDR(s); err == nil {
+			nets = append(nets, ipnet)
+		} else {
+			log.Printf("[WARN] Ignoring invalid CIDR %q in ACL: %v", s, err)
+		}
+	}
+	return nets
+}
+
+// PeerAllowed reports whether the ACL and active bans permit the peer.
+func (g *Gater) PeerAllowed(p peer.ID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if ban, ok := g.bans[p]; ok && time.Now().Before(ban.Until) {
+		return false
+	}
+	if g.denyPeers[p] {
+		return false
+	}
+	return len(g.allowPeers) == 0 || g.allowPeers[p]
+}
+
+// addrAllowed reports whether the ACL permits connections over the address.
+func (g *Gater) addrAllowed(addr ma.Multiaddr) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if len(g.denyNets) == 0 && len(g.allowNets) == 0 {
+		return true
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		// Addresses without an IP cannot be checked against an allow list.
+		return len(g.allowNets) == 0
+	}
+	for _, n := range g.denyNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(g.allowNets) == 0 {
+		return true
+	}
+	for _, n := range g.allowNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// InterceptPeerDial implements connmgr.ConnectionGater.
+func (g *Gater) InterceptPeerDial(p peer.ID) bool {
+	return g.PeerAllowed(p)
+}
+
+// InterceptAddrDial implements connmgr.ConnectionGater.
+func (g *Gater) InterceptAddrDial(_ peer.ID, addr ma.Multiaddr) bool {
+	return g.addrAllowed(addr)
+}
+
+// InterceptAccept implements connmgr.ConnectionGater.
+func (g *Gater) InterceptAccept(addrs gonetwork.ConnMultiaddrs) bool {
+	return g.addrAllowed(addrs.RemoteMultiaddr())
+}
+
+// InterceptSecured implements connmgr.ConnectionGater.
+func (g *Gater) InterceptSecured(_ gonetwork.Direction, p peer.ID, addrs gonetwork.ConnMultiaddrs) bool {
+	return g.PeerAllowed(p) && g.addrAllowed(addrs.RemoteMultiaddr())
+}
+
+// InterceptUpgraded implements connmgr.ConnectionGater.
+func (g *Gater) InterceptUpgraded(gonetwork.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// Ban refuses connections from the peer for the given duration.
+func (g *Gater) Ban(p peer.ID, d time.Duration, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.bans[p] = TempBan{PeerID: p.String(), Reason: reason, Until: time.Now().Add(d)}
+}
+
+// Bans returns the active temporary bans, dropping expired ones.
+func (g *Gater) Bans() []TempBan {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	bans := make([]TempBan, 0, len(g.bans))
+	for p, ban := range g.bans {
+		if time.Now().After(ban.Until) {
+			delete(g.bans, p)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// recordAuditFailure counts a failed audit and reports whether the peer
+// reached auditFailureThreshold within auditFailureWindow.
+func (g *Gater) recordAuditFailure(p peer.ID) bool {
+	g.failMu.Lock()
+	defer g.failMu.Unlock()
+	cutoff := time.Now().Add(-auditFailureWindow)
+	recent := []time.Time{time.Now()}
+	for _, t := range g.failures[p] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= auditFailureThreshold {
+		delete(g.failures, p)
+		return true
+	}
+	g.failures[p] = recent
+	return false
+}
+
+// allowStream applies the per-peer stream rate limit of a protocol.
+func (g *Gater) allowStream(p peer.ID, protocol string) bool {
+	limit, ok := streamRateLimits[protocol]
+	if !ok {
+		return true
+	}
+	key := p.String() + " " + protocol
+	g.floodMu.Lock()
+	l, ok := g.limiters[key]
+	if !ok {
+		if len(g.limiters) > 10000 {
+			// Idle limiters are full anyway; start over rather than grow without bound.
+			g.limiters = make(map[string]*rate.Limiter)
+		}
+		l = rate.NewLimiter(limit.perSecond, limit.burst)
+		g.limiters[key] = l
+	}
+	g.floodMu.Unlock()
+	return l.Allow()
+}
+
+// CheckStream reports whether a peer may open a stream for the protocol. It
+// refuses peers denied by the ACL or banned, and temporarily bans peers that
+// exceed the protocol's rate limit. Services outside libp2p, such as the QUIC
+// shard service, use it in place of checkFlood.
+func (g *Gater) CheckStream(p peer.ID, protocol string) error {
+	if !g.PeerAllowed(p) {
+		return fmt.Errorf("peer %s is not allowed by the ACL", p)
+	}
+	if !g.allowStream(p, protocol) {
+		g.Ban(p, floodBanDuration, "flooding "+protocol)
+		return fmt.Errorf("peer %s is flooding %s", p, protocol)
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Enforcement
+// -----------------------------------------------------------------------------
+
+// BanPeer temporarily bans a peer and closes its open connections.
+func (s *AutoDiscoveryService) BanPeer(p peer.ID, d time.Duration, reason string) {
+	s.Gater.Ban(p, d, reason)
+	s.Reputation.RecordViolation(p.String(), reason)
+	if err := s.Host.Network().ClosePeer(p); err != nil {
+		log.Printf("[WARN] Failed to disconnect banned peer %s: %v", p, err)
+	}
+	log.Printf("[WARN] Banned peer %s for %s: %s", p, d, reason)
+}
+
+// ReportAuditFailure records that a peer failed a storage audit, for example
+// by serving or pushing a shard that does not match its ID. Repeated failures
+// lead to a temporary ban.
+func (s *AutoDiscoveryService) ReportAuditFailure(p peer.ID, reason string) {
+	log.Printf("[WARN] Peer %s failed audit: %s", p, reason)
+	s.Reputation.RecordAudit(p.String(), false)
+	if s.Gater.recordAuditFailure(p) {
+		s.BanPeer(p, auditBanDuration, "repeated audit failures: "+reason)
+	}
+}
+
+// WatchACL reloads the ACL file whenever it changes and disconnects peers that
+// are no longer allowed, until ctx is cancelled.
+func (s *AutoDiscoveryService) WatchACL(ctx context.Context) {
+	s.Gater.Watch(ctx, func() {
+		for _, c := range s.Host.Network().Conns() {
+			if !s.Gater.PeerAllowed(c.RemotePeer()) || !s.Gater.addrAllowed(c.RemoteMultiaddr()) {
+				log.Printf("[INFO] Disconnecting peer %s denied by ACL", c.RemotePeer())
+				c.Close()
+			}
+		}
+	})
+}
+
+// Watch reloads the ACL file every aclReloadInterval until ctx is cancelled,
+// calling onChange, if set, after each change. Processes without a libp2p
+// host, such as the standalone QUIC shard service, use it directly.
+func (g *Gater) Watch(ctx context.Context, onChange func()) {
+	ticker := time.NewTicker(aclReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := g.Reload()
+		if err != nil {
+			log.Printf("[ERROR] Failed to reload ACL: %v", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		log.Println("[INFO] Peer ACL reloaded")
+		if onChange != nil {
+			onChange()
+		}
+	}
+}
+
+// checkFlood resets streams from peers exceeding the protocol's rate limit and
+// bans them temporarily. It reports whether the stream may be served.
+func checkFlood(stream gonetwork.Stream, protocol string) bool {
+	if globalADS == nil || globalADS.Gater == nil {
+		return true
+	}
+	p := stream.Conn().RemotePeer()
+	if globalADS.Gater.allowStream(p, protocol) {
+		return true
+	}
+	stream.Reset()
+	globalADS.BanPeer(p, floodBanDuration, "flooding "+protocol)
+	return false
+}
+
+// reportAuditFailure reports a failed audit against the global node, if running.
+func reportAuditFailure(p peer.ID, reason string) {
+	if globalADS != nil && globalADS.Gater != nil {
+		globalADS.ReportAuditFailure(p, reason)
+	}
+}
@@ -0,0 +1,225 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/reputation"
+)
+
+// appScoreWeight scales the reputation-based application score, which lies in
+// [-1, 1]. A peer with a reputation below 0.3 scores under GossipThreshold on
+// this component alone, while even the worst reputation stays above
+// PublishThreshold, so only protocol misbehaviour gets a peer graylisted.
+const appScoreWeight = 25
+
+const (
+	// maxAnnouncementSize bounds a signed storage announcement on the wire.
+	maxAnnouncementSize = 16 << 10
+	// announcementRate and announcementBurst bound how often one peer may announce.
+	announcementRate  = rate.Limit(1.0 / 60)
+	announcementBurst = 3
+)
+
+// Rejection reasons reported in the validation metrics.
+const (
+	rejectSize      = "size"
+	rejectRate      = "rate"
+	rejectSchema    = "schema"
+	rejectSignature = "signature"
+)
+
+// gossipScoreThresholds decide when a peer's GossipSub score stops us from
+// gossiping with it, accepting its publishes, or processing its RPCs at all.
+var gossipScoreThresholds = &pubsub.PeerScoreThresholds{
+	GossipThreshold:             -10,
+	PublishThreshold:            -50,
+	GraylistThreshold:           -80,
+	AcceptPXThreshold:           5,
+	OpportunisticGraftThreshold: 3,
+}
+
+// gossipScoreParams returns the GossipSub peer score parameters. The
+// application-specific component is the peer's reputation, centred on the
+// neutral score so unknown peers neither gain nor lose.
+func gossipScoreParams(rep *reputation.Service) *pubsub.PeerScoreParams {
+	return &pubsub.PeerScoreParams{
+		Topics: map[string]*pubsub.TopicScoreParams{
+			StorageAnnouncementTopic: announcementTopicScoreParams(),
+		},
+		TopicScoreCap: 20,
+		AppSpecificScore: func(p peer.ID) float64 {
+			return 2 * (rep.Score(p.String()) - reputation.NeutralScore)
+		},
+		AppSpecificWeight:           appScoreWeight,
+		IPColocationFactorWeight:    -5,
+		IPColocationFactorThreshold: 10,
+		BehaviourPenaltyWeight:      -10,
+		BehaviourPenaltyThreshold:   6,
+		BehaviourPenaltyDecay:       pubsub.ScoreParameterDecay(10 * time.Minute),
+		DecayInterval:               pubsub.DefaultDecayInterval,
+		DecayToZero:                 pubsub.DefaultDecayToZero,
+		RetainScore:                 time.Hour,
+	}
+}
+
+// announcementTopicScoreParams rewards peers that stay in the announcement mesh
+// and deliver announcements first, and heavily penalises invalid announcements.
+// Announcements are infrequent, so mesh delivery rates are not scored.
+func announcementTopicScoreParams() *pubsub.TopicScoreParams {
+	return &pubsub.TopicScoreParams{
+		TopicWeight:                    0.5,
+		TimeInMeshWeight:               0.01,
+		TimeInMeshQuantum:              time.Second,
+		TimeInMeshCap:                  3600,
+		FirstMessageDeliveriesWeight:   1,
+		FirstMessageDeliveriesDecay:    pubsub.ScoreParameterDecay(time.Hour),
+		FirstMessageDeliveriesCap:      10,
+		InvalidMessageDeliveriesWeight: -100,
+		InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(6 * time.Hour),
+	}
+}
+
+// gossipSubOptions returns the options used to create the node's GossipSub router.
+func gossipSubOptions(rep *reputation.Service) []pubsub.Option {
+	return []pubsub.Option{
+		pubsub.WithPeerScore(gossipScoreParams(rep), gossipScoreThresholds),
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Topic Validation
+// -----------------------------------------------------------------------------
+
+// TopicValidationStats counts the messages validated on one topic.
+type TopicValidationStats struct {
+	Topic    string            `json:"topic"`
+	Accepted uint64            `json:"accepted"`
+	Rejected map[string]uint64 `json:"rejected"` // Rejection reason -> count
+}
+
+// GossipMetrics collects per-topic validation results.
+type GossipMetrics struct {
+	mu     sync.Mutex
+	topics map[string]*TopicValidationStats
+}
+
+// NewGossipMetrics returns empty validation metrics.
+func NewGossipMetrics() *GossipMetrics {
+	return &GossipMetrics{topics: make(map[string]*TopicValidationStats)}
+}
+
+func (m *GossipMetrics) stats(topic string) *TopicValidationStats {
+	st, ok := m.topics[topic]
+	if !ok {
+		st = &TopicValidationStats{Topic: topic, Rejected: make(map[string]uint64)}
+		m.topics[topic] = st
+	}
+	return st
+}
+
+func (m *GossipMetrics) accept(topic string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats(topic).Accepted++
+}
+
+func (m *GossipMetrics) reject(topic, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats(topic).Rejected[reason]++
+}
+
+// Snapshot returns a copy of the metrics sorted by topic.
+func (m *GossipMetrics) Snapshot() []TopicValidationStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]TopicValidationStats, 0, len(m.topics))
+	for _, st := range m.topics {
+		cp := TopicValidationStats{Topic: st.Topic, Accepted: st.Accepted, Rejected: make(map[string]uint64, len(st.Rejected))}
+		for reason, n := range st.Rejected {
+			cp.Rejected[reason] = n
+		}
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Topic < out[j].Topic })
+	return out
+}
+
+// registerTopicValidators installs the validators for every topic the node uses.
+func (s *AutoDiscoveryService) registerTopicValidators() error {
+	limiter := newOriginLimiter(announcementRate, announcementBurst)
+	validate := func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		return s.validateAnnouncement(limiter, msg)
+	}
+	return s.PubSub.RegisterTopicValidator(StorageAnnouncementTopic, validate, pubsub.WithValidatorTimeout(time.Second))
+}
+
+// validateAnnouncement checks size, per-originator rate, schema and signature
+// of a storage announcement. Valid announcements are attached to the message
+// as ValidatorData for subscribers.
+func (s *AutoDiscoveryService) validateAnnouncement(limiter *originLimiter, msg *pubsub.Message) pubsub.ValidationResult {
+	topic := StorageAnnouncementTopic
+	origin := msg.GetFrom()
+	if len(msg.Data) > maxAnnouncementSize {
+		return s.rejectMessage(topic, rejectSize, origin)
+	}
+	if !limiter.allow(origin) {
+		s.GossipMetrics.reject(topic, rejectRate)
+		return pubsub.ValidationIgnore
+	}
+	a, err := VerifyAnnouncement(msg.Data)
+	if errors.Is(err, ErrInvalidAnnouncementSignature) {
+		return s.rejectMessage(topic, rejectSignature, origin)
+	}
+	if err != nil {
+		return s.rejectMessage(topic, rejectSchema, origin)
+	}
+	if a.PeerID != origin.String() {
+		// Peers may only announce their own storage.
+		return s.rejectMessage(topic, rejectSignature, origin)
+	}
+	msg.ValidatorData = a
+	s.GossipMetrics.accept(topic)
+	return pubsub.ValidationAccept
+}
+
+// rejectMessage counts a rejected message and records a violation against its originator.
+func (s *AutoDiscoveryService) rejectMessage(topic, reason string, origin peer.ID) pubsub.ValidationResult {
+	s.GossipMetrics.reject(topic, reason)
+	s.Reputation.RecordViolation(origin.String(), "invalid "+topic+" message ("+reason+")")
+	return pubsub.ValidationReject
+}
+
+// originLimiter rate-limits messages per originating peer.
+type originLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[peer.ID]*rate.Limiter
+}
+
+func newOriginLimiter(limit rate.Limit, burst int) *originLimiter {
+	return &originLimiter{limit: limit, burst: burst, limiters: make(map[peer.ID]*rate.Limiter)}
+}
+
+func (l *originLimiter) allow(p peer.ID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim, ok := l.limiters[p]
+	if !ok {
+		if len(l.limiters) > 10000 {
+			l.limiters = make(map[peer.ID]*rate.Limiter)
+		}
+		lim = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[p] = lim
+	}
+	return lim.Allow()
+}
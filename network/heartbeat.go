@@ -0,0 +1,317 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	gonetwork "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// HeartbeatProtocolID is the libp2p protocol used to check peer liveness.
+const HeartbeatProtocolID = "/desvault/heartbeat/1.0.0"
+
+const (
+	// HeartbeatInterval is how often every tracked peer is checked.
+	HeartbeatInterval = 30 * time.Second
+	heartbeatTimeout  = 10 * time.Second
+	heartbeatSize     = 32
+	// unreachableAfter consecutive failed heartbeats mark a peer unreachable.
+	unreachableAfter = 3
+	// forgetAfter is how long a peer that is not connected stays in the health table.
+	forgetAfter = 24 * time.Hour
+	// maxConcurrentHeartbeats bounds the heartbeats in flight at once.
+	maxConcurrentHeartbeats = 16
+)
+
+// Peer health states.
+const (
+	PeerConnected   = "connected"   // Live connection and answering heartbeats
+	PeerKnown       = "known"       // Seen before but not currently connected
+	PeerUnreachable = "unreachable" // Repeatedly failed to answer heartbeats
+)
+
+// PeerHealth is an entry of the health table.
+type PeerHealth struct {
+	PeerID    string        `json:"peerId"`
+	State     string        `json:"state"`
+	LastSeen  time.Time     `json:"lastSeen"`
+	LastRTT   time.Duration `json:"lastRtt"`
+	AvgRTT    time.Duration `json:"avgRtt"`
+	Failures  int           `json:"failures"` // Consecutive failed heartbeats
+	LastError string        `json:"lastError,omitempty"`
+}
+
+// HealthTable tracks the liveness of the DesVault peers the node has been
+// connected to, that is peers that speak HeartbeatProtocolID.
+type HealthTable struct {
+	mu    sync.RWMutex
+	peers map[peer.ID]*PeerHealth
+}
+
+// NewHealthTable returns an empty health table.
+func NewHealthTable() *HealthTable {
+	return &HealthTable{peers: make(map[peer.ID]*PeerHealth)}
+}
+
+func (t *HealthTable) entry(p peer.ID) *PeerHealth {
+	e, ok := t.peers[p]
+	if !ok {
+		e = &PeerHealth{PeerID: p.String(), State: PeerKnown}
+		t.peers[p] = e
+	}
+	return e
+}
+
+// connected marks a peer as connected, adding it to the table.
+func (t *HealthTable) connected(p peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entry(p)
+	e.State = PeerConnected
+	e.LastSeen = time.Now()
+	e.Failures = 0
+}
+
+// reconnected marks a tracked peer as connected again; unknown peers are
+// added once they are known to speak the heartbeat protocol.
+func (t *HealthTable) reconnected(p peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.peers[p]; ok {
+		e.State = PeerConnected
+		e.LastSeen = time.Now()
+		e.Failures = 0
+	}
+}
+
+// disconnected marks a peer as known once its last connection closes.
+func (t *HealthTable) disconnected(p peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.peers[p]; ok && e.State == PeerConnected {
+		e.State = PeerKnown
+	}
+}
+
+// record stores the result of a heartbeat.
+func (t *HealthTable) record(p peer.ID, rtt time.Duration, err error, live bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entry(p)
+	if err != nil {
+		e.Failures++
+		e.LastError = err.Error()
+		switch {
+		case e.Failures >= unreachableAfter:
+			e.State = PeerUnreachable
+		case !live:
+			e.State = PeerKnown
+		}
+		return
+	}
+	e.State = PeerConnected
+	e.LastSeen = time.Now()
+	e.LastRTT = rtt
+	if e.AvgRTT == 0 {
+		e.AvgRTT = rtt
+	} else {
+		e.AvgRTT = (4*e.AvgRTT + rtt) / 5
+	}
+	e.Failures = 0
+	e.LastError = ""
+}
+
+// Get returns the entry of a peer, if it is tracked.
+func (t *HealthTable) Get(p peer.ID) (PeerHealth, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	e, ok := t.peers[p]
+	if !ok {
+		return PeerHealth{}, false
+	}
+	return *e, true
+}
+
+// Snapshot returns all entries sorted by state and peer ID.
+func (t *HealthTable) Snapshot() []PeerHealth {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	entries := make([]PeerHealth, 0, len(t.peers))
+	for _, e := range t.peers {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].State != entries[j].State {
+			return entries[i].State < entries[j].State
+		}
+		return entries[i].PeerID < entries[j].PeerID
+	})
+	return entries
+}
+
+// tracked returns the tracked peers, forgetting peers that have not been seen
+// for forgetAfter.
+func (t *HealthTable) tracked() []peer.ID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]peer.ID, 0, len(t.peers))
+	for p, e := range t.peers {
+		if e.State != PeerConnected && time.Since(e.LastSeen) > forgetAfter {
+			delete(t.peers, p)
+			continue
+		}
+		ids = append(ids, p)
+	}
+	return ids
+}
+
+// -----------------------------------------------------------------------------
+// Heartbeat Protocol
+// -----------------------------------------------------------------------------
+
+// handleHeartbeatStream echoes heartbeat payloads until the stream closes.
+func handleHeartbeatStream(stream gonetwork.Stream) {
+	defer stream.Close()
+	buf := make([]byte, heartbeatSize)
+	for {
+		stream.SetDeadline(time.Now().Add(HeartbeatInterval + heartbeatTimeout))
+		if _, err := io.ReadFull(stream, buf); err != nil {
+			return
+		}
+		if _, err := stream.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+// SendHeartbeat sends a random payload to the peer over an existing
+// connection and returns the round-trip time once the peer has echoed it
+// back. It never dials; reconnecting is left to discovery and repair.
+func SendHeartbeat(ctx context.Context, h host.Host, p peer.ID) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, heartbeatTimeout)
+	defer cancel()
+	stream, err := h.NewStream(gonetwork.WithNoDial(ctx, "heartbeat"), p, HeartbeatProtocolID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open heartbeat stream: %v", err)
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	payload := make([]byte, heartbeatSize)
+	if _, err := rand.Read(payload); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err := stream.Write(payload); err != nil {
+		return 0, fmt.Errorf("failed to send heartbeat: %v", err)
+	}
+	echo := make([]byte, heartbeatSize)
+	if _, err := io.ReadFull(stream, echo); err != nil {
+		return 0, fmt.Errorf("no heartbeat reply: %v", err)
+	}
+	if !bytes.Equal(payload, echo) {
+		return 0, fmt.Errorf("heartbeat reply does not match")
+	}
+	return time.Since(start), nil
+}
+
+// StartHeartbeats keeps the health table current: it follows connection
+// events and sends a heartbeat to every connected DesVault peer each
+// HeartbeatInterval, reporting the results to the reputation service.
+func (s *AutoDiscoveryService) StartHeartbeats(ctx context.Context) {
+	s.Host.Network().Notify(&gonetwork.NotifyBundle{
+		ConnectedF: func(_ gonetwork.Network, c gonetwork.Conn) {
+			s.Health.reconnected(c.RemotePeer())
+		},
+		DisconnectedF: func(n gonetwork.Network, c gonetwork.Conn) {
+			if n.Connectedness(c.RemotePeer()) != gonetwork.Connected {
+				s.Health.disconnected(c.RemotePeer())
+			}
+		},
+	})
+	s.trackDesVaultPeers()
+
+	go func() {
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.heartbeatAll(ctx)
+			}
+		}
+	}()
+}
+
+// trackDesVaultPeers adds connected peers that speak the heartbeat protocol
+// to the health table. Other peers, such as DHT, relay and bootstrap nodes,
+// are never tracked.
+func (s *AutoDiscoveryService) trackDesVaultPeers() {
+	for _, p := range s.Host.Network().Peers() {
+		if _, tracked := s.Health.Get(p); tracked {
+			continue
+		}
+		if protos, err := s.Host.Peerstore().SupportsProtocols(p, HeartbeatProtocolID); err == nil && len(protos) > 0 {
+			s.Health.connected(p)
+		}
+	}
+}
+
+// heartbeatAll checks every tracked peer that is currently connected, at most
+// maxConcurrentHeartbeats at a time. Peers that are not connected are not
+// dialed; they are forgotten after forgetAfter unless they reconnect.
+func (s *AutoDiscoveryService) heartbeatAll(ctx context.Context) {
+	s.trackDesVaultPeers()
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentHeartbeats)
+	for _, p := range s.Health.tracked() {
+		if !s.Gater.PeerAllowed(p) || s.Host.Network().Connectedness(p) != gonetwork.Connected {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			rtt, err := SendHeartbeat(ctx, s.Host, p)
+			if ctx.Err() != nil {
+				return
+			}
+			live := s.Host.Network().Connectedness(p) == gonetwork.Connected
+			s.Health.record(p, rtt, err, live)
+			s.Reputation.RecordHeartbeat(p.String(), err == nil)
+		}(p)
+	}
+	wg.Wait()
+
+	var connected, unreachable int
+	for _, e := range s.Health.Snapshot() {
+		switch e.State {
+		case PeerConnected:
+			connected++
+		case PeerUnreachable:
+			unreachable++
+		}
+	}
+	if unreachable > 0 {
+		log.Printf("[WARN] Heartbeat: %d peers connected, %d unreachable", connected, unreachable)
+	}
+}
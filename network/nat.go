@@ -0,0 +1,113 @@
+package network
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	gonetwork "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+)
+
+// natOptions returns the libp2p options for NAT traversal: AutoNAT reachability
+// detection, the circuit relay v2 client with automatic relay reservations,
+// DCUtR hole punching and, when enabled in cfg, UPnP/NAT-PMP port mapping and
+// the relay service for publicly reachable nodes.
+func natOptions(cfg *setup.Config, relays autorelay.PeerSource) []libp2p.Option {
+	opts := []libp2p.Option{
+		libp2p.EnableNATService(),
+		libp2p.EnableRelay(),
+		libp2p.EnableAutoRelayWithPeerSource(relays, autorelay.WithMinInterval(time.Minute)),
+		libp2p.EnableHolePunching(),
+	}
+	if cfg.EnableNATPortMap {
+		opts = append(opts, libp2p.NATPortMap())
+	}
+	if cfg.EnableRelayService {
+		// The relay service only activates once AutoNAT reports public reachability.
+		opts = append(opts, libp2p.EnableRelayService())
+	}
+	return opts
+}
+
+// relayCandidates is the autorelay peer source. It offers the bootstrap peers
+// and currently connected peers; autorelay keeps those that speak relay v2.
+func (s *AutoDiscoveryService) relayCandidates(ctx context.Context, num int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo, num)
+	go func() {
+		defer close(out)
+		seen := make(map[peer.ID]bool)
+		send := func(info peer.AddrInfo) bool {
+			if seen[info.ID] || len(seen) >= num {
+				return len(seen) < num
+			}
+			seen[info.ID] = true
+			select {
+			case out <- info:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, info := range s.bootstrap.peers {
+			if !send(info) {
+				return
+			}
+		}
+		for _, p := range s.Host.Network().Peers() {
+			if !send(s.Host.Peerstore().PeerInfo(p)) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// watchReachability records reachability changes reported by AutoNAT.
+func (s *AutoDiscoveryService) watchReachability(ctx context.Context) error {
+	sub, err := s.Host.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				r := e.(event.EvtLocalReachabilityChanged).Reachability
+				s.reachMu.Lock()
+				s.reachability = r
+				s.reachMu.Unlock()
+				log.Printf("[INFO] Node reachability is now %s", strings.ToLower(r.String()))
+			}
+		}
+	}()
+	return nil
+}
+
+// Reachability returns the reachability detected by AutoNAT: "public",
+// "private" or "unknown".
+func (s *AutoDiscoveryService) Reachability() string {
+	s.reachMu.RLock()
+	defer s.reachMu.RUnlock()
+	return strings.ToLower(s.reachability.String())
+}
+
+// GetReachability returns the global node's reachability, or "unknown" before InitializeNode.
+func GetReachability() string {
+	if globalADS == nil {
+		return strings.ToLower(gonetwork.ReachabilityUnknown.String())
+	}
+	return globalADS.Reachability()
+}
@@ -9,21 +9,29 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
-	gonetwork "github.com/libp2p/go-libp2p/core/network"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
+	gonetwork "github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
 	mdns "github.com/libp2p/go-libp2p/p2p/discovery/mdns"
-	dht "github.com/libp2p/go-libp2p-kad-dht"
-	pubsub "github.com/libp2p/go-libp2p-pubsub"
 
+	"github.com/ArguableExorcist8/desvault-storage-node/encryption"
+	"github.com/ArguableExorcist8/desvault-storage-node/reputation"
 	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
 )
 
 const ChatProtocolID = "/desvault/chat/1.0.0"
 
+// maxChatMessage bounds a single chat line; longer lines close the stream.
+const maxChatMessage = 4 << 10
+
 // -----------------------------------------------------------------------------
 // Libp2p-Based Peer Discovery
 // -----------------------------------------------------------------------------
@@ -36,6 +44,10 @@ type Notifee struct {
 // HandlePeerFound is invoked when a peer is discovered via mDNS.
 func (n *Notifee) HandlePeerFound(pi peer.AddrInfo) {
 	log.Printf("[mDNS] Discovered peer: %s", pi.ID.String())
+	if globalADS != nil && !globalADS.Gater.PeerAllowed(pi.ID) {
+		log.Printf("[mDNS] Ignoring peer %s blocked by ACL", pi.ID.String())
+		return
+	}
 	// Attempt to connect to the discovered peer.
 	if err := n.Host.Connect(context.Background(), pi); err != nil {
 		log.Printf("[ERROR] Failed to connect to peer %s: %v", pi.ID.String(), err)
@@ -46,12 +58,36 @@ func (n *Notifee) HandlePeerFound(pi peer.AddrInfo) {
 
 // AutoDiscoveryService encapsulates the libp2p host, DHT instance, and PubSub.
 type AutoDiscoveryService struct {
-	Host   host.Host
-	DHT    *dht.IpfsDHT
-	PubSub *pubsub.PubSub
+	Host     host.Host
+	DHT      *dht.IpfsDHT
+	PubSub   *pubsub.PubSub
+	Capacity *CapacityTable
+	Gater    *Gater
+	// Reputation scores peers from audits, transfers and violations; may be nil.
+	Reputation *reputation.Service
+	// GossipMetrics counts accepted and rejected PubSub messages per topic.
+	GossipMetrics *GossipMetrics
+	// Health tracks liveness and round-trip times of peers.
+	Health *HealthTable
+
+	topicOnce    sync.Once
+	storageTopic *pubsub.Topic
+	topicErr     error
+	bootstrap    *bootstrapTracker
+
+	reachMu      sync.RWMutex
+	reachability gonetwork.Reachability
+
+	connLow, connHigh int
+	private           bool // Whether the node runs in a private swarm
+
+	quicMu sync.RWMutex
+	quic   *quicShards
 }
 
-// Start initializes mDNS discovery, bootstraps the DHT, and starts the mDNS service.
+// Start initializes mDNS discovery, dials the configured bootstrap peers,
+// bootstraps the DHT, and starts the mDNS service. It blocks until bootstrap
+// dialing has finished, so callers usually run it in a goroutine.
 func (s *AutoDiscoveryService) Start(ctx context.Context) {
 	// Initialize mDNS service.
 	mdnsService := mdns.NewMdnsService(s.Host, "_desvault._tcp", &Notifee{Host: s.Host})
@@ -61,6 +97,9 @@ func (s *AutoDiscoveryService) Start(ctx context.Context) {
 		log.Println("[INFO] mDNS service started successfully")
 	}
 
+	// Dial bootstrap peers so nodes outside the LAN can join without mDNS.
+	s.ConnectBootstrapPeers(ctx)
+
 	// Bootstrap the DHT.
 	if err := s.DHT.Bootstrap(ctx); err != nil {
 		log.Printf("[ERROR] DHT bootstrap error: %v", err)
@@ -71,18 +110,22 @@ func (s *AutoDiscoveryService) Start(ctx context.Context) {
 	log.Println("[INFO] Peer discovery fully initialized")
 }
 
-// AnnounceStorage publishes the node's storage contribution to the network using PubSub.
+// AnnounceStorage publishes a signed announcement of the node's storage contribution using PubSub.
 func (s *AutoDiscoveryService) AnnounceStorage(storageGB int) error {
-	topic, err := s.PubSub.Join("storage-announcements")
+	topic, err := s.announcementTopic()
 	if err != nil {
-		return fmt.Errorf("failed to join pubsub topic: %v", err)
+		return err
 	}
 	// Create and publish the storage announcement message.
-	msg := fmt.Sprintf("Node %s offering %dGB storage", s.Host.ID().String(), storageGB)
-	if err := topic.Publish(context.Background(), []byte(msg)); err != nil {
+	announcement := s.buildAnnouncement(storageGB)
+	msg, err := s.signAnnouncement(announcement)
+	if err != nil {
+		return err
+	}
+	if err := topic.Publish(context.Background(), msg); err != nil {
 		return fmt.Errorf("failed to publish storage announcement: %v", err)
 	}
-	log.Printf("[INFO] Storage announced: %s", msg)
+	log.Printf("[INFO] Storage announced: %d GB capacity, %d bytes used", storageGB, announcement.UsedBytes)
 	return nil
 }
 
@@ -94,46 +137,135 @@ var globalADS *AutoDiscoveryService
 
 // InitializeNode creates a libp2p host with a DHT and PubSub instance,
 // sets up mDNS discovery, and a stream handler for chat messages.
+// The host listens on TCP, QUIC-v1 and optionally WebTransport as configured.
+// NAT traversal (AutoNAT, relay v2, hole punching) is always enabled; port
+// mapping and the relay service follow cfg.
+// GossipSub peer scoring uses rep, which may be nil.
+// Bootstrap peers from cfg seed the DHT routing table; call Start to dial them.
 // It returns an AutoDiscoveryService.
-func InitializeNode(ctx context.Context) (*AutoDiscoveryService, error) {
+func InitializeNode(ctx context.Context, cfg *setup.Config, rep *reputation.Service) (*AutoDiscoveryService, error) {
+	bootstrapPeers := ParseBootstrapPeers(cfg.BootstrapPeers)
+
+	// Load the persistent node identity so the peer ID survives restarts.
+	priv, err := encryption.LoadOrCreateIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %v", err)
+	}
+
+	// Join the private swarm if configured; refuse to start on mixed configurations.
+	psk, err := LoadSwarmKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Enforce the persisted allow/deny list and temporary bans on every connection.
+	gater, err := NewGater()
+	if err != nil {
+		return nil, err
+	}
+
+	// ads is filled in once the host exists; the relay peer source only runs after that.
+	ads := &AutoDiscoveryService{
+		Gater:         gater,
+		Reputation:    rep,
+		GossipMetrics: NewGossipMetrics(),
+		Health:        NewHealthTable(),
+		Capacity:      NewCapacityTable(),
+		bootstrap:     newBootstrapTracker(bootstrapPeers),
+		connLow:       cfg.ConnLowWater,
+		connHigh:      cfg.ConnHighWater,
+		private:       psk != nil,
+	}
+
 	// Create a new libp2p host.
-	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/4001"))
+	opts := []libp2p.Option{libp2p.Identity(priv), libp2p.ConnectionGater(gater)}
+	if psk != nil {
+		// libp2p falls back to its TCP-based private transports with a PSK;
+		// QUIC and WebTransport cannot be protected by the pre-shared key.
+		listenCfg := *cfg
+		if listenCfg.QUICPort > 0 || listenCfg.EnableWebTransport {
+			log.Println("[WARN] Private network mode: QUIC and WebTransport listeners disabled")
+		}
+		listenCfg.QUICPort = 0
+		listenCfg.EnableWebTransport = false
+		pnet.ForcePrivateNetwork = true
+		opts = append(opts,
+			libp2p.PrivateNetwork(psk),
+			libp2p.ListenAddrStrings(ListenAddrs(&listenCfg)...),
+		)
+		log.Println("[INFO] Joining private DesVault swarm")
+	} else {
+		opts = append(opts, libp2p.ListenAddrStrings(ListenAddrs(cfg)...))
+	}
+	resourceOpts, err := resourceOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, resourceOpts...)
+	opts = append(opts, natOptions(cfg, ads.relayCandidates)...)
+	h, err := libp2p.New(opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create libp2p host: %v", err)
 	}
 
 	// Initialize the Kademlia DHT.
-	kademliaDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeAuto))
+	dhtOpts := []dht.Option{dht.Mode(dht.ModeAuto)}
+	if len(bootstrapPeers) > 0 {
+		dhtOpts = append(dhtOpts, dht.BootstrapPeers(bootstrapPeers...))
+	}
+	kademliaDHT, err := dht.New(ctx, h, dhtOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize DHT: %v", err)
 	}
 
 	// Initialize PubSub using GossipSub.
-	ps, err := pubsub.NewGossipSub(ctx, h)
+	ps, err := pubsub.NewGossipSub(ctx, h, gossipSubOptions(rep)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create pubsub: %v", err)
 	}
 
-	ads := &AutoDiscoveryService{
-		Host:   h,
-		DHT:    kademliaDHT,
-		PubSub: ps,
+	ads.Host = h
+	ads.DHT = kademliaDHT
+	ads.PubSub = ps
+	if err := ads.registerTopicValidators(); err != nil {
+		return nil, fmt.Errorf("failed to register topic validators: %v", err)
+	}
+	if err := ads.watchReachability(ctx); err != nil {
+		log.Printf("[WARN] Failed to watch reachability changes: %v", err)
 	}
 
 	// Set up a stream handler for the chat protocol.
 	h.SetStreamHandler(ChatProtocolID, func(stream gonetwork.Stream) {
-		reader := bufio.NewReader(stream)
+		if !checkFlood(stream, ChatProtocolID) {
+			return
+		}
+		defer stream.Close()
+		// Charge the read buffer against the chat protocol's memory limit.
+		if err := stream.Scope().ReserveMemory(maxChatMessage, gonetwork.ReservationPriorityLow); err != nil {
+			log.Printf("[WARN] Refused chat stream from %s: %v", stream.Conn().RemotePeer(), err)
+			stream.Reset()
+			return
+		}
+		defer stream.Scope().ReleaseMemory(maxChatMessage)
+		reader := bufio.NewReaderSize(stream, maxChatMessage)
 		for {
-			msg, err := reader.ReadString('\n')
+			msg, err := reader.ReadSlice('\n')
 			if err != nil {
 				log.Printf("[ERROR] Failed to read from chat stream: %v", err)
 				return
 			}
-			fmt.Printf("[Chat] %s: %s\n", stream.Conn().RemotePeer().String(), strings.TrimSpace(msg))
+			fmt.Printf("[Chat] %s: %s\n", stream.Conn().RemotePeer().String(), strings.TrimSpace(string(msg)))
 		}
 	})
 
+	// Serve shard fetch and store requests from other nodes.
+	h.SetStreamHandler(ShardProtocolID, handleShardStream)
+	// Answer liveness checks.
+	h.SetStreamHandler(HeartbeatProtocolID, handleHeartbeatStream)
+
 	SetGlobalAutoDiscoveryService(ads)
+	// Announce shards in the DHT as soon as they are stored, whichever transport delivered them.
+	storage.SetShardStoredHook(provideAsync)
 	return ads, nil
 }
 
@@ -142,6 +274,11 @@ func SetGlobalAutoDiscoveryService(ads *AutoDiscoveryService) {
 	globalADS = ads
 }
 
+// GetAutoDiscoveryService returns the global AutoDiscoveryService, or nil before InitializeNode.
+func GetAutoDiscoveryService() *AutoDiscoveryService {
+	return globalADS
+}
+
 // GetNodePeerID returns the node's peer ID.
 func GetNodePeerID() string {
 	if globalADS == nil {
@@ -150,13 +287,13 @@ func GetNodePeerID() string {
 	return globalADS.Host.ID().String()
 }
 
-// GetConnectedPeers returns a slice of connected peer IDs.
+// GetConnectedPeers returns the IDs of peers with a live connection.
 func GetConnectedPeers() []string {
 	if globalADS == nil {
 		return []string{}
 	}
-	var peers []string
-	for _, p := range globalADS.Host.Peerstore().Peers() {
+	peers := []string{}
+	for _, p := range globalADS.Host.Network().Peers() {
 		peers = append(peers, p.String())
 	}
 	return peers
@@ -229,13 +366,23 @@ func RegisterNode(h host.Host, config *setup.Config) error {
 	return nil
 }
 
-// MonitorNetwork continuously monitors network connectivity and logs the current number of connected peers.
+// MonitorNetwork continuously monitors network connectivity and logs the current number of connected peers,
+// along with the health table summary once the node's heartbeats are running.
 func MonitorNetwork(h host.Host) {
 	log.Println("[INFO] Starting network monitoring...")
 	for {
 		peers := h.Network().Peers()
 		count := len(peers)
-		log.Printf("[INFO] Connected to %d peers", count)
+		if globalADS != nil && globalADS.Host == h {
+			states := make(map[string]int)
+			for _, e := range globalADS.Health.Snapshot() {
+				states[e.State]++
+			}
+			log.Printf("[INFO] Connected to %d peers (%d healthy, %d known, %d unreachable)",
+				count, states[PeerConnected], states[PeerKnown], states[PeerUnreachable])
+		} else {
+			log.Printf("[INFO] Connected to %d peers", count)
+		}
 		if count == 0 {
 			log.Println("[WARN] No peers connected; the network may be isolated")
 		}
@@ -243,6 +390,4 @@ func MonitorNetwork(h host.Host) {
 	}
 }
 
-
-
-// The RegisterNode function uses a default registration endpoint constant (set to http://localhost:8080/register). i need to modify this to read from my configuration.
\ No newline at end of file
+// The RegisterNode function uses a default registration endpoint constant (set to http://localhost:8080/register). i need to modify this to read from my configuration.
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+)
+
+// swarmKeyFileName is the pre-shared key file in the DesVault directory.
+const swarmKeyFileName = "swarm.key"
+
+// SwarmKeyPath returns the location of the private network key.
+func SwarmKeyPath() (string, error) {
+	dir, err := setup.GetDesVaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, swarmKeyFileName), nil
+}
+
+// GenerateSwarmKey returns a new random 32-byte pre-shared key in the
+// standard /key/swarm/psk/1.0.0/ base16 format used by libp2p and IPFS.
+func GenerateSwarmKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate swarm key: %v", err)
+	}
+	return []byte("/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(key) + "\n"), nil
+}
+
+// InstallSwarmKey validates an encoded swarm key and writes it to SwarmKeyPath.
+// An existing key is only replaced when overwrite is set.
+func InstallSwarmKey(data []byte, overwrite bool) (string, error) {
+	if _, err := pnet.DecodeV1PSK(bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("invalid swarm key: %v", err)
+	}
+	path, err := SwarmKeyPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		return "", fmt.Errorf("swarm key already exists at %s", path)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write swarm key: %v", err)
+	}
+	return path, nil
+}
+
+// ReadSwarmKey returns the encoded swarm key file contents.
+func ReadSwarmKey() ([]byte, error) {
+	path, err := SwarmKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// LoadSwarmKey decides whether the node joins a private swarm. It returns the
+// decoded pre-shared key when cfg.PrivateNetwork is set, or nil for the public
+// network. Mixed configurations are refused: private mode without a key, and a
+// swarm.key present while private mode is off.
+func LoadSwarmKey(cfg *setup.Config) (pnet.PSK, error) {
+	data, err := ReadSwarmKey()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read swarm key: %v", err)
+	}
+	hasKey := err == nil
+
+	switch {
+	case cfg.PrivateNetwork && !hasKey:
+		return nil, fmt.Errorf("private network mode is enabled but no swarm key was found; run 'desvault swarm keygen' or 'desvault swarm import'")
+	case !cfg.PrivateNetwork && hasKey:
+		path, _ := SwarmKeyPath()
+		return nil, fmt.Errorf("found %s but private network mode is disabled; enable privateNetwork or remove the key", path)
+	case !cfg.PrivateNetwork:
+		return nil, nil
+	}
+
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid swarm key: %v", err)
+	}
+	return psk, nil
+}
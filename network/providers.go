@@ -0,0 +1,139 @@
+package network
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+)
+
+// ReprovideInterval is how often locally held shards are re-announced in the DHT.
+// Provider records expire after 48 hours, so this keeps them comfortably fresh.
+const ReprovideInterval = 12 * time.Hour
+
+// ShardKey returns the DHT key for a shard. Shard IDs are hex-encoded SHA-256
+// digests of the shard content, so the key is a raw CIDv1 over that digest.
+func ShardKey(shardID string) (cid.Cid, error) {
+	digest, err := hex.DecodeString(shardID)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid shard ID %q: %v", shardID, err)
+	}
+	mh, err := multihash.Encode(digest, multihash.SHA2_256)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to encode multihash for shard %s: %v", shardID, err)
+	}
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
+
+// ProvideShard announces in the DHT that this node holds the given shard.
+func (s *AutoDiscoveryService) ProvideShard(ctx context.Context, shardID string) error {
+	key, err := ShardKey(shardID)
+	if err != nil {
+		return err
+	}
+	if err := s.DHT.Provide(ctx, key, true); err != nil {
+		return fmt.Errorf("failed to provide shard %s: %v", shardID, err)
+	}
+	return nil
+}
+
+// ProvideLocalShards announces every locally stored shard in the DHT.
+func (s *AutoDiscoveryService) ProvideLocalShards(ctx context.Context) {
+	ids, err := storage.ListLocalShards()
+	if err != nil {
+		log.Printf("[ERROR] Failed to list local shards: %v", err)
+		return
+	}
+	provided := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.ProvideShard(ctx, id); err != nil {
+			log.Printf("[WARN] %v", err)
+			continue
+		}
+		provided++
+	}
+	log.Printf("[INFO] Provided %d of %d local shards in the DHT", provided, len(ids))
+}
+
+// StartReprovider provides all local shards now and again every ReprovideInterval.
+func (s *AutoDiscoveryService) StartReprovider(ctx context.Context) {
+	go func() {
+		s.ProvideLocalShards(ctx)
+		ticker := time.NewTicker(ReprovideInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.ProvideLocalShards(ctx)
+			}
+		}
+	}()
+}
+
+// FindShardProviders looks up peers that hold the given shard, excluding this node.
+// At most max providers are returned.
+func (s *AutoDiscoveryService) FindShardProviders(ctx context.Context, shardID string, max int) ([]peer.AddrInfo, error) {
+	key, err := ShardKey(shardID)
+	if err != nil {
+		return nil, err
+	}
+	var providers []peer.AddrInfo
+	for info := range s.DHT.FindProvidersAsync(ctx, key, max+1) {
+		if info.ID == s.Host.ID() {
+			continue
+		}
+		providers = append(providers, info)
+		if len(providers) == max {
+			break
+		}
+	}
+	return providers, nil
+}
+
+// FetchShardFromProviders locates holders of a shard through the DHT and
+// returns the encrypted shard from the first one that serves a valid copy.
+func (s *AutoDiscoveryService) FetchShardFromProviders(ctx context.Context, shardID string) ([]byte, error) {
+	providers, err := s.FindShardProviders(ctx, shardID, 10)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range providers {
+		if err := s.Host.Connect(ctx, info); err != nil {
+			log.Printf("[WARN] Failed to connect to provider %s: %v", info.ID, err)
+			continue
+		}
+		data, err := s.FetchShardFrom(ctx, info.ID, shardID)
+		if err != nil {
+			log.Printf("[WARN] Provider %s could not serve shard %s: %v", info.ID, shardID, err)
+			continue
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("no provider served shard %s (%d found)", shardID, len(providers))
+}
+
+// provideAsync announces a newly stored shard without blocking the caller.
+func provideAsync(shardID string) {
+	if globalADS == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := globalADS.ProvideShard(ctx, shardID); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}()
+}
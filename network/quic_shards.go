@@ -0,0 +1,193 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/encryption"
+)
+
+// quicDialTimeout bounds connecting to a peer's QUIC shard service.
+const quicDialTimeout = 10 * time.Second
+
+// quicShards holds the node's QUIC shard service and its client connections
+// to the services of other peers, one per peer.
+type quicShards struct {
+	port  int
+	certs *encryption.CertManager
+
+	mu      sync.Mutex
+	clients map[peer.ID]*encryption.QUICShardClient
+}
+
+// ServeQUICShards starts the QUIC shard service on port next to the libp2p
+// host and lets the node fetch and push shards over the QUIC services other
+// peers announce. Streams are subject to the same ACL, bans and rate limits
+// as the libp2p shard protocol. The service is not started in a private
+// network, since it is not protected by the swarm key.
+func (s *AutoDiscoveryService) ServeQUICShards(ctx context.Context, port int, certs *encryption.CertManager) {
+	if port <= 0 {
+		return
+	}
+	if s.private {
+		log.Println("[INFO] QUIC shard service disabled in private network mode")
+		return
+	}
+	q := &quicShards{port: port, certs: certs, clients: make(map[peer.ID]*encryption.QUICShardClient)}
+	tlsConfig := encryption.ServerTLSConfig(certs, func(id peer.ID) error {
+		if !s.Gater.PeerAllowed(id) {
+			return fmt.Errorf("peer %s is not allowed by the ACL", id)
+		}
+		return nil
+	})
+	allowStream := func(id peer.ID) error {
+		return s.Gater.CheckStream(id, ShardProtocolID)
+	}
+	listener, err := encryption.ListenShardService(net.JoinHostPort("0.0.0.0", strconv.Itoa(port)), tlsConfig, nil)
+	if err != nil {
+		// Without a listener the port is not announced, so peers keep using libp2p.
+		log.Printf("[ERROR] QUIC shard service unavailable: %v", err)
+		return
+	}
+	go encryption.ServeShardService(listener, allowStream)
+	s.quicMu.Lock()
+	s.quic = q
+	s.quicMu.Unlock()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		q.closeAll()
+	}()
+}
+
+// shardServicePort returns the port of the node's QUIC shard service, or 0.
+func (s *AutoDiscoveryService) shardServicePort() int {
+	if q := s.quicShards(); q != nil {
+		return q.port
+	}
+	return 0
+}
+
+func (s *AutoDiscoveryService) quicShards() *quicShards {
+	s.quicMu.RLock()
+	defer s.quicMu.RUnlock()
+	return s.quic
+}
+
+// quicClient returns a connection to the QUIC shard service of p, or nil if p
+// announces none or is not connected over an IP address.
+func (s *AutoDiscoveryService) quicClient(ctx context.Context, p peer.ID) *encryption.QUICShardClient {
+	q := s.quicShards()
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	c, ok := q.clients[p]
+	q.mu.Unlock()
+	if ok {
+		return c
+	}
+	capacity, ok := s.Capacity.Get(p.String())
+	if !ok || capacity.ShardServicePort <= 0 {
+		return nil
+	}
+	var ip net.IP
+	for _, conn := range s.Host.Network().ConnsToPeer(p) {
+		if addrIP, err := manet.ToIP(conn.RemoteMultiaddr()); err == nil {
+			ip = addrIP
+			break
+		}
+	}
+	if ip == nil {
+		return nil
+	}
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(capacity.ShardServicePort))
+	dialCtx, cancel := context.WithTimeout(ctx, quicDialTimeout)
+	defer cancel()
+	c, err := encryption.DialShardService(dialCtx, addr, encryption.ClientTLSConfig(q.certs, p), nil)
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if existing, ok := q.clients[p]; ok {
+		// Another transfer connected first.
+		c.Close()
+		return existing
+	}
+	q.clients[p] = c
+	return c
+}
+
+// dropQUICClient closes the connection to p's shard service after a failure,
+// so the next transfer dials again.
+func (s *AutoDiscoveryService) dropQUICClient(p peer.ID, c *encryption.QUICShardClient) {
+	q := s.quicShards()
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	if q.clients[p] == c {
+		delete(q.clients, p)
+	}
+	q.mu.Unlock()
+	c.Close()
+}
+
+func (q *quicShards) closeAll() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for p, c := range q.clients {
+		c.Close()
+		delete(q.clients, p)
+	}
+}
+
+// FetchShardFrom retrieves a shard from p over its QUIC shard service when it
+// announces one, falling back to the libp2p shard protocol.
+func (s *AutoDiscoveryService) FetchShardFrom(ctx context.Context, p peer.ID, shardID string) ([]byte, error) {
+	if c := s.quicClient(ctx, p); c != nil {
+		start := time.Now()
+		data, err := c.GetShard(ctx, shardID)
+		recordTransfer(p, start, err)
+		if err == nil {
+			recordAuditPass(p)
+			return data, nil
+		}
+		if errors.Is(err, encryption.ErrCorruptShard) {
+			reportAuditFailure(p, fmt.Sprintf("served corrupt shard %s", shardID))
+			return nil, err
+		}
+		if !errors.Is(err, encryption.ErrShardNotFound) {
+			s.dropQUICClient(p, c)
+		}
+		log.Printf("[WARN] QUIC fetch of shard %s from %s failed, using libp2p: %v", shardID, p, err)
+	}
+	return FetchShard(ctx, s.Host, p, shardID)
+}
+
+// PushShardTo stores a shard on p over its QUIC shard service when it
+// announces one, falling back to the libp2p shard protocol.
+func (s *AutoDiscoveryService) PushShardTo(ctx context.Context, p peer.ID, shardID string, data []byte) error {
+	if c := s.quicClient(ctx, p); c != nil {
+		start := time.Now()
+		err := c.PutShard(ctx, shardID, data)
+		recordTransfer(p, start, err)
+		if err == nil {
+			return nil
+		}
+		s.dropQUICClient(p, c)
+		log.Printf("[WARN] QUIC push of shard %s to %s failed, using libp2p: %v", shardID, p, err)
+	}
+	return PushShard(ctx, s.Host, p, shardID, data)
+}
@@ -0,0 +1,104 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	gonetwork "github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+)
+
+// Connection manager tags for peers that must not be trimmed.
+const (
+	BootstrapPeerTag = "desvault-bootstrap"
+	ShardHolderTag   = "desvault-shard-holder"
+)
+
+// connGracePeriod is how long new connections are exempt from trimming.
+const connGracePeriod = time.Minute
+
+// ResourceUsage reports the host's current resource consumption.
+type ResourceUsage struct {
+	ConnsInbound    int   `json:"connsInbound"`
+	ConnsOutbound   int   `json:"connsOutbound"`
+	StreamsInbound  int   `json:"streamsInbound"`
+	StreamsOutbound int   `json:"streamsOutbound"`
+	FileDescriptors int   `json:"fileDescriptors"`
+	MemoryBytes     int64 `json:"memoryBytes"`
+	LowWater        int   `json:"lowWater"`
+	HighWater       int   `json:"highWater"`
+}
+
+// resourceOptions returns the connection manager and resource manager options
+// for the host. Shard transfers and chat get their own stream and memory limits
+// on top of libp2p's auto-scaled defaults.
+func resourceOptions(cfg *setup.Config) ([]libp2p.Option, error) {
+	if err := validateConnLimits(cfg.ConnLowWater, cfg.ConnHighWater); err != nil {
+		return nil, err
+	}
+	cm, err := connmgr.NewConnManager(cfg.ConnLowWater, cfg.ConnHighWater, connmgr.WithGracePeriod(connGracePeriod))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection manager: %v", err)
+	}
+
+	limits := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&limits)
+	// Shard streams reserve a whole shard before reading it; the protocol
+	// limits below bound how many such reservations a peer may hold.
+	limits.StreamBaseLimit.Memory = MaxShardSize
+	limits.PeerBaseLimit.Memory = max(limits.PeerBaseLimit.Memory, 2*MaxShardSize)
+	limits.AddProtocolLimit(ShardProtocolID,
+		rcmgr.BaseLimit{Streams: 64, StreamsInbound: 32, StreamsOutbound: 32, Memory: 4 * MaxShardSize},
+		rcmgr.BaseLimitIncrease{Streams: 32, StreamsInbound: 16, StreamsOutbound: 16, Memory: MaxShardSize},
+	)
+	limits.AddProtocolPeerLimit(ShardProtocolID,
+		rcmgr.BaseLimit{Streams: 8, StreamsInbound: 4, StreamsOutbound: 4, Memory: 2 * MaxShardSize},
+		rcmgr.BaseLimitIncrease{},
+	)
+	limits.AddProtocolLimit(ChatProtocolID,
+		rcmgr.BaseLimit{Streams: 32, StreamsInbound: 16, StreamsOutbound: 16, Memory: 4 << 20},
+		rcmgr.BaseLimitIncrease{Streams: 16, StreamsInbound: 8, StreamsOutbound: 8, Memory: 1 << 20},
+	)
+	limits.AddProtocolPeerLimit(ChatProtocolID,
+		rcmgr.BaseLimit{Streams: 2, StreamsInbound: 1, StreamsOutbound: 1, Memory: 256 << 10},
+		rcmgr.BaseLimitIncrease{},
+	)
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.AutoScale()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager: %v", err)
+	}
+
+	return []libp2p.Option{libp2p.ConnectionManager(cm), libp2p.ResourceManager(rm)}, nil
+}
+
+// validateConnLimits rejects watermarks the connection manager cannot work
+// with: it trims down to low once high is exceeded.
+func validateConnLimits(low, high int) error {
+	if low <= 0 || high <= 0 {
+		return fmt.Errorf("invalid connection limits: connLowWater/--conn-low (%d) and connHighWater/--conn-high (%d) must be positive", low, high)
+	}
+	if low >= high {
+		return fmt.Errorf("invalid connection limits: connLowWater/--conn-low (%d) must be below connHighWater/--conn-high (%d)", low, high)
+	}
+	return nil
+}
+
+// ResourceUsage returns the system-wide resource usage of the host.
+func (s *AutoDiscoveryService) ResourceUsage() ResourceUsage {
+	usage := ResourceUsage{LowWater: s.connLow, HighWater: s.connHigh}
+	_ = s.Host.Network().ResourceManager().ViewSystem(func(scope gonetwork.ResourceScope) error {
+		stat := scope.Stat()
+		usage.ConnsInbound = stat.NumConnsInbound
+		usage.ConnsOutbound = stat.NumConnsOutbound
+		usage.StreamsInbound = stat.NumStreamsInbound
+		usage.StreamsOutbound = stat.NumStreamsOutbound
+		usage.FileDescriptors = stat.NumFD
+		usage.MemoryBytes = stat.Memory
+		return nil
+	})
+	return usage
+}
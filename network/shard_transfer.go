@@ -0,0 +1,248 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	gonetwork "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+)
+
+// ShardProtocolID is the libp2p protocol used to fetch and store shard replicas.
+const ShardProtocolID = "/desvault/shard/1.0.0"
+
+// MaxShardSize bounds the size of a shard accepted over the shard protocol.
+// Files are capped at 500 MB and split into 5 shards, plus encryption overhead.
+const MaxShardSize = 128 << 20
+
+// Shard protocol operations and response codes.
+const (
+	shardOpGet byte = 'G'
+	shardOpPut byte = 'P'
+
+	shardStatusOK       byte = 0
+	shardStatusNotFound byte = 1
+	shardStatusError    byte = 2
+)
+
+const shardStreamTimeout = 2 * time.Minute
+
+// handleShardStream serves a single shard request. A request is an operation byte
+// followed by the newline-terminated shard ID; PUT requests then carry a
+// big-endian uint64 length and the encrypted shard bytes. The response is a
+// status byte, followed for successful GETs by a length and the shard bytes.
+func handleShardStream(stream gonetwork.Stream) {
+	if !checkFlood(stream, ShardProtocolID) {
+		return
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(shardStreamTimeout))
+	remote := stream.Conn().RemotePeer()
+
+	reader := bufio.NewReader(stream)
+	op, err := reader.ReadByte()
+	if err != nil {
+		log.Printf("[ERROR] Failed to read shard request from %s: %v", remote, err)
+		return
+	}
+	shardID, err := reader.ReadString('\n')
+	if err != nil {
+		log.Printf("[ERROR] Failed to read shard ID from %s: %v", remote, err)
+		return
+	}
+	shardID = strings.TrimSpace(shardID)
+
+	switch op {
+	case shardOpGet:
+		data, err := storage.ReadLocalShard(shardID)
+		if err != nil {
+			stream.Write([]byte{shardStatusNotFound})
+			return
+		}
+		// Charge the shard against the protocol's memory limit while it is sent.
+		if err := stream.Scope().ReserveMemory(len(data), gonetwork.ReservationPriorityAlways); err != nil {
+			log.Printf("[WARN] Refused to serve shard %s to %s: %v", shardID, remote, err)
+			stream.Write([]byte{shardStatusError})
+			return
+		}
+		defer stream.Scope().ReleaseMemory(len(data))
+		if _, err := stream.Write([]byte{shardStatusOK}); err != nil {
+			log.Printf("[ERROR] Failed to send shard %s to %s: %v", shardID, remote, err)
+			return
+		}
+		if err := writeShardPayload(stream, data); err != nil {
+			log.Printf("[ERROR] Failed to send shard %s to %s: %v", shardID, remote, err)
+			return
+		}
+		log.Printf("[INFO] Served shard %s to %s", shardID, remote)
+	case shardOpPut:
+		length, err := readShardLength(reader)
+		if err != nil {
+			log.Printf("[ERROR] Failed to receive shard %s from %s: %v", shardID, remote, err)
+			stream.Write([]byte{shardStatusError})
+			return
+		}
+		release, err := storage.ReserveSpace(int64(length))
+		if err != nil {
+			log.Printf("[WARN] Refused shard %s from %s: %v", shardID, remote, err)
+			stream.Write([]byte{shardStatusError})
+			return
+		}
+		defer release()
+		if err := stream.Scope().ReserveMemory(int(length), gonetwork.ReservationPriorityAlways); err != nil {
+			log.Printf("[WARN] Refused shard %s from %s: %v", shardID, remote, err)
+			stream.Write([]byte{shardStatusError})
+			return
+		}
+		defer stream.Scope().ReleaseMemory(int(length))
+		data, err := readShardData(reader, length)
+		if err != nil {
+			log.Printf("[ERROR] Failed to receive shard %s from %s: %v", shardID, remote, err)
+			stream.Write([]byte{shardStatusError})
+			return
+		}
+		if err := storage.WriteLocalShard(shardID, data); err != nil {
+			log.Printf("[ERROR] Rejected shard %s from %s: %v", shardID, remote, err)
+			if storage.VerifyShard(shardID, data) != nil {
+				reportAuditFailure(remote, fmt.Sprintf("pushed corrupt shard %s", shardID))
+			}
+			stream.Write([]byte{shardStatusError})
+			return
+		}
+		stream.Write([]byte{shardStatusOK})
+		log.Printf("[INFO] Stored shard %s pushed by %s", shardID, remote)
+	default:
+		log.Printf("[WARN] Unknown shard operation %q from %s", op, remote)
+		stream.Write([]byte{shardStatusError})
+	}
+}
+
+// FetchShard retrieves the encrypted bytes of a shard from a remote peer.
+func FetchShard(ctx context.Context, h host.Host, p peer.ID, shardID string) (data []byte, err error) {
+	start := time.Now()
+	defer func() { recordTransfer(p, start, err) }()
+
+	stream, err := h.NewStream(ctx, p, ShardProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open shard stream to %s: %v", p, err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(shardStreamTimeout))
+
+	if _, err := stream.Write(append([]byte{shardOpGet}, shardID+"\n"...)); err != nil {
+		return nil, fmt.Errorf("failed to send shard request: %v", err)
+	}
+	reader := bufio.NewReader(stream)
+	status, err := reader.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shard response: %v", err)
+	}
+	if status != shardStatusOK {
+		return nil, fmt.Errorf("peer %s does not hold shard %s (status %d)", p, shardID, status)
+	}
+	data, err = readShardPayload(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.VerifyShard(shardID, data); err != nil {
+		reportAuditFailure(p, fmt.Sprintf("served corrupt shard %s", shardID))
+		return nil, err
+	}
+	recordAuditPass(p)
+	return data, nil
+}
+
+// PushShard stores the encrypted bytes of a shard on a remote peer.
+func PushShard(ctx context.Context, h host.Host, p peer.ID, shardID string, data []byte) (err error) {
+	start := time.Now()
+	defer func() { recordTransfer(p, start, err) }()
+
+	stream, err := h.NewStream(ctx, p, ShardProtocolID)
+	if err != nil {
+		return fmt.Errorf("failed to open shard stream to %s: %v", p, err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(shardStreamTimeout))
+
+	if _, err := stream.Write(append([]byte{shardOpPut}, shardID+"\n"...)); err != nil {
+		return fmt.Errorf("failed to send shard request: %v", err)
+	}
+	if err := writeShardPayload(stream, data); err != nil {
+		return fmt.Errorf("failed to send shard data: %v", err)
+	}
+	if err := stream.CloseWrite(); err != nil {
+		return fmt.Errorf("failed to finish shard upload: %v", err)
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(stream, status); err != nil {
+		return fmt.Errorf("failed to read shard response: %v", err)
+	}
+	if status[0] != shardStatusOK {
+		return fmt.Errorf("peer %s rejected shard %s (status %d)", p, shardID, status[0])
+	}
+	return nil
+}
+
+// writeShardPayload writes a length-prefixed shard payload.
+func writeShardPayload(w io.Writer, data []byte) error {
+	header := binary.BigEndian.AppendUint64(nil, uint64(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readShardPayload reads a length-prefixed shard payload.
+func readShardPayload(r io.Reader) ([]byte, error) {
+	length, err := readShardLength(r)
+	if err != nil {
+		return nil, err
+	}
+	return readShardData(r, length)
+}
+
+// readShardLength reads and bounds the length prefix of a shard payload.
+func readShardLength(r io.Reader) (uint64, error) {
+	var length uint64
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return 0, fmt.Errorf("failed to read shard length: %v", err)
+	}
+	if length > MaxShardSize {
+		return 0, fmt.Errorf("shard of %d bytes exceeds limit of %d bytes", length, MaxShardSize)
+	}
+	return length, nil
+}
+
+// readShardData reads the shard bytes following a length prefix.
+func readShardData(r io.Reader, length uint64) ([]byte, error) {
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read shard data: %v", err)
+	}
+	return data, nil
+}
+
+// recordTransfer reports the outcome and latency of a shard transfer to the
+// global node's reputation service, if running.
+func recordTransfer(p peer.ID, start time.Time, err error) {
+	if globalADS != nil {
+		globalADS.Reputation.RecordTransfer(p.String(), err == nil, time.Since(start))
+	}
+}
+
+// recordAuditPass reports that a peer served a shard matching its ID.
+func recordAuditPass(p peer.ID) {
+	if globalADS != nil {
+		globalADS.Reputation.RecordAudit(p.String(), true)
+	}
+}
@@ -0,0 +1,28 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+)
+
+// ListenAddrs builds the host's listen multiaddrs from cfg: TCP and QUIC-v1 on
+// IPv4 and, when enabled, IPv6, plus WebTransport if requested. WebTransport
+// shares the UDP socket with QUIC when both use the same port.
+func ListenAddrs(cfg *setup.Config) []string {
+	networks := []string{"/ip4/0.0.0.0"}
+	if cfg.EnableIPv6 {
+		networks = append(networks, "/ip6/::")
+	}
+	var addrs []string
+	for _, n := range networks {
+		addrs = append(addrs, fmt.Sprintf("%s/tcp/%d", n, cfg.TCPPort))
+		if cfg.QUICPort > 0 {
+			addrs = append(addrs, fmt.Sprintf("%s/udp/%d/quic-v1", n, cfg.QUICPort))
+		}
+		if cfg.EnableWebTransport && cfg.WebTransportPort > 0 {
+			addrs = append(addrs, fmt.Sprintf("%s/udp/%d/quic-v1/webtransport", n, cfg.WebTransportPort))
+		}
+	}
+	return addrs
+}
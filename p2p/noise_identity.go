@@ -0,0 +1,360 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/encryption"
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+)
+
+const (
+	// noiseKeyDomain separates Noise static key signatures from other uses of the node key.
+	noiseKeyDomain = "desvault-noise-static-key:"
+	// knownNoisePeersFile stores the Noise static keys seen for each peer ID.
+	knownNoisePeersFile = "noise_known_peers.json"
+	// noiseHandshakeTimeout bounds the handshake of dialed and accepted connections.
+	noiseHandshakeTimeout = 10 * time.Second
+	// knownNoisePeerSaveInterval limits how often LastSeen of a known peer is saved.
+	knownNoisePeerSaveInterval = time.Hour
+)
+
+// ErrNoiseKeyChanged is returned when a peer presents a Noise static key other
+// than the one pinned for it.
+var ErrNoiseKeyChanged = errors.New("noise static key changed")
+
+// NoiseHandshakePayload is sent in the handshake to bind the Noise static key
+// to a libp2p identity.
+type NoiseHandshakePayload struct {
+	IdentityKey []byte `json:"identityKey"` // libp2p public key, protobuf encoded
+	Signature   []byte `json:"signature"`   // Signature over noiseKeyDomain || static public key
+}
+
+// NoiseIdentity is the node's Noise static key together with the signed
+// payload proving it belongs to the node's libp2p identity.
+type NoiseIdentity struct {
+	PeerID  peer.ID
+	Static  noise.DHKey
+	Payload []byte
+	Known   *KnownNoisePeers
+}
+
+// LoadNoiseIdentity derives the node's Noise static key from its persistent
+// libp2p identity and signs it, so the key is stable across restarts.
+func LoadNoiseIdentity() (*NoiseIdentity, error) {
+	priv, err := encryption.LoadOrCreateIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %v", err)
+	}
+	known, err := LoadKnownNoisePeers()
+	if err != nil {
+		return nil, err
+	}
+	id, err := NewNoiseIdentity(priv)
+	if err != nil {
+		return nil, err
+	}
+	id.Known = known
+	return id, nil
+}
+
+// NewNoiseIdentity derives a Noise static key from priv and signs it with priv.
+func NewNoiseIdentity(priv crypto.PrivKey) (*NoiseIdentity, error) {
+	raw, err := priv.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity key: %v", err)
+	}
+	seed := sha256.Sum256(append([]byte(noiseKeyDomain), raw...))
+	static, err := noise.DH25519.GenerateKeypair(bytes.NewReader(seed[:]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive noise static key: %v", err)
+	}
+
+	sig, err := priv.Sign(append([]byte(noiseKeyDomain), static.Public...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign noise static key: %v", err)
+	}
+	pub, err := crypto.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal identity key: %v", err)
+	}
+	payload, err := json.Marshal(NoiseHandshakePayload{IdentityKey: pub, Signature: sig})
+	if err != nil {
+		return nil, err
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return &NoiseIdentity{PeerID: id, Static: static, Payload: payload}, nil
+}
+
+// VerifyNoisePayload checks that payload carries a valid signature over the
+// remote static key and returns the peer ID that signed it.
+func VerifyNoisePayload(remoteStatic, payload []byte) (peer.ID, error) {
+	if len(remoteStatic) == 0 {
+		return "", errors.New("remote party sent no static key")
+	}
+	var p NoiseHandshakePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", fmt.Errorf("malformed handshake payload: %v", err)
+	}
+	pub, err := crypto.UnmarshalPublicKey(p.IdentityKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid identity key: %v", err)
+	}
+	ok, err := pub.Verify(append([]byte(noiseKeyDomain), remoteStatic...), p.Signature)
+	if err != nil || !ok {
+		return "", errors.New("noise static key is not signed by the presented identity")
+	}
+	return peer.IDFromPublicKey(pub)
+}
+
+// Config returns a NoiseConfig that sends this identity's payload and
+// authenticates the remote party. If expected is not empty the remote party
+// must prove that peer ID. With NK, the initiator has no static key, so the
+// responder accepts it anonymously when expected is empty.
+func (id *NoiseIdentity) Config(pattern noise.HandshakePattern, remoteStatic []byte, expected peer.ID) NoiseConfig {
+	cfg := NoiseConfig{
+		Pattern:      pattern,
+		StaticKey:    id.Static,
+		RemoteStatic: remoteStatic,
+		Payload:      id.Payload,
+		VerifyPeer: func(remoteStatic, payload []byte) error {
+			if len(remoteStatic) == 0 && expected == "" {
+				return nil
+			}
+			pid, err := VerifyNoisePayload(remoteStatic, payload)
+			if err != nil {
+				return err
+			}
+			if expected != "" && pid != expected {
+				return fmt.Errorf("remote identity %s does not match expected %s", pid, expected)
+			}
+			return id.Known.Check(pid, remoteStatic)
+		},
+	}
+	if pattern.Name == noise.HandshakeNK.Name && len(remoteStatic) > 0 {
+		// NK initiators have no static key of their own.
+		cfg.StaticKey = noise.DHKey{}
+	}
+	return cfg
+}
+
+// NoiseRemotePeer returns the authenticated peer ID of an established connection.
+func NoiseRemotePeer(c *NoiseConn) (peer.ID, error) {
+	return VerifyNoisePayload(c.RemoteStatic(), c.HandshakePayload())
+}
+
+// -----------------------------------------------------------------------------
+// Transport
+// -----------------------------------------------------------------------------
+
+// Dial connects to addr and runs an XX handshake as initiator. The remote
+// party must prove the expected peer ID, if not empty, and present the static
+// key pinned for it.
+func (id *NoiseIdentity) Dial(network, addr string, expected peer.ID) (*NoiseConn, error) {
+	conn, err := net.DialTimeout(network, addr, noiseHandshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %v", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(noiseHandshakeTimeout))
+	nc, err := NewNoiseClient(conn, id.Config(noise.HandshakeXX, nil, expected))
+	if err != nil {
+		return nil, fmt.Errorf("noise handshake with %s failed: %w", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return nc, nil
+}
+
+// Listen accepts connections on addr that complete an XX handshake with an
+// authenticated peer. Handshakes run concurrently, so a slow or silent client
+// does not hold up the others.
+func (id *NoiseIdentity) Listen(network, addr string) (*NoiseListener, error) {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	nl := &NoiseListener{
+		Listener: l,
+		id:       id,
+		conns:    make(chan *NoiseConn),
+		done:     make(chan struct{}),
+	}
+	go nl.acceptLoop()
+	return nl, nil
+}
+
+// NoiseListener is a net.Listener whose connections are *NoiseConn.
+type NoiseListener struct {
+	net.Listener
+	id *NoiseIdentity
+
+	conns     chan *NoiseConn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error // Set before done is closed
+}
+
+// acceptLoop accepts raw connections and runs each handshake in its own
+// goroutine, handing completed connections to Accept.
+func (l *NoiseListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.shutdown(err)
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *NoiseListener) handshake(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(noiseHandshakeTimeout))
+	nc, err := NewNoiseServer(conn, l.id.Config(noise.HandshakeXX, nil, ""))
+	if err != nil {
+		log.Printf("[!] Rejected noise connection from %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	select {
+	case l.conns <- nc:
+	case <-l.done:
+		nc.Close()
+	}
+}
+
+func (l *NoiseListener) shutdown(err error) {
+	l.closeOnce.Do(func() {
+		l.err = err
+		close(l.done)
+	})
+}
+
+// Accept waits for the next connection whose handshake succeeds. Connections
+// that fail the handshake, including peers whose pinned key changed, are
+// closed and logged.
+func (l *NoiseListener) Accept() (net.Conn, error) {
+	select {
+	case nc := <-l.conns:
+		return nc, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close stops accepting connections. Handshakes still in progress are
+// abandoned once they complete.
+func (l *NoiseListener) Close() error {
+	l.shutdown(net.ErrClosed)
+	return l.Listener.Close()
+}
+
+// -----------------------------------------------------------------------------
+// Known Peers
+// -----------------------------------------------------------------------------
+
+// KnownNoisePeer records the Noise static key last seen for a peer.
+type KnownNoisePeer struct {
+	StaticKey string    `json:"staticKey"` // Hex encoded
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"` // Saved at most once per knownNoisePeerSaveInterval
+}
+
+// KnownNoisePeers is a trust-on-first-use store of peer Noise static keys.
+// A key change is rejected until the old key is forgotten.
+type KnownNoisePeers struct {
+	mu    sync.Mutex
+	path  string
+	peers map[string]KnownNoisePeer
+}
+
+// LoadKnownNoisePeers reads the known peers store from the DesVault directory.
+func LoadKnownNoisePeers() (*KnownNoisePeers, error) {
+	dir, err := setup.GetDesVaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return loadKnownNoisePeers(filepath.Join(dir, knownNoisePeersFile))
+}
+
+func loadKnownNoisePeers(path string) (*KnownNoisePeers, error) {
+	k := &KnownNoisePeers{
+		path:  path,
+		peers: make(map[string]KnownNoisePeer),
+	}
+	data, err := os.ReadFile(k.path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known noise peers: %v", err)
+	}
+	if err := json.Unmarshal(data, &k.peers); err != nil {
+		return nil, fmt.Errorf("failed to parse known noise peers: %v", err)
+	}
+	return k, nil
+}
+
+// Check pins the static key of an authenticated peer on first use and returns
+// ErrNoiseKeyChanged if it differs from the pinned key. A nil store accepts
+// every key.
+func (k *KnownNoisePeers) Check(p peer.ID, static []byte) error {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	key := hex.EncodeToString(static)
+	entry, ok := k.peers[p.String()]
+	if ok && entry.StaticKey != key {
+		log.Printf("[!] WARNING: Noise static key of peer %s changed (pinned %s, presented %s)", p, entry.StaticKey, key)
+		return fmt.Errorf("%w for peer %s", ErrNoiseKeyChanged, p)
+	}
+	// Only a newly pinned key must reach the disk right away; LastSeen is
+	// written at most once per knownNoisePeerSaveInterval for each peer.
+	save := !ok || now.Sub(entry.LastSeen) >= knownNoisePeerSaveInterval
+	if !ok {
+		entry = KnownNoisePeer{StaticKey: key, FirstSeen: now}
+	}
+	if save {
+		entry.LastSeen = now
+		k.peers[p.String()] = entry
+		if err := k.save(); err != nil {
+			log.Printf("[!] Failed to save known noise peers: %v", err)
+		}
+	}
+	return nil
+}
+
+// Forget removes the pinned key of a peer, so the next key it presents is
+// accepted. Use it after the peer has legitimately rotated its identity.
+func (k *KnownNoisePeers) Forget(p peer.ID) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.peers, p.String())
+	return k.save()
+}
+
+func (k *KnownNoisePeers) save() error {
+	data, err := json.MarshalIndent(k.peers, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(k.path, data, 0600)
+}
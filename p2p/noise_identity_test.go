@@ -0,0 +1,214 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func newTestIdentity(t *testing.T) *NoiseIdentity {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate identity key: %v", err)
+	}
+	id, err := NewNoiseIdentity(priv)
+	if err != nil {
+		t.Fatalf("failed to create noise identity: %v", err)
+	}
+	return id
+}
+
+func newTestKnownPeers(t *testing.T) *KnownNoisePeers {
+	t.Helper()
+	k, err := loadKnownNoisePeers(filepath.Join(t.TempDir(), knownNoisePeersFile))
+	if err != nil {
+		t.Fatalf("failed to create known peers store: %v", err)
+	}
+	return k
+}
+
+func TestVerifyNoisePayload(t *testing.T) {
+	id := newTestIdentity(t)
+	pid, err := VerifyNoisePayload(id.Static.Public, id.Payload)
+	if err != nil {
+		t.Fatalf("valid payload rejected: %v", err)
+	}
+	if pid != id.PeerID {
+		t.Errorf("got peer %s, want %s", pid, id.PeerID)
+	}
+
+	// The payload only vouches for the static key it signed.
+	other := mustKeypair(t)
+	if _, err := VerifyNoisePayload(other.Public, id.Payload); err == nil {
+		t.Error("payload accepted for a different static key")
+	}
+
+	var p NoiseHandshakePayload
+	if err := json.Unmarshal(id.Payload, &p); err != nil {
+		t.Fatal(err)
+	}
+	p.Signature[0] ^= 0xff
+	tampered, _ := json.Marshal(p)
+	if _, err := VerifyNoisePayload(id.Static.Public, tampered); err == nil {
+		t.Error("payload with a corrupted signature accepted")
+	}
+}
+
+func TestNoiseIdentityExpectedPeer(t *testing.T) {
+	client, server, stranger := newTestIdentity(t), newTestIdentity(t), newTestIdentity(t)
+	a, b := net.Pipe()
+	defer a.Close()
+
+	go NewNoiseServer(b, server.Config(noise.HandshakeXX, nil, ""))
+	_, err := NewNoiseClient(a, client.Config(noise.HandshakeXX, nil, stranger.PeerID))
+	if err == nil {
+		t.Fatal("handshake succeeded with a server that is not the expected peer")
+	}
+}
+
+func TestKnownNoisePeersPinning(t *testing.T) {
+	k := newTestKnownPeers(t)
+	id := newTestIdentity(t)
+	original, rotated := mustKeypair(t), mustKeypair(t)
+
+	if err := k.Check(id.PeerID, original.Public); err != nil {
+		t.Fatalf("first key rejected: %v", err)
+	}
+	if err := k.Check(id.PeerID, original.Public); err != nil {
+		t.Fatalf("pinned key rejected: %v", err)
+	}
+	if err := k.Check(id.PeerID, rotated.Public); !errors.Is(err, ErrNoiseKeyChanged) {
+		t.Fatalf("changed key returned %v, want %v", err, ErrNoiseKeyChanged)
+	}
+
+	// The rejected key must not replace the pinned one, also after a reload.
+	reloaded, err := loadKnownNoisePeers(k.path)
+	if err != nil {
+		t.Fatalf("failed to reload store: %v", err)
+	}
+	if err := reloaded.Check(id.PeerID, original.Public); err != nil {
+		t.Fatalf("pinned key rejected after reload: %v", err)
+	}
+
+	if err := reloaded.Forget(id.PeerID); err != nil {
+		t.Fatalf("failed to forget peer: %v", err)
+	}
+	if err := reloaded.Check(id.PeerID, rotated.Public); err != nil {
+		t.Fatalf("new key rejected after forgetting the old one: %v", err)
+	}
+}
+
+func TestNoiseListenerRejectsChangedKey(t *testing.T) {
+	server, client := newTestIdentity(t), newTestIdentity(t)
+	server.Known = newTestKnownPeers(t)
+	client.Known = newTestKnownPeers(t)
+
+	l, err := server.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan peer.ID, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			pid, _ := NoiseRemotePeer(conn.(*NoiseConn))
+			accepted <- pid
+			conn.Close()
+		}
+	}()
+
+	conn, err := client.Dial("tcp", l.Addr().String(), server.PeerID)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+	if pid := <-accepted; pid != client.PeerID {
+		t.Fatalf("server authenticated %s, want %s", pid, client.PeerID)
+	}
+
+	// Pin a different key for the client, as if it had been seen before with
+	// another static key; the server must now refuse it.
+	if err := server.Known.Forget(client.PeerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Known.Check(client.PeerID, mustKeypair(t).Public); err != nil {
+		t.Fatal(err)
+	}
+	conn, err = client.Dial("tcp", l.Addr().String(), server.PeerID)
+	if err != nil {
+		// The XX initiator may also see the server close the connection.
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("server kept a connection from a peer whose key changed")
+	}
+	select {
+	case pid := <-accepted:
+		t.Errorf("server accepted %s despite the key change", pid)
+	default:
+	}
+}
+
+func TestNoiseListenerSlowHandshake(t *testing.T) {
+	server, client := newTestIdentity(t), newTestIdentity(t)
+	l, err := server.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	// A client that connects but never sends its handshake must not hold up
+	// the others.
+	stalled, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := client.Dial("tcp", l.Addr().String(), server.PeerID)
+		if err == nil {
+			conn.Close()
+		}
+		dialed <- err
+	}()
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatalf("accept failed: %v", err)
+		}
+	case <-time.After(noiseHandshakeTimeout / 2):
+		t.Fatal("handshake blocked behind a stalled connection")
+	}
+	if err := <-dialed; err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after Close returned %v, want %v", err, net.ErrClosed)
+	}
+}
@@ -1,118 +1,323 @@
 package p2p
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"sync"
 
 	"github.com/flynn/noise"
-	"crypto/rand"
 )
 
-// SecureChannel holds the cipher states for sending and receiving encrypted messages.
-type SecureChannel struct {
-	SendCipher *noise.CipherState
-	RecvCipher *noise.CipherState
+const (
+	// maxNoiseMessage is the largest Noise message, bounded by the 2-byte length prefix.
+	maxNoiseMessage = 65535
+	// maxNoisePlaintext leaves room for the 16-byte authentication tag.
+	maxNoisePlaintext = maxNoiseMessage - 16
+	// DefaultRekeyInterval is the number of messages after which each direction rekeys.
+	DefaultRekeyInterval = 1 << 16
+)
+
+// noiseCipherSuite is used for every DesVault Noise session.
+var noiseCipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+
+// NoiseConfig configures a Noise session.
+type NoiseConfig struct {
+	// Pattern is the handshake pattern: noise.HandshakeXX, noise.HandshakeIK or
+	// noise.HandshakeNK. Defaults to XX.
+	Pattern noise.HandshakePattern
+	// StaticKey is the local static keypair. It is required for XX, for IK on
+	// both sides and for the NK responder.
+	StaticKey noise.DHKey
+	// RemoteStatic is the responder's static public key, required by IK and NK initiators.
+	RemoteStatic []byte
+	// Prologue is data both sides must agree on; it is mixed into the handshake hash.
+	Prologue []byte
+	// Payload is sent, encrypted, in the last handshake message this side writes.
+	Payload []byte
+	// RekeyInterval is the number of messages after which each direction
+	// rekeys. Zero means DefaultRekeyInterval.
+	RekeyInterval uint64
+	// VerifyPeer, if set, is called with the remote static key (nil when the
+	// pattern does not transmit one) and the remote handshake payload.
+	// Returning an error aborts the handshake.
+	VerifyPeer func(remoteStatic, payload []byte) error
 }
 
-// PerformHandshakeInitiator performs a complete Noise XX handshake as the initiator
-// over the provided connection. It exchanges three handshake messages and returns
-// a SecureChannel containing the cipher states for secure communication.
-func PerformHandshakeInitiator(conn io.ReadWriter, payload []byte) (*SecureChannel, error) {
-	config := noise.Config{
-		Pattern:     noise.HandshakeXX,
-		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256),
-		Random:      rand.Reader,
-		Initiator:   true,
+// GenerateNoiseKeypair returns a new Curve25519 static keypair.
+func GenerateNoiseKeypair() (noise.DHKey, error) {
+	return noiseCipherSuite.GenerateKeypair(rand.Reader)
+}
+
+// -----------------------------------------------------------------------------
+// Framing
+// -----------------------------------------------------------------------------
+
+// writeNoiseFrame writes a message prefixed with its 2-byte big-endian length.
+func writeNoiseFrame(w io.Writer, msg []byte) error {
+	if len(msg) > maxNoiseMessage {
+		return fmt.Errorf("noise message of %d bytes exceeds %d", len(msg), maxNoiseMessage)
 	}
-	hs, err := noise.NewHandshakeState(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create handshake state: %v", err)
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readNoiseFrame reads one length-prefixed message, however the bytes arrive.
+func readNoiseFrame(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
 	}
+	return msg, nil
+}
 
-	// --- Message 1: Initiator -> Responder ---
-	msg1, _, _, err := hs.WriteMessage(nil, payload)
+// -----------------------------------------------------------------------------
+// Handshake
+// -----------------------------------------------------------------------------
+
+// handshakeResult is the outcome of a completed Noise handshake.
+type handshakeResult struct {
+	send, recv    *noise.CipherState
+	remoteStatic  []byte
+	remotePayload []byte
+}
+
+// runHandshake drives any two-party Noise pattern over framed messages. The
+// payload is sent in the last message this side writes, which is always
+// encrypted for the supported patterns.
+func runHandshake(rw io.ReadWriter, cfg NoiseConfig, initiator bool) (*handshakeResult, error) {
+	if cfg.Pattern.Name == "" {
+		cfg.Pattern = noise.HandshakeXX
+	}
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   noiseCipherSuite,
+		Random:        rand.Reader,
+		Pattern:       cfg.Pattern,
+		Initiator:     initiator,
+		Prologue:      cfg.Prologue,
+		StaticKeypair: cfg.StaticKey,
+		PeerStatic:    cfg.RemoteStatic,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to write handshake message 1: %v", err)
+		return nil, fmt.Errorf("failed to create handshake state: %v", err)
 	}
-	if _, err := conn.Write(msg1); err != nil {
-		return nil, fmt.Errorf("failed to send handshake message 1: %v", err)
+
+	total := len(cfg.Pattern.Messages)
+	lastOwn := total - 1
+	if (lastOwn%2 == 0) != initiator {
+		lastOwn--
 	}
 
-	// --- Message 2: Responder -> Initiator ---
-	buf := make([]byte, 1024)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read handshake message 2: %v", err)
+	res := &handshakeResult{}
+	var cs1, cs2 *noise.CipherState
+	for i := 0; i < total; i++ {
+		if (i%2 == 0) == initiator {
+			var payload []byte
+			if i == lastOwn {
+				payload = cfg.Payload
+			}
+			var msg []byte
+			msg, cs1, cs2, err = hs.WriteMessage(nil, payload)
+			if err != nil {
+				return nil, fmt.Errorf("failed to write handshake message %d: %v", i+1, err)
+			}
+			if err := writeNoiseFrame(rw, msg); err != nil {
+				return nil, fmt.Errorf("failed to send handshake message %d: %v", i+1, err)
+			}
+		} else {
+			msg, err := readNoiseFrame(rw)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read handshake message %d: %v", i+1, err)
+			}
+			var payload []byte
+			payload, cs1, cs2, err = hs.ReadMessage(nil, msg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to process handshake message %d: %v", i+1, err)
+			}
+			if len(payload) > 0 {
+				res.remotePayload = payload
+			}
+		}
 	}
-	_, _, _, err = hs.ReadMessage(nil, buf[:n])
-	if err != nil {
-		return nil, fmt.Errorf("failed to process handshake message 2: %v", err)
+	if cs1 == nil || cs2 == nil {
+		return nil, errors.New("handshake did not complete")
 	}
 
-	// --- Message 3: Initiator -> Responder ---
-	msg3, csSend, csRecv, err := hs.WriteMessage(nil, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write handshake message 3: %v", err)
+	res.remoteStatic = hs.PeerStatic()
+	if cfg.VerifyPeer != nil {
+		if err := cfg.VerifyPeer(res.remoteStatic, res.remotePayload); err != nil {
+			return nil, fmt.Errorf("peer verification failed: %w", err)
+		}
 	}
-	if _, err := conn.Write(msg3); err != nil {
-		return nil, fmt.Errorf("failed to send handshake message 3: %v", err)
+	// The first cipher state always encrypts initiator-to-responder traffic.
+	if initiator {
+		res.send, res.recv = cs1, cs2
+	} else {
+		res.send, res.recv = cs2, cs1
 	}
+	return res, nil
+}
 
-	// Return both cipher states for encryption (send) and decryption (receive)
-	return &SecureChannel{
-		SendCipher: csSend,
-		RecvCipher: csRecv,
-	}, nil
+// SecureChannel holds the cipher states for sending and receiving encrypted messages.
+type SecureChannel struct {
+	SendCipher *noise.CipherState
+	RecvCipher *noise.CipherState
 }
 
-// PerformHandshakeResponder performs a complete Noise XX handshake as the responder
-// over the provided connection. It processes the initiator's messages, responds
-// accordingly, and returns a SecureChannel with the cipher states.
-func PerformHandshakeResponder(conn io.ReadWriter, payload []byte) (*SecureChannel, error) {
-	config := noise.Config{
-		Pattern:     noise.HandshakeXX,
-		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256),
-		Random:      rand.Reader,
-		Initiator:   false,
+// PerformHandshakeInitiator performs a complete Noise XX handshake as the initiator
+// over the provided connection with a fresh static key. Handshake messages are
+// length-prefixed. It returns a SecureChannel containing the cipher states.
+// New code should use NewNoiseClient, which also frames transport messages.
+func PerformHandshakeInitiator(conn io.ReadWriter, payload []byte) (*SecureChannel, error) {
+	key, err := GenerateNoiseKeypair()
+	if err != nil {
+		return nil, err
 	}
-	hs, err := noise.NewHandshakeState(config)
+	res, err := runHandshake(conn, NoiseConfig{StaticKey: key, Payload: payload}, true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create handshake state: %v", err)
+		return nil, err
 	}
+	return &SecureChannel{SendCipher: res.send, RecvCipher: res.recv}, nil
+}
 
-	// --- Message 1: Initiator -> Responder ---
-	buf := make([]byte, 1024)
-	n, err := conn.Read(buf)
+// PerformHandshakeResponder performs a complete Noise XX handshake as the responder
+// over the provided connection with a fresh static key. Handshake messages are
+// length-prefixed. It returns a SecureChannel containing the cipher states.
+// New code should use NewNoiseServer, which also frames transport messages.
+func PerformHandshakeResponder(conn io.ReadWriter, payload []byte) (*SecureChannel, error) {
+	key, err := GenerateNoiseKeypair()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read handshake message 1: %v", err)
+		return nil, err
 	}
-	_, _, _, err = hs.ReadMessage(nil, buf[:n])
+	res, err := runHandshake(conn, NoiseConfig{StaticKey: key, Payload: payload}, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to process handshake message 1: %v", err)
+		return nil, err
 	}
+	return &SecureChannel{SendCipher: res.send, RecvCipher: res.recv}, nil
+}
+
+// -----------------------------------------------------------------------------
+// Noise Connection
+// -----------------------------------------------------------------------------
+
+// NoiseConn is a net.Conn that encrypts all traffic with Noise. Every Write is
+// split into length-prefixed messages of at most 65535 bytes; Read returns
+// decrypted bytes and buffers whatever does not fit into the caller's slice.
+type NoiseConn struct {
+	net.Conn
+
+	rekeyInterval uint64
+	remoteStatic  []byte
+	remotePayload []byte
+
+	writeMu   sync.Mutex
+	send      *noise.CipherState
+	sendCount uint64
+
+	readMu    sync.Mutex
+	recv      *noise.CipherState
+	recvCount uint64
+	pending   []byte
+}
 
-	// --- Message 2: Responder -> Initiator ---
-	msg2, _, _, err := hs.WriteMessage(nil, payload)
+// NewNoiseClient performs the handshake as initiator and returns the secured connection.
+// The underlying connection is closed if the handshake fails.
+func NewNoiseClient(conn net.Conn, cfg NoiseConfig) (*NoiseConn, error) {
+	return newNoiseConn(conn, cfg, true)
+}
+
+// NewNoiseServer performs the handshake as responder and returns the secured connection.
+// The underlying connection is closed if the handshake fails.
+func NewNoiseServer(conn net.Conn, cfg NoiseConfig) (*NoiseConn, error) {
+	return newNoiseConn(conn, cfg, false)
+}
+
+func newNoiseConn(conn net.Conn, cfg NoiseConfig, initiator bool) (*NoiseConn, error) {
+	res, err := runHandshake(conn, cfg, initiator)
 	if err != nil {
-		return nil, fmt.Errorf("failed to write handshake message 2: %v", err)
+		conn.Close()
+		return nil, err
 	}
-	if _, err := conn.Write(msg2); err != nil {
-		return nil, fmt.Errorf("failed to send handshake message 2: %v", err)
+	interval := cfg.RekeyInterval
+	if interval == 0 {
+		interval = DefaultRekeyInterval
 	}
+	return &NoiseConn{
+		Conn:          conn,
+		rekeyInterval: interval,
+		remoteStatic:  res.remoteStatic,
+		remotePayload: res.remotePayload,
+		send:          res.send,
+		recv:          res.recv,
+	}, nil
+}
 
-	// --- Message 3: Initiator -> Responder ---
-	n, err = conn.Read(buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read handshake message 3: %v", err)
-	}
-	_, csRecv, csSend, err := hs.ReadMessage(nil, buf[:n])
-	if err != nil {
-		return nil, fmt.Errorf("failed to process handshake message 3: %v", err)
+// RemoteStatic returns the remote party's static public key, or nil if the
+// handshake pattern did not transmit one.
+func (c *NoiseConn) RemoteStatic() []byte {
+	return c.remoteStatic
+}
+
+// HandshakePayload returns the payload the remote party sent during the handshake.
+func (c *NoiseConn) HandshakePayload() []byte {
+	return c.remotePayload
+}
+
+// Write encrypts b and writes it as one or more Noise messages.
+func (c *NoiseConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		if len(chunk) > maxNoisePlaintext {
+			chunk = chunk[:maxNoisePlaintext]
+		}
+		msg, err := c.send.Encrypt(nil, nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		if err := writeNoiseFrame(c.Conn, msg); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		if c.sendCount++; c.sendCount%c.rekeyInterval == 0 {
+			c.send.Rekey()
+		}
 	}
+	return written, nil
+}
 
-	// Return both cipher states for encryption (send) and decryption (receive)
-	return &SecureChannel{
-		SendCipher: csSend,
-		RecvCipher: csRecv,
-	}, nil
-}
\ No newline at end of file
+// Read decrypts the next Noise message into b. Bytes that do not fit are
+// returned by subsequent calls.
+func (c *NoiseConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.pending) == 0 {
+		msg, err := readNoiseFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		plain, err := c.recv.Decrypt(nil, nil, msg)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt noise message: %v", err)
+		}
+		if c.recvCount++; c.recvCount%c.rekeyInterval == 0 {
+			c.recv.Rekey()
+		}
+		c.pending = plain
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,255 @@
+package p2p
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+)
+
+// noisePair runs a handshake over an in-memory pipe and returns both ends.
+func noisePair(t *testing.T, clientCfg, serverCfg NoiseConfig) (*NoiseConn, *NoiseConn) {
+	t.Helper()
+	a, b := net.Pipe()
+	type result struct {
+		conn *NoiseConn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := NewNoiseServer(b, serverCfg)
+		done <- result{conn, err}
+	}()
+	client, err := NewNoiseClient(a, clientCfg)
+	if err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("server handshake failed: %v", res.err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		res.conn.Close()
+	})
+	return client, res.conn
+}
+
+func mustKeypair(t *testing.T) noise.DHKey {
+	t.Helper()
+	key, err := GenerateNoiseKeypair()
+	if err != nil {
+		t.Fatalf("failed to generate keypair: %v", err)
+	}
+	return key
+}
+
+// writeAsync writes data on its own goroutine, since net.Pipe is unbuffered.
+func writeAsync(conn io.Writer, data []byte) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		errc <- err
+	}()
+	return errc
+}
+
+func TestNoiseHandshakePatterns(t *testing.T) {
+	clientKey, serverKey := mustKeypair(t), mustKeypair(t)
+	tests := []struct {
+		name         string
+		client       NoiseConfig
+		server       NoiseConfig
+		serverLearns []byte // Client static key the server should see
+	}{
+		{
+			name:         "XX",
+			client:       NoiseConfig{Pattern: noise.HandshakeXX, StaticKey: clientKey},
+			server:       NoiseConfig{Pattern: noise.HandshakeXX, StaticKey: serverKey},
+			serverLearns: clientKey.Public,
+		},
+		{
+			name:         "IK",
+			client:       NoiseConfig{Pattern: noise.HandshakeIK, StaticKey: clientKey, RemoteStatic: serverKey.Public},
+			server:       NoiseConfig{Pattern: noise.HandshakeIK, StaticKey: serverKey},
+			serverLearns: clientKey.Public,
+		},
+		{
+			name:   "NK",
+			client: NoiseConfig{Pattern: noise.HandshakeNK, RemoteStatic: serverKey.Public},
+			server: NoiseConfig{Pattern: noise.HandshakeNK, StaticKey: serverKey},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.client.Payload = []byte("client hello")
+			tt.server.Payload = []byte("server hello")
+			client, server := noisePair(t, tt.client, tt.server)
+
+			if !bytes.Equal(client.RemoteStatic(), serverKey.Public) {
+				t.Errorf("client saw remote static %x, want %x", client.RemoteStatic(), serverKey.Public)
+			}
+			if !bytes.Equal(server.RemoteStatic(), tt.serverLearns) {
+				t.Errorf("server saw remote static %x, want %x", server.RemoteStatic(), tt.serverLearns)
+			}
+			if got := string(server.HandshakePayload()); got != "client hello" {
+				t.Errorf("server got payload %q", got)
+			}
+			if got := string(client.HandshakePayload()); got != "server hello" {
+				t.Errorf("client got payload %q", got)
+			}
+
+			for _, dir := range []struct {
+				from, to *NoiseConn
+				msg      string
+			}{{client, server, "ping"}, {server, client, "pong"}} {
+				errc := writeAsync(dir.from, []byte(dir.msg))
+				buf := make([]byte, len(dir.msg))
+				if _, err := io.ReadFull(dir.to, buf); err != nil {
+					t.Fatalf("read failed: %v", err)
+				}
+				if err := <-errc; err != nil {
+					t.Fatalf("write failed: %v", err)
+				}
+				if string(buf) != dir.msg {
+					t.Errorf("got %q, want %q", buf, dir.msg)
+				}
+			}
+		})
+	}
+}
+
+func TestNoiseConnShortReads(t *testing.T) {
+	client, server := noisePair(t, NoiseConfig{StaticKey: mustKeypair(t)}, NoiseConfig{StaticKey: mustKeypair(t)})
+
+	msg := []byte("a message longer than the read buffer")
+	errc := writeAsync(client, msg)
+	var got []byte
+	buf := make([]byte, 5)
+	for len(got) < len(msg) {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if n > len(buf) {
+			t.Fatalf("read returned %d bytes into a %d byte buffer", n, len(buf))
+		}
+		got = append(got, buf[:n]...)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("got %q, want %q", got, msg)
+	}
+}
+
+func TestNoiseConnLargeWrite(t *testing.T) {
+	client, server := noisePair(t, NoiseConfig{StaticKey: mustKeypair(t)}, NoiseConfig{StaticKey: mustKeypair(t)})
+
+	msg := make([]byte, 2*maxNoisePlaintext+1000)
+	for i := range msg {
+		msg[i] = byte(i % 251)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		n, err := client.Write(msg)
+		if err == nil && n != len(msg) {
+			err = io.ErrShortWrite
+		}
+		errc <- err
+	}()
+
+	// Each Read returns at most one message, so the first one shows the split.
+	first := make([]byte, len(msg))
+	n, err := server.Read(first)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if n != maxNoisePlaintext {
+		t.Errorf("first message carried %d bytes, want %d", n, maxNoisePlaintext)
+	}
+	rest := make([]byte, len(msg)-n)
+	if _, err := io.ReadFull(server, rest); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if !bytes.Equal(append(first[:n], rest...), msg) {
+		t.Error("large message was corrupted")
+	}
+}
+
+func TestNoiseConnRekey(t *testing.T) {
+	client, server := noisePair(t,
+		NoiseConfig{StaticKey: mustKeypair(t), RekeyInterval: 2},
+		NoiseConfig{StaticKey: mustKeypair(t), RekeyInterval: 2})
+
+	for i := 0; i < 10; i++ {
+		msg := []byte{byte(i)}
+		errc := writeAsync(client, msg)
+		buf := make([]byte, 1)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			t.Fatalf("message %d: read failed: %v", i, err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("message %d: write failed: %v", i, err)
+		}
+		if buf[0] != byte(i) {
+			t.Fatalf("message %d: got %d", i, buf[0])
+		}
+	}
+}
+
+func TestNoiseConnRekeyMismatch(t *testing.T) {
+	client, server := noisePair(t,
+		NoiseConfig{StaticKey: mustKeypair(t), RekeyInterval: 1},
+		NoiseConfig{StaticKey: mustKeypair(t), RekeyInterval: 3})
+
+	// The first message uses the initial key; the second is sent after the
+	// client rekeyed but before the server did, so it must not decrypt.
+	for i := 0; i < 2; i++ {
+		writeAsync(client, []byte{byte(i)})
+		buf := make([]byte, 1)
+		_, err := server.Read(buf)
+		if i == 0 && err != nil {
+			t.Fatalf("first message failed: %v", err)
+		}
+		if i == 1 && err == nil {
+			t.Fatal("message after a one-sided rekey decrypted")
+		}
+	}
+}
+
+func TestNoiseVerifyPeerRejects(t *testing.T) {
+	errRejected := errors.New("unknown peer")
+	a, b := net.Pipe()
+	defer a.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewNoiseServer(b, NoiseConfig{
+			StaticKey:  mustKeypair(t),
+			VerifyPeer: func([]byte, []byte) error { return errRejected },
+		})
+		done <- err
+	}()
+	// In XX the initiator finishes first, so only the server sees the rejection.
+	client, err := NewNoiseClient(a, NoiseConfig{StaticKey: mustKeypair(t)})
+	if err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; !errors.Is(err, errRejected) {
+		t.Fatalf("server returned %v, want %v", err, errRejected)
+	}
+	if _, err := b.Write([]byte{0}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("server conn still open after rejection: %v", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("client read succeeded on a rejected connection")
+	}
+}
@@ -0,0 +1,250 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/geo_routing"
+	"github.com/ArguableExorcist8/desvault-storage-node/network"
+	"github.com/ArguableExorcist8/desvault-storage-node/reputation"
+)
+
+// ErrInsufficientPeers is returned when fewer eligible peers exist than the
+// requested number of replicas.
+var ErrInsufficientPeers = errors.New("not enough eligible peers for placement")
+
+// PeerCandidate describes a peer that may receive shard replicas.
+type PeerCandidate struct {
+	PeerID     string
+	Addr       string  // IP address, used to resolve Region when it was not announced
+	Region     string  // Region reported by the peer or its country from geo_routing
+	FreeBytes  int64   // Announced free capacity
+	Reputation float64 // Reputation score in the range [0, 1]
+	Load       int     // Replicas currently assigned to the peer
+}
+
+// PlacementContext carries the information a policy needs to score a candidate.
+type PlacementContext struct {
+	ShardSize int64
+	MaxFree   int64           // Largest FreeBytes among the candidates
+	MaxLoad   int             // Largest Load among the candidates
+	Chosen    []PeerCandidate // Peers already holding a replica of this shard
+}
+
+// PlacementPolicy scores how suitable a candidate is for one replica of a shard.
+// Candidates with a score of zero or less are never selected.
+type PlacementPolicy interface {
+	Score(c PeerCandidate, ctx PlacementContext) float64
+}
+
+// WeightedPolicy is the default placement policy. It combines free capacity,
+// reputation and current load, and penalises regions that already hold a replica.
+type WeightedPolicy struct {
+	CapacityWeight   float64
+	ReputationWeight float64
+	LoadWeight       float64
+	SameRegionFactor float64 // Multiplier applied when the candidate's region is already used
+}
+
+// DefaultPlacementPolicy returns the weights used when no policy is configured.
+func DefaultPlacementPolicy() WeightedPolicy {
+	return WeightedPolicy{
+		CapacityWeight:   0.4,
+		ReputationWeight: 0.4,
+		LoadWeight:       0.2,
+		SameRegionFactor: 0.1,
+	}
+}
+
+// Score implements PlacementPolicy.
+func (p WeightedPolicy) Score(c PeerCandidate, ctx PlacementContext) float64 {
+	if c.FreeBytes < ctx.ShardSize {
+		return 0
+	}
+	capacity := 1.0
+	if ctx.MaxFree > 0 {
+		capacity = float64(c.FreeBytes) / float64(ctx.MaxFree)
+	}
+	load := 1.0 - float64(c.Load)/float64(ctx.MaxLoad+1)
+	score := p.CapacityWeight*capacity + p.ReputationWeight*c.Reputation + p.LoadWeight*load
+	if c.Region != "" {
+		for _, chosen := range ctx.Chosen {
+			if chosen.Region == c.Region {
+				score *= p.SameRegionFactor
+				break
+			}
+		}
+	}
+	return score
+}
+
+// PlacementEngine selects distinct peers for shard replicas using a PlacementPolicy.
+// Selection is weighted-random; engines created with the same seed and inputs
+// always make the same choices.
+type PlacementEngine struct {
+	policy PlacementPolicy
+	rng    *rand.Rand
+	mu     sync.Mutex
+}
+
+// NewPlacementEngine creates a placement engine. If policy is nil the
+// DefaultPlacementPolicy is used.
+func NewPlacementEngine(policy PlacementPolicy, seed int64) *PlacementEngine {
+	if policy == nil {
+		policy = DefaultPlacementPolicy()
+	}
+	return &PlacementEngine{
+		policy: policy,
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Place picks up to replicas distinct peers for a shard of the given size.
+// If fewer eligible peers exist, the peers that could be chosen are returned
+// together with an error wrapping ErrInsufficientPeers.
+func (e *PlacementEngine) Place(shardSize int64, replicas int, candidates []PeerCandidate) ([]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Sort a copy so the result does not depend on the caller's ordering.
+	pool := make([]PeerCandidate, len(candidates))
+	copy(pool, candidates)
+	sort.Slice(pool, func(i, j int) bool { return pool[i].PeerID < pool[j].PeerID })
+
+	ctx := PlacementContext{ShardSize: shardSize}
+	for _, c := range pool {
+		if c.FreeBytes > ctx.MaxFree {
+			ctx.MaxFree = c.FreeBytes
+		}
+		if c.Load > ctx.MaxLoad {
+			ctx.MaxLoad = c.Load
+		}
+	}
+
+	var chosen []string
+	for len(chosen) < replicas && len(pool) > 0 {
+		scores := make([]float64, len(pool))
+		var total float64
+		for i, c := range pool {
+			if s := e.policy.Score(c, ctx); s > 0 {
+				scores[i] = s
+				total += s
+			}
+		}
+		if total == 0 {
+			break
+		}
+
+		pick := e.rng.Float64() * total
+		idx := -1
+		for i, s := range scores {
+			if s <= 0 {
+				continue
+			}
+			idx = i
+			if pick < s {
+				break
+			}
+			pick -= s
+		}
+
+		selected := pool[idx]
+		chosen = append(chosen, selected.PeerID)
+		ctx.Chosen = append(ctx.Chosen, selected)
+		pool = append(pool[:idx], pool[idx+1:]...)
+	}
+
+	if len(chosen) < replicas {
+		return chosen, fmt.Errorf("placed %d of %d replicas: %w", len(chosen), replicas, ErrInsufficientPeers)
+	}
+	return chosen, nil
+}
+
+// PlaceShards assigns replicas of every shard to distinct peers, updating the
+// load and free capacity of candidates as replicas are assigned so that later
+// shards spread across the network. It returns a map of peer ID to shards.
+func (e *PlacementEngine) PlaceShards(shards []Shard, replicas int, candidates []PeerCandidate) (map[string][]Shard, error) {
+	pool := make([]PeerCandidate, len(candidates))
+	copy(pool, candidates)
+	index := make(map[string]int, len(pool))
+	for i, c := range pool {
+		index[c.PeerID] = i
+	}
+
+	shardMap := make(map[string][]Shard)
+	var placeErr error
+	for _, shard := range shards {
+		size := int64(len(shard.Data))
+		peers, err := e.Place(size, replicas, pool)
+		if err != nil && placeErr == nil {
+			placeErr = fmt.Errorf("shard %s: %w", shard.ID, err)
+		}
+		for _, p := range peers {
+			shardMap[p] = append(shardMap[p], shard)
+			c := &pool[index[p]]
+			c.Load++
+			c.FreeBytes -= size
+		}
+	}
+	return shardMap, placeErr
+}
+
+// ResolveRegions fills in missing candidate regions with the country of their
+// address from the GeoLite2 database. Candidates whose location cannot be
+// determined keep an empty region, so they are never treated as co-located.
+func ResolveRegions(candidates []PeerCandidate) {
+	for i := range candidates {
+		if candidates[i].Region != "" || candidates[i].Addr == "" {
+			continue
+		}
+		location, err := geo_routing.GetGeoLocation(candidates[i].Addr)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Without the database no region can be resolved.
+			log.Printf("[!] Peer regions unknown: %v", err)
+			return
+		}
+		if err != nil {
+			log.Printf("[!] Region of peer %s unknown: %v", candidates[i].PeerID, err)
+			continue
+		}
+		// Locations are "city, country"; regions are compared by country.
+		country := location[strings.LastIndex(location, ", ")+2:]
+		if country != "" {
+			candidates[i].Region = country
+		}
+	}
+}
+
+// CandidatesFromCapacity builds placement candidates from the announced peer
+// capacity table, scored by rep. With a nil rep every peer has NeutralScore.
+func CandidatesFromCapacity(entries []network.PeerCapacity, rep *reputation.Service) []PeerCandidate {
+	candidates := make([]PeerCandidate, 0, len(entries))
+	for _, e := range entries {
+		c := PeerCandidate{
+			PeerID:     e.PeerID,
+			Region:     e.Region,
+			FreeBytes:  e.FreeBytes(),
+			Reputation: rep.Score(e.PeerID),
+		}
+		for _, addr := range e.ListenAddrs {
+			m, err := ma.NewMultiaddr(addr)
+			if err != nil {
+				continue
+			}
+			if ip, err := m.ValueForProtocol(ma.P_IP4); err == nil {
+				c.Addr = ip
+				break
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
@@ -0,0 +1,148 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testCandidates returns n peers with equal capacity and reputation, spread
+// round-robin over the given regions.
+func testCandidates(n int, regions ...string) []PeerCandidate {
+	candidates := make([]PeerCandidate, n)
+	for i := range candidates {
+		candidates[i] = PeerCandidate{
+			PeerID:     fmt.Sprintf("peer-%02d", i),
+			FreeBytes:  1 << 30,
+			Reputation: 0.5,
+		}
+		if len(regions) > 0 {
+			candidates[i].Region = regions[i%len(regions)]
+		}
+	}
+	return candidates
+}
+
+func TestPlacementSameSeedSamePicks(t *testing.T) {
+	candidates := testCandidates(10)
+	reversed := make([]PeerCandidate, len(candidates))
+	for i, c := range candidates {
+		reversed[len(candidates)-1-i] = c
+	}
+
+	a, b := NewPlacementEngine(nil, 42), NewPlacementEngine(nil, 42)
+	for i := 0; i < 5; i++ {
+		got, err := a.Place(1024, 3, candidates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The caller's ordering must not matter either.
+		want, err := b.Place(1024, 3, reversed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("round %d: same seed placed %v and %v", i, got, want)
+		}
+	}
+}
+
+func TestPlacementDistinctPeers(t *testing.T) {
+	candidates := testCandidates(8)
+	for seed := int64(0); seed < 100; seed++ {
+		peers, err := NewPlacementEngine(nil, seed).Place(1024, 8, candidates)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		seen := make(map[string]bool)
+		for _, p := range peers {
+			if seen[p] {
+				t.Fatalf("seed %d: peer %s chosen twice in %v", seed, p, peers)
+			}
+			seen[p] = true
+		}
+	}
+}
+
+func TestPlacementExcludesFullPeers(t *testing.T) {
+	candidates := testCandidates(6)
+	full := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		candidates[i].FreeBytes = 512
+		full[candidates[i].PeerID] = true
+	}
+	for seed := int64(0); seed < 100; seed++ {
+		peers, err := NewPlacementEngine(nil, seed).Place(1024, 3, candidates)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		for _, p := range peers {
+			if full[p] {
+				t.Fatalf("seed %d: placed a 1024 byte shard on %s with 512 bytes free", seed, p)
+			}
+		}
+	}
+}
+
+func TestPlacementSpreadsRegions(t *testing.T) {
+	candidates := testCandidates(9, "eu", "us", "asia")
+	region := make(map[string]string)
+	for _, c := range candidates {
+		region[c.PeerID] = c.Region
+	}
+	distinctRegions := func(peers []string) bool {
+		used := make(map[string]bool)
+		for _, p := range peers {
+			if used[region[p]] {
+				return false
+			}
+			used[region[p]] = true
+		}
+		return true
+	}
+
+	// Without any weight on reused regions, every replica lands in a new region.
+	strict := DefaultPlacementPolicy()
+	strict.SameRegionFactor = 0
+	for seed := int64(0); seed < 100; seed++ {
+		peers, err := NewPlacementEngine(strict, seed).Place(1024, 3, candidates)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if !distinctRegions(peers) {
+			t.Fatalf("seed %d: replicas %v share a region", seed, peers)
+		}
+	}
+
+	// The default policy only penalises reused regions, so most placements spread.
+	spread := 0
+	for seed := int64(0); seed < 200; seed++ {
+		peers, err := NewPlacementEngine(nil, seed).Place(1024, 3, candidates)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if distinctRegions(peers) {
+			spread++
+		}
+	}
+	if spread < 150 {
+		t.Errorf("only %d of 200 default placements used three regions", spread)
+	}
+}
+
+func TestPlacementInsufficientPeers(t *testing.T) {
+	candidates := testCandidates(3)
+	candidates[0].FreeBytes = 0
+
+	peers, err := NewPlacementEngine(nil, 1).Place(1024, 3, candidates)
+	if !errors.Is(err, ErrInsufficientPeers) {
+		t.Fatalf("got error %v, want %v", err, ErrInsufficientPeers)
+	}
+	if len(peers) != 2 {
+		t.Errorf("got %v, want the 2 eligible peers", peers)
+	}
+
+	if _, err := NewPlacementEngine(nil, 1).Place(1024, 1, nil); !errors.Is(err, ErrInsufficientPeers) {
+		t.Errorf("placement without candidates returned %v", err)
+	}
+}
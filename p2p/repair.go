@@ -0,0 +1,685 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	gonetwork "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/network"
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+)
+
+// ShardReconstructor rebuilds the encrypted bytes of a shard when no live holder
+// can serve a copy, for example from parity shards or an external store.
+type ShardReconstructor interface {
+	Reconstruct(ctx context.Context, shardID string) ([]byte, error)
+}
+
+// ProviderFinder locates peers that hold a shard, typically through DHT provider records.
+type ProviderFinder interface {
+	FindShardProviders(ctx context.Context, shardID string, max int) ([]peer.AddrInfo, error)
+}
+
+// ShardTransport fetches and pushes shards, for example over a peer's QUIC
+// shard service with the libp2p shard protocol as fallback.
+type ShardTransport interface {
+	FetchShardFrom(ctx context.Context, p peer.ID, shardID string) ([]byte, error)
+	PushShardTo(ctx context.Context, p peer.ID, shardID string, data []byte) error
+}
+
+// RepairConfig controls the background repair loop.
+type RepairConfig struct {
+	TargetReplicas    int           // Desired number of live holders per shard
+	Interval          time.Duration // How often shards are checked
+	HeartbeatInterval time.Duration // How often holder liveness is checked against the health table
+	LivenessTimeout   time.Duration // How long a holder stays live after it was last seen
+	HolderExpiry      time.Duration // How long a holder may stay unreachable before it is dropped
+	MaxBackoff        time.Duration // Upper bound on the delay between repair attempts of a shard
+	StateFile         string        // Where repair state is persisted
+}
+
+// DefaultRepairConfig returns the repair settings used by the node.
+func DefaultRepairConfig() RepairConfig {
+	stateFile := "repair_state.json"
+	if dir, err := setup.GetDesVaultDir(); err == nil {
+		stateFile = filepath.Join(dir, "repair_state.json")
+	}
+	return RepairConfig{
+		TargetReplicas:    3,
+		Interval:          time.Minute,
+		HeartbeatInterval: 30 * time.Second,
+		LivenessTimeout:   2 * time.Minute,
+		HolderExpiry:      24 * time.Hour,
+		MaxBackoff:        time.Hour,
+		StateFile:         stateFile,
+	}
+}
+
+// repairRecord tracks repair attempts for a shard.
+type repairRecord struct {
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// repairState is the persisted part of the repairer.
+type repairState struct {
+	Holders map[string][]string      `json:"holders"` // Shard ID -> peer IDs holding a replica
+	CIDs    map[string]string        `json:"cids"`    // Shard ID -> IPFS CID, used as a last-resort source
+	Pending map[string]*repairRecord `json:"pending"` // Shards that are below target replication
+	// Unreachable records when each holder was first found down since it was last seen.
+	Unreachable map[string]time.Time `json:"unreachable,omitempty"`
+}
+
+// Repairer watches shard holders and restores replication when holders go offline.
+type Repairer struct {
+	host   host.Host
+	engine *PlacementEngine
+	cfg    RepairConfig
+
+	// Candidates supplies peers eligible to receive new replicas. When nil,
+	// every connected peer is considered with unknown capacity.
+	Candidates func() []PeerCandidate
+	// Providers is consulted for additional holders before a shard is repaired.
+	Providers ProviderFinder
+	// Transport moves shards between peers. When nil, the libp2p shard
+	// protocol is used.
+	Transport ShardTransport
+	// Reconstructor is used when no holder can serve a shard.
+	Reconstructor ShardReconstructor
+	// Health supplies the heartbeat results of the discovery service. When
+	// nil, only open connections count as liveness.
+	Health *network.HealthTable
+
+	mu       sync.Mutex
+	state    repairState
+	lastSeen map[peer.ID]time.Time
+
+	persistMu sync.Mutex // Orders writes of the state file
+}
+
+// NewRepairer creates a repairer and loads any persisted repair state.
+func NewRepairer(h host.Host, engine *PlacementEngine, cfg RepairConfig) (*Repairer, error) {
+	if engine == nil {
+		engine = NewPlacementEngine(nil, time.Now().UnixNano())
+	}
+	r := &Repairer{
+		host:   h,
+		engine: engine,
+		cfg:    cfg,
+		state: repairState{
+			Holders:     make(map[string][]string),
+			CIDs:        make(map[string]string),
+			Pending:     make(map[string]*repairRecord),
+			Unreachable: make(map[string]time.Time),
+		},
+		lastSeen: make(map[peer.ID]time.Time),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	for _, holders := range r.state.Holders {
+		for _, h := range holders {
+			r.protect(h)
+		}
+	}
+	return r, nil
+}
+
+// Track records the holders of a shard so that its replication is maintained.
+func (r *Repairer) Track(shardID, cid string, holders []string) {
+	r.mu.Lock()
+	for _, h := range holders {
+		r.addHolderLocked(shardID, h)
+	}
+	if cid != "" {
+		r.state.CIDs[shardID] = cid
+	}
+	r.mu.Unlock()
+	r.persist()
+}
+
+// Untrack stops maintaining the replication of a shard, for example after the
+// file it belongs to was deleted. Holders that hold no other tracked shard are
+// no longer protected or pinged.
+func (r *Repairer) Untrack(shardID string) {
+	r.mu.Lock()
+	holders := r.state.Holders[shardID]
+	delete(r.state.Holders, shardID)
+	delete(r.state.CIDs, shardID)
+	delete(r.state.Pending, shardID)
+	stillHeld := make(map[string]bool)
+	for _, hs := range r.state.Holders {
+		for _, h := range hs {
+			stillHeld[h] = true
+		}
+	}
+	for _, h := range holders {
+		if !stillHeld[h] {
+			delete(r.state.Unreachable, h)
+		}
+	}
+	r.mu.Unlock()
+	for _, h := range holders {
+		if stillHeld[h] {
+			continue
+		}
+		if pid, err := peer.Decode(h); err == nil {
+			r.host.ConnManager().Unprotect(pid, network.ShardHolderTag)
+		}
+	}
+	r.persist()
+}
+
+// Start begins tracking liveness and runs the repair loop until ctx is cancelled.
+func (r *Repairer) Start(ctx context.Context) {
+	r.host.Network().Notify(&gonetwork.NotifyBundle{
+		ConnectedF: func(_ gonetwork.Network, c gonetwork.Conn) {
+			r.markSeen(c.RemotePeer())
+		},
+		// A holder that just disconnected gets LivenessTimeout to come back
+		// before its shards are considered under-replicated.
+		DisconnectedF: func(_ gonetwork.Network, c gonetwork.Conn) {
+			r.markSeen(c.RemotePeer())
+		},
+	})
+	go r.livenessLoop(ctx)
+	go r.repairLoop(ctx)
+	log.Printf("[+] Shard repair loop started (target %d replicas)", r.cfg.TargetReplicas)
+}
+
+// -----------------------------------------------------------------------------
+// Liveness
+// -----------------------------------------------------------------------------
+
+func (r *Repairer) markSeen(p peer.ID) {
+	r.mu.Lock()
+	r.lastSeen[p] = time.Now()
+	_, wasUnreachable := r.state.Unreachable[p.String()]
+	delete(r.state.Unreachable, p.String())
+	r.mu.Unlock()
+	if wasUnreachable {
+		r.persist()
+	}
+}
+
+// markUnreachable records when a holder was first found down.
+func (r *Repairer) markUnreachable(p peer.ID) {
+	r.mu.Lock()
+	_, known := r.state.Unreachable[p.String()]
+	if !known {
+		r.state.Unreachable[p.String()] = time.Now()
+	}
+	r.mu.Unlock()
+	if !known {
+		r.persist()
+	}
+}
+
+// isLive reports whether a holder is currently reachable.
+func (r *Repairer) isLive(p peer.ID) bool {
+	if p == r.host.ID() {
+		return true
+	}
+	if r.host.Network().Connectedness(p) == gonetwork.Connected {
+		return true
+	}
+	r.mu.Lock()
+	seen, ok := r.lastSeen[p]
+	r.mu.Unlock()
+	return ok && time.Since(seen) < r.cfg.LivenessTimeout
+}
+
+// livenessLoop checks known holders against the health table, which the
+// discovery service keeps current with its own heartbeats.
+func (r *Repairer) livenessLoop(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, p := range r.knownHolders() {
+				if p == r.host.ID() {
+					continue
+				}
+				if r.answersHeartbeats(p) {
+					r.markSeen(p)
+				} else if !r.isLive(p) {
+					r.markUnreachable(p)
+				}
+			}
+			r.pruneHolders()
+		}
+	}
+}
+
+// answersHeartbeats reports whether the health table lists p as connected.
+func (r *Repairer) answersHeartbeats(p peer.ID) bool {
+	if r.Health == nil {
+		return false
+	}
+	h, ok := r.Health.Get(p)
+	return ok && h.State == network.PeerConnected
+}
+
+// pruneHolders drops holders that have been unreachable for longer than
+// HolderExpiry, so they are no longer pinged or protected and their shards
+// are re-replicated elsewhere.
+func (r *Repairer) pruneHolders() {
+	if r.cfg.HolderExpiry <= 0 {
+		return
+	}
+	r.mu.Lock()
+	var expired []string
+	for h, since := range r.state.Unreachable {
+		if time.Since(since) >= r.cfg.HolderExpiry {
+			expired = append(expired, h)
+		}
+	}
+	for _, h := range expired {
+		for shardID := range r.state.Holders {
+			r.removeHolderLocked(shardID, h)
+		}
+		delete(r.state.Unreachable, h)
+	}
+	r.mu.Unlock()
+	if len(expired) == 0 {
+		return
+	}
+	for _, h := range expired {
+		if pid, err := peer.Decode(h); err == nil {
+			r.host.ConnManager().Unprotect(pid, network.ShardHolderTag)
+		}
+		log.Printf("[!] Dropped shard holder %s, unreachable for over %s", h, r.cfg.HolderExpiry)
+	}
+	r.persist()
+}
+
+// knownHolders returns every distinct peer that holds at least one tracked shard.
+func (r *Repairer) knownHolders() []peer.ID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[peer.ID]bool)
+	var peers []peer.ID
+	for _, holders := range r.state.Holders {
+		for _, h := range holders {
+			p, err := peer.Decode(h)
+			if err != nil || seen[p] {
+				continue
+			}
+			seen[p] = true
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// -----------------------------------------------------------------------------
+// Repair
+// -----------------------------------------------------------------------------
+
+func (r *Repairer) repairLoop(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce checks every tracked shard and repairs those below target replication.
+func (r *Repairer) RunOnce(ctx context.Context) {
+	for shardID, live := range r.underReplicated() {
+		if ctx.Err() != nil {
+			return
+		}
+		if !r.repairDue(shardID) {
+			continue
+		}
+		if found := r.discoverHolders(ctx, shardID); found > 0 {
+			live = r.liveHolders(shardID)
+			if len(live) >= r.cfg.TargetReplicas {
+				r.mu.Lock()
+				delete(r.state.Pending, shardID)
+				r.mu.Unlock()
+				r.persist()
+				continue
+			}
+		}
+		log.Printf("[!] Shard %s has %d of %d live replicas. Repairing.", shardID, len(live), r.cfg.TargetReplicas)
+		err := r.repairShard(ctx, shardID, live)
+
+		r.mu.Lock()
+		rec, ok := r.state.Pending[shardID]
+		if !ok {
+			rec = &repairRecord{}
+			r.state.Pending[shardID] = rec
+		}
+		rec.Attempts++
+		rec.LastAttempt = time.Now()
+		if err != nil {
+			rec.LastError = err.Error()
+			log.Printf("[!] Repair of shard %s failed: %v", shardID, err)
+		} else {
+			delete(r.state.Pending, shardID)
+			log.Printf("[+] Shard %s restored to %d replicas.", shardID, r.cfg.TargetReplicas)
+		}
+		r.mu.Unlock()
+		r.persist()
+	}
+}
+
+// repairDue reports whether a shard's backoff since its last failed repair
+// attempt has elapsed. The delay doubles with every attempt up to MaxBackoff.
+func (r *Repairer) repairDue(shardID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.state.Pending[shardID]
+	if !ok || rec.Attempts == 0 {
+		return true
+	}
+	backoff := r.cfg.Interval << min(rec.Attempts-1, 20)
+	if r.cfg.MaxBackoff > 0 && (backoff > r.cfg.MaxBackoff || backoff <= 0) {
+		backoff = r.cfg.MaxBackoff
+	}
+	return time.Since(rec.LastAttempt) >= backoff
+}
+
+// underReplicated returns the shards with fewer live holders than the target,
+// together with the holders that are still live.
+func (r *Repairer) underReplicated() map[string][]peer.ID {
+	r.mu.Lock()
+	holders := make(map[string][]string, len(r.state.Holders))
+	for id, hs := range r.state.Holders {
+		holders[id] = append([]string(nil), hs...)
+	}
+	r.mu.Unlock()
+
+	result := make(map[string][]peer.ID)
+	for shardID, hs := range holders {
+		if live := r.filterLive(hs); len(live) < r.cfg.TargetReplicas {
+			result[shardID] = live
+		}
+	}
+	return result
+}
+
+// liveHolders returns the holders of a shard that are currently live.
+func (r *Repairer) liveHolders(shardID string) []peer.ID {
+	r.mu.Lock()
+	hs := append([]string(nil), r.state.Holders[shardID]...)
+	r.mu.Unlock()
+	return r.filterLive(hs)
+}
+
+func (r *Repairer) filterLive(holders []string) []peer.ID {
+	var live []peer.ID
+	for _, h := range holders {
+		if p, err := peer.Decode(h); err == nil && r.isLive(p) {
+			live = append(live, p)
+		}
+	}
+	return live
+}
+
+// discoverHolders adds reachable DHT providers of a shard to its holders and
+// returns how many were added.
+func (r *Repairer) discoverHolders(ctx context.Context, shardID string) int {
+	if r.Providers == nil {
+		return 0
+	}
+	fctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	providers, err := r.Providers.FindShardProviders(fctx, shardID, r.cfg.TargetReplicas*2)
+	if err != nil {
+		log.Printf("[!] Provider lookup for shard %s failed: %v", shardID, err)
+		return 0
+	}
+	added := 0
+	for _, info := range providers {
+		if err := r.host.Connect(fctx, info); err != nil {
+			continue
+		}
+		r.markSeen(info.ID)
+		r.mu.Lock()
+		before := len(r.state.Holders[shardID])
+		r.addHolderLocked(shardID, info.ID.String())
+		if len(r.state.Holders[shardID]) > before {
+			added++
+		}
+		r.mu.Unlock()
+	}
+	if added > 0 {
+		r.persist()
+	}
+	return added
+}
+
+// repairShard obtains a copy of the shard and pushes it to newly chosen peers.
+func (r *Repairer) repairShard(ctx context.Context, shardID string, live []peer.ID) error {
+	data, err := r.obtainShard(ctx, shardID, live)
+	if err != nil {
+		return err
+	}
+
+	exclude := make(map[string]bool)
+	r.mu.Lock()
+	for _, h := range r.state.Holders[shardID] {
+		exclude[h] = true
+	}
+	r.mu.Unlock()
+	exclude[r.host.ID().String()] = true
+
+	var candidates []PeerCandidate
+	for _, c := range r.candidates() {
+		if !exclude[c.PeerID] {
+			candidates = append(candidates, c)
+		}
+	}
+	ResolveRegions(candidates)
+
+	needed := r.cfg.TargetReplicas - len(live)
+	targets, placeErr := r.engine.Place(int64(len(data)), needed, candidates)
+	pushed := 0
+	for _, target := range targets {
+		p, err := peer.Decode(target)
+		if err != nil {
+			continue
+		}
+		if err := r.pushShard(ctx, p, shardID, data); err != nil {
+			log.Printf("[!] Failed to push shard %s to %s: %v", shardID, target, err)
+			continue
+		}
+		r.mu.Lock()
+		r.addHolderLocked(shardID, target)
+		r.mu.Unlock()
+		pushed++
+	}
+	if pushed < needed {
+		if placeErr != nil {
+			return placeErr
+		}
+		return fmt.Errorf("pushed %d of %d missing replicas", pushed, needed)
+	}
+	return nil
+}
+
+// pushShard stores a shard on p through the configured transport.
+func (r *Repairer) pushShard(ctx context.Context, p peer.ID, shardID string, data []byte) error {
+	if r.Transport != nil {
+		return r.Transport.PushShardTo(ctx, p, shardID, data)
+	}
+	return network.PushShard(ctx, r.host, p, shardID, data)
+}
+
+// fetchShard retrieves a shard from p through the configured transport.
+func (r *Repairer) fetchShard(ctx context.Context, p peer.ID, shardID string) ([]byte, error) {
+	if r.Transport != nil {
+		return r.Transport.FetchShardFrom(ctx, p, shardID)
+	}
+	return network.FetchShard(ctx, r.host, p, shardID)
+}
+
+// obtainShard returns the encrypted shard from the local store, a live holder,
+// IPFS or the configured reconstructor, in that order.
+func (r *Repairer) obtainShard(ctx context.Context, shardID string, live []peer.ID) ([]byte, error) {
+	if storage.HasLocalShard(shardID) {
+		if data, err := storage.ReadLocalShard(shardID); err == nil {
+			return data, nil
+		}
+	}
+	for _, p := range live {
+		if p == r.host.ID() {
+			continue
+		}
+		data, err := r.fetchShard(ctx, p, shardID)
+		if err == nil {
+			return data, nil
+		}
+		log.Printf("[!] Could not fetch shard %s from %s: %v", shardID, p, err)
+	}
+
+	r.mu.Lock()
+	cid := r.state.CIDs[shardID]
+	r.mu.Unlock()
+	if cid != "" {
+		data, err := storage.FetchEncryptedShardFromIPFS(cid)
+		if err == nil && storage.VerifyShard(shardID, data) == nil {
+			return data, nil
+		}
+	}
+
+	if r.Reconstructor != nil {
+		data, err := r.Reconstructor.Reconstruct(ctx, shardID)
+		if err != nil {
+			return nil, fmt.Errorf("reconstruction failed: %v", err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("no surviving copy of shard %s", shardID)
+}
+
+// candidates returns the peers eligible to receive new replicas.
+func (r *Repairer) candidates() []PeerCandidate {
+	if r.Candidates != nil {
+		return r.Candidates()
+	}
+	var candidates []PeerCandidate
+	for _, p := range r.host.Network().Peers() {
+		candidates = append(candidates, PeerCandidate{
+			PeerID:     p.String(),
+			FreeBytes:  math.MaxInt64,
+			Reputation: 1,
+		})
+	}
+	return candidates
+}
+
+func (r *Repairer) addHolderLocked(shardID, holder string) {
+	for _, h := range r.state.Holders[shardID] {
+		if h == holder {
+			return
+		}
+	}
+	r.state.Holders[shardID] = append(r.state.Holders[shardID], holder)
+	r.protect(holder)
+}
+
+// removeHolderLocked forgets that a peer holds a shard.
+func (r *Repairer) removeHolderLocked(shardID, holder string) {
+	hs := r.state.Holders[shardID]
+	for i, h := range hs {
+		if h == holder {
+			r.state.Holders[shardID] = append(hs[:i:i], hs[i+1:]...)
+			return
+		}
+	}
+}
+
+// protect keeps the connection manager from trimming connections to a shard holder.
+func (r *Repairer) protect(holder string) {
+	if pid, err := peer.Decode(holder); err == nil && pid != r.host.ID() {
+		r.host.ConnManager().Protect(pid, network.ShardHolderTag)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Persistence
+// -----------------------------------------------------------------------------
+
+func (r *Repairer) load() error {
+	data, err := os.ReadFile(r.cfg.StateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read repair state: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.state); err != nil {
+		return fmt.Errorf("failed to unmarshal repair state: %w", err)
+	}
+	if r.state.Holders == nil {
+		r.state.Holders = make(map[string][]string)
+	}
+	if r.state.CIDs == nil {
+		r.state.CIDs = make(map[string]string)
+	}
+	if r.state.Pending == nil {
+		r.state.Pending = make(map[string]*repairRecord)
+	}
+	if r.state.Unreachable == nil {
+		r.state.Unreachable = make(map[string]time.Time)
+	}
+	log.Printf("[+] Loaded repair state for %d shards (%d pending repairs)", len(r.state.Holders), len(r.state.Pending))
+	return nil
+}
+
+// persist writes the repair state to a private temporary file and renames it
+// over the state file, so a crash never leaves a truncated file behind.
+func (r *Repairer) persist() {
+	r.persistMu.Lock()
+	defer r.persistMu.Unlock()
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.state, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		log.Printf("[!] Failed to marshal repair state: %v", err)
+		return
+	}
+	if err := writeFileAtomic(r.cfg.StateFile, data); err != nil {
+		log.Printf("[!] Failed to write repair state: %v", err)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
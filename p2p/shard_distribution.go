@@ -3,7 +3,7 @@ package p2p
 import (
 	"fmt"
 	"log"
-	"math/rand"
+	"math"
 	"time"
 )
 
@@ -16,21 +16,29 @@ type Shard struct {
 
 // Function to distribute shards across nodes
 func DistributeShards(fileID string, fileData []byte, nodes []string) map[string][]Shard {
-	rand.Seed(time.Now().UnixNano())
-	shardMap := make(map[string][]Shard)
-
 	// Split file into 5 shards
 	shards := SplitFileIntoShards(fileData, 5)
 
-	// Assign each shard to 3 random nodes
-	for _, shard := range shards {
-		for i := 0; i < 3; i++ {
-			randomNode := nodes[rand.Intn(len(nodes))]
-			shardMap[randomNode] = append(shardMap[randomNode], shard)
-		}
+	// Capacity and region are unknown for plain node IDs, so every node starts
+	// as an equal candidate and the engine spreads replicas by load.
+	candidates := make([]PeerCandidate, 0, len(nodes))
+	for _, node := range nodes {
+		candidates = append(candidates, PeerCandidate{
+			PeerID:     node,
+			FreeBytes:  math.MaxInt64,
+			Reputation: 1,
+		})
+	}
+
+	// Assign each shard to 3 distinct nodes
+	engine := NewPlacementEngine(nil, time.Now().UnixNano())
+	shardMap, err := engine.PlaceShards(shards, 3, candidates)
+	if err != nil {
+		log.Printf("[!] Placement for file %s is under-replicated: %v", fileID, err)
+		return shardMap
 	}
 
-	log.Println("[+] Shard replication complete! 5x3 redundancy achieved.")
+	log.Printf("[+] Shard replication complete! %dx3 redundancy achieved.", len(shards))
 	return shardMap
 }
 
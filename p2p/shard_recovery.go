@@ -2,6 +2,8 @@ package p2p
 
 import (
 	"log"
+	"math"
+	"time"
 )
 
 // Check if a node storing shards is offline
@@ -16,10 +18,21 @@ func DetectOfflineNodes(activeNodes map[string]bool, shardMap map[string][]Shard
 
 // Redistribute lost shards to active nodes
 func RedistributeShards(shards []Shard, activeNodes map[string]bool) {
-	for _, shard := range shards {
-		for node := range activeNodes {
+	var candidates []PeerCandidate
+	for node, active := range activeNodes {
+		if active {
+			candidates = append(candidates, PeerCandidate{PeerID: node, FreeBytes: math.MaxInt64, Reputation: 1})
+		}
+	}
+
+	engine := NewPlacementEngine(nil, time.Now().UnixNano())
+	shardMap, err := engine.PlaceShards(shards, 1, candidates)
+	if err != nil {
+		log.Printf("[!] Could not redistribute every shard: %v", err)
+	}
+	for node, placed := range shardMap {
+		for _, shard := range placed {
 			log.Printf("[+] Redistributing shard %s to node %s.\n", shard.ID, node)
-			break
 		}
 	}
 }
@@ -1,8 +1,286 @@
 package reputation
 
-// Reputation formula based on uptime and storage
-func calculateReputation(uptimeHours, storageGB int) float64 {
-	baseReputation := float64(uptimeHours) / 100.0
-	storageBonus := float64(storageGB) / 50.0
-	return baseReputation + storageBonus
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Defaults for a reputation service.
+const (
+	// DefaultHalfLife is how long it takes for past observations to lose half their weight.
+	DefaultHalfLife = 24 * time.Hour
+	// DefaultPersistInterval is how often scores are written to the database.
+	DefaultPersistInterval = 5 * time.Minute
+	// NeutralScore is the score of a peer with no observations.
+	NeutralScore = 0.5
+)
+
+// Score weights. They sum to 1 so a well-behaved peer approaches a score of 1.
+const (
+	uptimeWeight   = 0.3
+	auditWeight    = 0.35
+	transferWeight = 0.25
+	latencyWeight  = 0.1
+
+	// latencyReference is the transfer latency at which the latency factor is 0.5.
+	latencyReference = time.Second
+)
+
+// PeerScore holds the decayed observations of a peer and its resulting score.
+// Counters are fractional because they decay exponentially over time.
+type PeerScore struct {
+	PeerID           string    `gorm:"primaryKey" json:"peerId"`
+	Heartbeats       float64   `json:"heartbeats"`
+	HeartbeatsOK     float64   `json:"heartbeatsOk"`
+	AuditsPassed     float64   `json:"auditsPassed"`
+	AuditsFailed     float64   `json:"auditsFailed"`
+	TransfersOK      float64   `json:"transfersOk"`
+	TransfersFailed  float64   `json:"transfersFailed"`
+	AvgLatencyMillis float64   `json:"avgLatencyMillis"`
+	Violations       float64   `json:"violations"`
+	Score            float64   `json:"score"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// TableName sets the database table for peer scores.
+func (PeerScore) TableName() string {
+	return "peer_reputations"
+}
+
+// ratio returns a smoothed success rate, starting at 0.5 with no observations.
+func ratio(ok, total float64) float64 {
+	return (ok + 1) / (total + 2)
+}
+
+// compute derives the score in the range [0, 1] from the observations.
+// Each protocol violation halves the remaining score.
+func (p *PeerScore) compute() float64 {
+	uptime := ratio(p.HeartbeatsOK, p.Heartbeats)
+	audits := ratio(p.AuditsPassed, p.AuditsPassed+p.AuditsFailed)
+	transfers := ratio(p.TransfersOK, p.TransfersOK+p.TransfersFailed)
+	latency := NeutralScore
+	if p.AvgLatencyMillis > 0 {
+		ref := float64(latencyReference.Milliseconds())
+		latency = ref / (ref + p.AvgLatencyMillis)
+	}
+	score := uptimeWeight*uptime + auditWeight*audits + transferWeight*transfers + latencyWeight*latency
+	return score * math.Pow(0.5, p.Violations)
+}
+
+// decay scales all counters down according to the time elapsed since the last update.
+func (p *PeerScore) decay(now time.Time, halfLife time.Duration) {
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = now
+		return
+	}
+	elapsed := now.Sub(p.UpdatedAt)
+	if elapsed <= 0 {
+		return
+	}
+	f := math.Pow(0.5, float64(elapsed)/float64(halfLife))
+	p.Heartbeats *= f
+	p.HeartbeatsOK *= f
+	p.AuditsPassed *= f
+	p.AuditsFailed *= f
+	p.TransfersOK *= f
+	p.TransfersFailed *= f
+	p.Violations *= f
+	p.UpdatedAt = now
+}
+
+// Service tracks per-peer reputation from heartbeats, storage audits, shard
+// transfers and protocol violations. Scores are kept in memory and
+// periodically persisted to the database when one is configured.
+type Service struct {
+	db       *gorm.DB
+	halfLife time.Duration
+
+	mu    sync.RWMutex
+	peers map[string]*PeerScore
+	dirty map[string]bool
+}
+
+// NewService creates a reputation service and loads stored scores from db.
+// db may be nil, in which case scores are only kept in memory.
+func NewService(db *gorm.DB) (*Service, error) {
+	s := &Service{
+		db:       db,
+		halfLife: DefaultHalfLife,
+		peers:    make(map[string]*PeerScore),
+		dirty:    make(map[string]bool),
+	}
+	if db == nil {
+		return s, nil
+	}
+	var stored []PeerScore
+	if err := db.Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	for i := range stored {
+		s.peers[stored[i].PeerID] = &stored[i]
+	}
+	log.Printf("[INFO] Loaded reputation for %d peers", len(stored))
+	return s, nil
+}
+
+// update applies fn to the peer's decayed record and recomputes its score.
+func (s *Service) update(peerID string, fn func(p *PeerScore)) {
+	if s == nil || peerID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.peers[peerID]
+	if !ok {
+		p = &PeerScore{PeerID: peerID}
+		s.peers[peerID] = p
+	}
+	p.decay(time.Now(), s.halfLife)
+	fn(p)
+	p.Score = p.compute()
+	s.dirty[peerID] = true
+}
+
+// RecordHeartbeat records whether a liveness check of the peer succeeded.
+func (s *Service) RecordHeartbeat(peerID string, ok bool) {
+	s.update(peerID, func(p *PeerScore) {
+		p.Heartbeats++
+		if ok {
+			p.HeartbeatsOK++
+		}
+	})
+}
+
+// RecordAudit records the outcome of verifying data served or stored by the peer.
+func (s *Service) RecordAudit(peerID string, passed bool) {
+	s.update(peerID, func(p *PeerScore) {
+		if passed {
+			p.AuditsPassed++
+		} else {
+			p.AuditsFailed++
+		}
+	})
+}
+
+// RecordTransfer records a shard transfer with the peer and, for successful
+// transfers, its latency.
+func (s *Service) RecordTransfer(peerID string, ok bool, latency time.Duration) {
+	s.update(peerID, func(p *PeerScore) {
+		if !ok {
+			p.TransfersFailed++
+			return
+		}
+		p.TransfersOK++
+		ms := float64(latency.Milliseconds())
+		if p.AvgLatencyMillis == 0 {
+			p.AvgLatencyMillis = ms
+		} else {
+			p.AvgLatencyMillis = 0.8*p.AvgLatencyMillis + 0.2*ms
+		}
+	})
+}
+
+// RecordViolation records a protocol violation such as flooding or an invalid message.
+func (s *Service) RecordViolation(peerID, reason string) {
+	if s == nil {
+		return
+	}
+	s.update(peerID, func(p *PeerScore) {
+		p.Violations++
+	})
+	log.Printf("[WARN] Reputation: violation by %s: %s", peerID, reason)
+}
+
+// Score returns the peer's current score in the range [0, 1], or NeutralScore
+// for unknown peers.
+func (s *Service) Score(peerID string) float64 {
+	if s == nil {
+		return NeutralScore
+	}
+	s.mu.RLock()
+	p, ok := s.peers[peerID]
+	if !ok {
+		s.mu.RUnlock()
+		return NeutralScore
+	}
+	current := *p
+	s.mu.RUnlock()
+	current.decay(time.Now(), s.halfLife)
+	return current.compute()
+}
+
+// Snapshot returns the decayed records of all known peers, best first.
+func (s *Service) Snapshot() []PeerScore {
+	if s == nil {
+		return nil
+	}
+	now := time.Now()
+	s.mu.RLock()
+	scores := make([]PeerScore, 0, len(s.peers))
+	for _, p := range s.peers {
+		current := *p
+		current.decay(now, s.halfLife)
+		current.Score = current.compute()
+		scores = append(scores, current)
+	}
+	s.mu.RUnlock()
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Score > scores[j].Score })
+	return scores
+}
+
+// Persist writes changed records to the database.
+func (s *Service) Persist() error {
+	if s == nil || s.db == nil {
+		return nil
+	}
+	s.mu.Lock()
+	changed := make([]PeerScore, 0, len(s.dirty))
+	for id := range s.dirty {
+		changed = append(changed, *s.peers[id])
+	}
+	s.dirty = make(map[string]bool)
+	s.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&changed).Error; err != nil {
+		// Keep the records dirty so the next Persist retries them.
+		s.mu.Lock()
+		for _, p := range changed {
+			s.dirty[p.PeerID] = true
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start persists scores every DefaultPersistInterval and once more when ctx is
+// cancelled. That last write may race process exit, so callers shutting down
+// should also call Persist themselves.
+func (s *Service) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(DefaultPersistInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := s.Persist(); err != nil {
+					log.Printf("[ERROR] Failed to persist reputation: %v", err)
+				}
+				return
+			case <-ticker.C:
+				if err := s.Persist(); err != nil {
+					log.Printf("[ERROR] Failed to persist reputation: %v", err)
+				}
+			}
+		}
+	}()
 }
@@ -0,0 +1,400 @@
+package rewards
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/auth"
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
+	"github.com/ArguableExorcist8/desvault-storage-node/storage"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// -----------------------------------------------------------------------------
+// Epochs
+// -----------------------------------------------------------------------------
+
+// EpochDuration is the length of a reward epoch. Epoch n covers
+// [n*EpochDuration, (n+1)*EpochDuration) since the Unix epoch, in UTC.
+const EpochDuration = 24 * time.Hour
+
+// EpochAt returns the epoch containing t.
+func EpochAt(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(EpochDuration/time.Second))
+}
+
+// EpochBounds returns the start and end of epoch.
+func EpochBounds(epoch uint64) (time.Time, time.Time) {
+	start := time.Unix(int64(epoch)*int64(EpochDuration/time.Second), 0).UTC()
+	return start, start.Add(EpochDuration)
+}
+
+// -----------------------------------------------------------------------------
+// Storage Proofs
+// -----------------------------------------------------------------------------
+
+// StorageProofs summarises the shards a node could prove it held when a
+// statement was made. Every local shard is read back and verified; the
+// Merkle root commits to the IDs of those that passed, so a verifier can
+// later challenge the node for any of them.
+type StorageProofs struct {
+	Shards       uint64      `json:"shards"`
+	Bytes        uint64      `json:"bytes"`
+	ShardsRoot   common.Hash `json:"shardsRoot"`
+	AuditsPassed uint64      `json:"auditsPassed"`
+	AuditsFailed uint64      `json:"auditsFailed"`
+}
+
+// CollectStorageProofs verifies the locally stored shards and summarises them.
+func CollectStorageProofs() (StorageProofs, error) {
+	ids, err := storage.ListLocalShards()
+	if err != nil {
+		return StorageProofs{}, err
+	}
+	var proofs StorageProofs
+	var held []string
+	for _, id := range ids {
+		data, err := storage.ReadLocalShard(id)
+		if err == nil {
+			err = storage.VerifyShard(id, data)
+		}
+		if err != nil {
+			log.Printf("[!] Storage proof failed for shard %s: %v", id, err)
+			proofs.AuditsFailed++
+			continue
+		}
+		proofs.AuditsPassed++
+		proofs.Bytes += uint64(len(data))
+		held = append(held, id)
+	}
+	proofs.Shards = uint64(len(held))
+	proofs.ShardsRoot = ShardsRoot(held)
+	return proofs, nil
+}
+
+// ShardsRoot returns the Merkle root of the given shard IDs. Leaves are the
+// Keccak-256 hashes of the decoded IDs, pairs are hashed in sorted order (as
+// OpenZeppelin's MerkleProof expects) and an odd node is carried up a level.
+// The root of no shards is the zero hash.
+func ShardsRoot(shardIDs []string) common.Hash {
+	level := make([][]byte, 0, len(shardIDs))
+	for _, id := range shardIDs {
+		raw, err := hex.DecodeString(id)
+		if err != nil {
+			raw = []byte(id)
+		}
+		level = append(level, crypto.Keccak256(raw))
+	}
+	if len(level) == 0 {
+		return common.Hash{}
+	}
+	sort.Slice(level, func(i, j int) bool { return bytes.Compare(level[i], level[j]) < 0 })
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			a, b := level[i], level[i+1]
+			if bytes.Compare(a, b) > 0 {
+				a, b = b, a
+			}
+			next = append(next, crypto.Keccak256(a, b))
+		}
+		level = next
+	}
+	return common.BytesToHash(level[0])
+}
+
+// -----------------------------------------------------------------------------
+// Statements and Claims
+// -----------------------------------------------------------------------------
+
+// Statement is a node's reward claim for one epoch. Points are cumulative
+// up to the end of the epoch, so a later statement supersedes earlier ones
+// and a redeemer pays out only the difference to what it already paid.
+type Statement struct {
+	NodeID     string         `json:"nodeId"`
+	Wallet     common.Address `json:"wallet"`
+	Epoch      uint64         `json:"epoch"`
+	EpochStart uint64         `json:"epochStart"` // Unix seconds
+	EpochEnd   uint64         `json:"epochEnd"`   // Unix seconds
+	Points     uint64         `json:"points"`
+	Proofs     StorageProofs  `json:"proofs"`
+}
+
+// ClaimDomain is the EIP-712 domain reward claims are signed in.
+type ClaimDomain struct {
+	ChainID           int64          // Omitted from the domain when zero
+	VerifyingContract common.Address // Omitted from the domain when zero
+}
+
+const (
+	claimDomainName    = "DesVault Rewards"
+	claimDomainVersion = "1"
+	claimPrimaryType   = "RewardStatement"
+)
+
+// ErrBadClaim is returned when a claim's signature or contents do not verify.
+var ErrBadClaim = errors.New("invalid reward claim")
+
+// typedData returns the EIP-712 typed data of the statement in domain.
+func (s *Statement) typedData(domain ClaimDomain) apitypes.TypedData {
+	domainTypes := []apitypes.Type{
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+	}
+	td := apitypes.TypedData{
+		PrimaryType: claimPrimaryType,
+		Domain: apitypes.TypedDataDomain{
+			Name:    claimDomainName,
+			Version: claimDomainVersion,
+		},
+	}
+	if domain.ChainID != 0 {
+		domainTypes = append(domainTypes, apitypes.Type{Name: "chainId", Type: "uint256"})
+		td.Domain.ChainId = math.NewHexOrDecimal256(domain.ChainID)
+	}
+	if domain.VerifyingContract != (common.Address{}) {
+		domainTypes = append(domainTypes, apitypes.Type{Name: "verifyingContract", Type: "address"})
+		td.Domain.VerifyingContract = domain.VerifyingContract.Hex()
+	}
+	td.Types = apitypes.Types{
+		"EIP712Domain": domainTypes,
+		claimPrimaryType: {
+			{Name: "nodeId", Type: "string"},
+			{Name: "wallet", Type: "address"},
+			{Name: "epoch", Type: "uint64"},
+			{Name: "epochStart", Type: "uint64"},
+			{Name: "epochEnd", Type: "uint64"},
+			{Name: "points", Type: "uint256"},
+			{Name: "proofs", Type: "StorageProofs"},
+		},
+		"StorageProofs": {
+			{Name: "shards", Type: "uint64"},
+			{Name: "bytes", Type: "uint64"},
+			{Name: "shardsRoot", Type: "bytes32"},
+			{Name: "auditsPassed", Type: "uint64"},
+			{Name: "auditsFailed", Type: "uint64"},
+		},
+	}
+	// Integers are decimal strings so the typed data survives a JSON round trip exactly.
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	td.Message = apitypes.TypedDataMessage{
+		"nodeId":     s.NodeID,
+		"wallet":     s.Wallet.Hex(),
+		"epoch":      u(s.Epoch),
+		"epochStart": u(s.EpochStart),
+		"epochEnd":   u(s.EpochEnd),
+		"points":     u(s.Points),
+		"proofs": map[string]interface{}{
+			"shards":       u(s.Proofs.Shards),
+			"bytes":        u(s.Proofs.Bytes),
+			"shardsRoot":   s.Proofs.ShardsRoot.Hex(),
+			"auditsPassed": u(s.Proofs.AuditsPassed),
+			"auditsFailed": u(s.Proofs.AuditsFailed),
+		},
+	}
+	return td
+}
+
+// Claim is a signed statement in a self-contained, exportable form. TypedData
+// is what eth_signTypedData_v4 takes, so wallets and contracts can check the
+// signature with standard EIP-712 tooling. NodeSignature is the node
+// identity's signature binding the wallet to the statement's node ID, so a
+// wallet cannot claim for a node it does not run.
+type Claim struct {
+	Statement     Statement          `json:"statement"`
+	TypedData     apitypes.TypedData `json:"typedData"`
+	Digest        common.Hash        `json:"digest"`
+	Signer        common.Address     `json:"signer"`
+	Signature     hexutil.Bytes      `json:"signature"`     // 65 bytes, V of 27 or 28
+	NodeKey       hexutil.Bytes      `json:"nodeKey"`       // Marshalled libp2p public key of the node
+	NodeSignature hexutil.Bytes      `json:"nodeSignature"` // Over walletBinding
+}
+
+// walletBinding returns the bytes the node identity signs to vouch for wallet.
+func walletBinding(nodeID string, wallet common.Address) []byte {
+	return []byte("desvault-reward-wallet:" + nodeID + ":" + wallet.Hex())
+}
+
+// SignStatement signs s with the wallet unlocked by auth.InitializeWallet,
+// setting s.Wallet to its address, and binds the wallet to the node with
+// identity, whose peer ID must be s.NodeID.
+func SignStatement(s *Statement, domain ClaimDomain, identity libp2pcrypto.PrivKey) (*Claim, error) {
+	if id, err := peer.IDFromPrivateKey(identity); err != nil || id.String() != s.NodeID {
+		return nil, fmt.Errorf("node identity %s does not match statement node %s", id, s.NodeID)
+	}
+	nodeKey, err := libp2pcrypto.MarshalPublicKey(identity.GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node key: %v", err)
+	}
+	wallet, err := auth.WalletAccountAddress()
+	if err != nil {
+		return nil, err
+	}
+	s.Wallet = wallet
+	nodeSig, err := identity.Sign(walletBinding(s.NodeID, wallet))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign wallet binding: %v", err)
+	}
+	td := s.typedData(domain)
+	digest, _, err := apitypes.TypedDataAndHash(td)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash reward statement: %v", err)
+	}
+	sig, err := auth.SignWalletHash(digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign reward statement: %v", err)
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return &Claim{
+		Statement: *s,
+		TypedData: td,
+		Digest:    common.BytesToHash(digest),
+		Signer:        wallet,
+		Signature:     sig,
+		NodeKey:       nodeKey,
+		NodeSignature: nodeSig,
+	}, nil
+}
+
+// VerifyClaim checks that the claim was signed by its signer, that the
+// signer is the statement's wallet, that the statement's node vouched for
+// that wallet and that the typed data matches the statement. It needs no keys
+// or node state, so anyone holding an exported claim can run it. It returns
+// the verified statement.
+func VerifyClaim(c *Claim) (*Statement, error) {
+	if c.TypedData.PrimaryType != claimPrimaryType || c.TypedData.Domain.Name != claimDomainName {
+		return nil, fmt.Errorf("%w: not a DesVault reward statement", ErrBadClaim)
+	}
+	if len(c.Signature) != crypto.SignatureLength {
+		return nil, fmt.Errorf("%w: signature must be %d bytes", ErrBadClaim, crypto.SignatureLength)
+	}
+	digest, _, err := apitypes.TypedDataAndHash(c.TypedData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadClaim, err)
+	}
+	if c.Digest != (common.Hash{}) && c.Digest != common.BytesToHash(digest) {
+		return nil, fmt.Errorf("%w: digest does not match the typed data", ErrBadClaim)
+	}
+	sig := append([]byte(nil), c.Signature...)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(digest, sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadClaim, err)
+	}
+	if signer := crypto.PubkeyToAddress(*pub); signer != c.Signer || signer != c.Statement.Wallet {
+		return nil, fmt.Errorf("%w: signed by %s, not %s", ErrBadClaim, signer.Hex(), c.Statement.Wallet.Hex())
+	}
+	nodeKey, err := libp2pcrypto.UnmarshalPublicKey(c.NodeKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid node key: %v", ErrBadClaim, err)
+	}
+	if id, err := peer.IDFromPublicKey(nodeKey); err != nil || id.String() != c.Statement.NodeID {
+		return nil, fmt.Errorf("%w: node key does not belong to node %s", ErrBadClaim, c.Statement.NodeID)
+	}
+	if ok, err := nodeKey.Verify(walletBinding(c.Statement.NodeID, c.Statement.Wallet), c.NodeSignature); err != nil || !ok {
+		return nil, fmt.Errorf("%w: node %s did not vouch for wallet %s", ErrBadClaim, c.Statement.NodeID, c.Statement.Wallet.Hex())
+	}
+
+	// The statement is a convenience copy; it must say exactly what was signed.
+	var domain ClaimDomain
+	if c.TypedData.Domain.ChainId != nil {
+		domain.ChainID = (*big.Int)(c.TypedData.Domain.ChainId).Int64()
+	}
+	if c.TypedData.Domain.VerifyingContract != "" {
+		domain.VerifyingContract = common.HexToAddress(c.TypedData.Domain.VerifyingContract)
+	}
+	expected, _, err := apitypes.TypedDataAndHash(c.Statement.typedData(domain))
+	if err != nil || !bytes.Equal(expected, digest) {
+		return nil, fmt.Errorf("%w: statement does not match the signed typed data", ErrBadClaim)
+	}
+	return &c.Statement, nil
+}
+
+// -----------------------------------------------------------------------------
+// Claim Files
+// -----------------------------------------------------------------------------
+
+// ClaimsDir returns the directory holding exported claims (~/.desvault/claims).
+func ClaimsDir() (string, error) {
+	dir, err := setup.GetDesVaultDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "claims")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create claims directory: %v", err)
+	}
+	return dir, nil
+}
+
+// ClaimPath returns the file the claim for epoch is saved to.
+func ClaimPath(epoch uint64) (string, error) {
+	dir, err := ClaimsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("epoch-%d.json", epoch)), nil
+}
+
+// SaveClaim writes c as indented JSON to path.
+func SaveClaim(c *Claim, path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal claim: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write claim: %w", err)
+	}
+	return nil
+}
+
+// LoadClaim reads a claim saved by SaveClaim. It does not verify it.
+func LoadClaim(path string) (*Claim, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read claim: %w", err)
+	}
+	var c Claim
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse claim: %w", err)
+	}
+	return &c, nil
+}
+
+// IssueClaim signs st and saves the claim to ClaimPath.
+func IssueClaim(st *Statement, domain ClaimDomain, identity libp2pcrypto.PrivKey) (*Claim, string, error) {
+	claim, err := SignStatement(st, domain, identity)
+	if err != nil {
+		return nil, "", err
+	}
+	path, err := ClaimPath(st.Epoch)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := SaveClaim(claim, path); err != nil {
+		return nil, "", err
+	}
+	return claim, path, nil
+}
@@ -0,0 +1,402 @@
+package rewards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+)
+
+const (
+	// SampleInterval is how often a running node records uptime and storage.
+	SampleInterval = 5 * time.Minute
+	// maxSampleGap is the longest gap between samples credited as uptime;
+	// longer gaps mean the node was down.
+	maxSampleGap = 2 * SampleInterval
+
+	// unprovenAuditRatio scales the points of epochs finalized without
+	// storage proofs, since nothing shows the node held its shards then.
+	unprovenAuditRatio = 0.5
+)
+
+// ErrEpochNotFinalized is returned when a finalized epoch is required.
+var ErrEpochNotFinalized = errors.New("epoch is not finalized")
+
+// Epoch is a node's ledger entry for one reward epoch. The inputs are
+// recorded while the epoch runs and when it is finalized; the outputs are
+// derived from them by compute, so they can be replayed at any time.
+type Epoch struct {
+	NodeID string `gorm:"primaryKey;size:64" json:"nodeId"`
+	Epoch  uint64 `gorm:"primaryKey;autoIncrement:false" json:"epoch"`
+
+	// Inputs
+	UptimeSeconds    int64     `json:"uptimeSeconds"`
+	StorageGB        int       `json:"storageGB"`        // Allocated storage at the last sample
+	StorageUsedBytes int64     `json:"storageUsedBytes"` // Shard bytes stored at the last sample
+	NodeType         string    `gorm:"size:16" json:"nodeType"`
+	Multiplier       float64   `json:"multiplier"`
+	AuditsPassed     uint64    `json:"auditsPassed"`
+	AuditsFailed     uint64    `json:"auditsFailed"`
+	ShardsHeld       uint64    `json:"shardsHeld"`
+	ShardsBytes      uint64    `json:"shardsBytes"`
+	ShardsRoot       string    `gorm:"size:66" json:"shardsRoot,omitempty"`
+	ProofsMissing    bool      `json:"proofsMissing,omitempty"` // Finalized late, without storage proofs
+	LastSampleAt     time.Time `json:"lastSampleAt"`
+
+	// Outputs
+	BasePoints       uint64     `json:"basePoints"`
+	Points           uint64     `json:"points"`
+	CumulativePoints uint64     `json:"cumulativePoints"`
+	FinalizedAt      *time.Time `json:"finalizedAt,omitempty"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+}
+
+// TableName sets the database table for reward epochs.
+func (Epoch) TableName() string {
+	return "reward_epochs"
+}
+
+// Finalized reports whether the epoch's inputs are complete.
+func (e *Epoch) Finalized() bool {
+	return e.FinalizedAt != nil
+}
+
+// compute derives the epoch's base points and points from its inputs:
+// CalculatePoints per allocated GB for every hour of uptime, scaled by the
+// node type multiplier and the share of storage audits passed. A node
+// holding no shards passes trivially; an epoch finalized without proofs
+// gets unprovenAuditRatio instead.
+func (e *Epoch) compute() {
+	hours := float64(e.UptimeSeconds) / 3600
+	e.BasePoints = uint64(hours * float64(CalculatePoints(e.StorageGB)))
+	auditRatio := 1.0
+	if e.ProofsMissing {
+		auditRatio = unprovenAuditRatio
+	} else if total := e.AuditsPassed + e.AuditsFailed; total > 0 {
+		auditRatio = float64(e.AuditsPassed) / float64(total)
+	}
+	e.Points = uint64(math.Round(float64(e.BasePoints) * e.Multiplier * auditRatio))
+}
+
+// Statement returns the reward statement of a finalized epoch.
+func (e *Epoch) Statement() (*Statement, error) {
+	if !e.Finalized() {
+		return nil, fmt.Errorf("%w: %d", ErrEpochNotFinalized, e.Epoch)
+	}
+	start, end := EpochBounds(e.Epoch)
+	return &Statement{
+		NodeID:     e.NodeID,
+		Epoch:      e.Epoch,
+		EpochStart: uint64(start.Unix()),
+		EpochEnd:   uint64(end.Unix()),
+		Points:     e.CumulativePoints,
+		Proofs: StorageProofs{
+			Shards:       e.ShardsHeld,
+			Bytes:        e.ShardsBytes,
+			ShardsRoot:   common.HexToHash(e.ShardsRoot),
+			AuditsPassed: e.AuditsPassed,
+			AuditsFailed: e.AuditsFailed,
+		},
+	}, nil
+}
+
+// Sample is a node's state at one point in an epoch.
+type Sample struct {
+	StorageGB int
+	UsedBytes int64
+	NodeType  string
+}
+
+// -----------------------------------------------------------------------------
+// Ledger
+// -----------------------------------------------------------------------------
+
+// Ledger records a node's reward epochs in the database. It is the only
+// record of earned points.
+type Ledger struct {
+	db     *gorm.DB
+	nodeID string
+}
+
+// NewLedger returns the ledger of nodeID backed by db. The reward_epochs
+// table must have been migrated.
+func NewLedger(db *gorm.DB, nodeID string) *Ledger {
+	return &Ledger{db: db, nodeID: nodeID}
+}
+
+// load returns the entry for epoch, or a new unsaved one.
+func (l *Ledger) load(tx *gorm.DB, epoch uint64) (*Epoch, error) {
+	var rows []Epoch
+	if err := tx.Where("node_id = ? AND epoch = ?", l.nodeID, epoch).Limit(1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return &Epoch{NodeID: l.nodeID, Epoch: epoch}, nil
+	}
+	return &rows[0], nil
+}
+
+// cumulativeBefore returns the cumulative points of the last finalized epoch before epoch.
+func (l *Ledger) cumulativeBefore(tx *gorm.DB, epoch uint64) (uint64, error) {
+	return l.lastCumulative(tx.Where("epoch < ?", epoch))
+}
+
+// lastCumulative returns the cumulative points of the last finalized epoch matching query.
+func (l *Ledger) lastCumulative(query *gorm.DB) (uint64, error) {
+	var rows []Epoch
+	err := query.Where("node_id = ? AND finalized_at IS NOT NULL", l.nodeID).
+		Order("epoch DESC").Limit(1).Find(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return rows[0].CumulativePoints, nil
+}
+
+// RecordSample credits the time since the previous sample as uptime, split
+// across epoch boundaries, and records s in the epoch containing now. Gaps
+// longer than maxSampleGap, such as across a restart, are not credited, and
+// repeating a sample credits nothing, so samples are safe to replay.
+func (l *Ledger) RecordSample(now time.Time, s Sample) error {
+	return l.db.Transaction(func(tx *gorm.DB) error {
+		var last []Epoch
+		if err := tx.Where("node_id = ?", l.nodeID).Order("epoch DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		if len(last) > 0 && !last[0].LastSampleAt.IsZero() {
+			from := last[0].LastSampleAt
+			if gap := now.Sub(from); gap > 0 && gap <= maxSampleGap {
+				for from.Before(now) {
+					epoch := EpochAt(from)
+					_, end := EpochBounds(epoch)
+					to := now
+					if end.Before(to) {
+						to = end
+					}
+					e, err := l.load(tx, epoch)
+					if err != nil {
+						return err
+					}
+					if !e.Finalized() {
+						e.UptimeSeconds += int64(to.Sub(from) / time.Second)
+						if limit := int64(EpochDuration / time.Second); e.UptimeSeconds > limit {
+							e.UptimeSeconds = limit
+						}
+						if err := l.save(tx, e); err != nil {
+							return err
+						}
+					}
+					from = to
+				}
+			}
+		}
+
+		e, err := l.load(tx, EpochAt(now))
+		if err != nil {
+			return err
+		}
+		if e.Finalized() {
+			return nil
+		}
+		e.StorageGB = s.StorageGB
+		e.StorageUsedBytes = s.UsedBytes
+		e.NodeType = s.NodeType
+		e.Multiplier = GetMultiplier(s.NodeType)
+		e.LastSampleAt = now
+		return l.save(tx, e)
+	})
+}
+
+// save recomputes an unfinalized entry's provisional outputs and stores it.
+func (l *Ledger) save(tx *gorm.DB, e *Epoch) error {
+	e.compute()
+	prev, err := l.cumulativeBefore(tx, e.Epoch)
+	if err != nil {
+		return err
+	}
+	e.CumulativePoints = prev + e.Points
+	return tx.Save(e).Error
+}
+
+// Finalize records the storage proofs of epoch and fixes its outputs. A nil
+// proofs finalizes the epoch as unproven. An epoch without samples is
+// finalized with no points. Finalizing an epoch again returns it unchanged.
+func (l *Ledger) Finalize(epoch uint64, proofs *StorageProofs) (*Epoch, error) {
+	var e *Epoch
+	err := l.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if e, err = l.load(tx, epoch); err != nil || e.Finalized() {
+			return err
+		}
+		if proofs == nil {
+			proofs = &StorageProofs{}
+			e.ProofsMissing = true
+		}
+		e.AuditsPassed = proofs.AuditsPassed
+		e.AuditsFailed = proofs.AuditsFailed
+		e.ShardsHeld = proofs.Shards
+		e.ShardsBytes = proofs.Bytes
+		e.ShardsRoot = proofs.ShardsRoot.Hex()
+		now := time.Now()
+		e.FinalizedAt = &now
+		return l.save(tx, e)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize epoch %d: %v", epoch, err)
+	}
+	return e, nil
+}
+
+// Pending returns the epochs before current that were not finalized yet,
+// oldest first.
+func (l *Ledger) Pending(current uint64) ([]uint64, error) {
+	var epochs []uint64
+	err := l.db.Model(&Epoch{}).
+		Where("node_id = ? AND epoch < ? AND finalized_at IS NULL", l.nodeID, current).
+		Order("epoch").Pluck("epoch", &epochs).Error
+	return epochs, err
+}
+
+// Replay recomputes the provisional outputs of every unfinalized epoch from
+// its inputs and returns how many entries changed. Finalized outputs are
+// never rewritten, since claims over them may already have been signed;
+// instead the finalized epochs whose points the current formula would change
+// are returned in drifted. Replaying an unchanged ledger changes nothing.
+func (l *Ledger) Replay() (changed int, drifted []uint64, err error) {
+	err = l.db.Transaction(func(tx *gorm.DB) error {
+		var epochs []Epoch
+		if err := tx.Where("node_id = ?", l.nodeID).Order("epoch").Find(&epochs).Error; err != nil {
+			return err
+		}
+		var cumulative uint64
+		for i := range epochs {
+			e := &epochs[i]
+			if e.Finalized() {
+				replayed := *e
+				replayed.compute()
+				if replayed.Points != e.Points {
+					drifted = append(drifted, e.Epoch)
+				}
+				cumulative = e.CumulativePoints
+				continue
+			}
+			before := [3]uint64{e.BasePoints, e.Points, e.CumulativePoints}
+			e.compute()
+			e.CumulativePoints = cumulative + e.Points
+			if before == [3]uint64{e.BasePoints, e.Points, e.CumulativePoints} {
+				continue
+			}
+			if err := tx.Save(e).Error; err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	return changed, drifted, err
+}
+
+// Get returns the entry for epoch, or gorm.ErrRecordNotFound.
+func (l *Ledger) Get(epoch uint64) (*Epoch, error) {
+	var e Epoch
+	if err := l.db.Where("node_id = ? AND epoch = ?", l.nodeID, epoch).First(&e).Error; err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Epochs returns up to limit entries, newest first.
+func (l *Ledger) Epochs(limit int) ([]Epoch, error) {
+	var epochs []Epoch
+	err := l.db.Where("node_id = ?", l.nodeID).Order("epoch DESC").Limit(limit).Find(&epochs).Error
+	return epochs, err
+}
+
+// TotalPoints returns the points of all finalized epochs and the total
+// including the provisional points of epochs still running.
+func (l *Ledger) TotalPoints() (finalized, provisional uint64, err error) {
+	finalized, err = l.lastCumulative(l.db)
+	if err != nil {
+		return 0, 0, err
+	}
+	var latest []Epoch
+	if err = l.db.Where("node_id = ?", l.nodeID).Order("epoch DESC").Limit(1).Find(&latest).Error; err != nil {
+		return 0, 0, err
+	}
+	provisional = finalized
+	if len(latest) > 0 {
+		provisional = latest[0].CumulativePoints
+	}
+	return finalized, provisional, nil
+}
+
+// Start records a sample every SampleInterval and finalizes each epoch once
+// it has ended, including epochs left open by a previous run, until ctx is
+// cancelled. Storage proofs describe the node when they are collected, so
+// only an epoch that ended within maxSampleGap receives them; epochs
+// finalized late, after the node was down across their end, are finalized
+// as unproven. sample returns the node's current state; onFinalize, if set,
+// is called with every newly finalized epoch.
+func (l *Ledger) Start(ctx context.Context, sample func() Sample, onFinalize func(*Epoch)) {
+	tick := func() {
+		now := time.Now()
+		if err := l.RecordSample(now, sample()); err != nil {
+			log.Printf("[!] Failed to record reward sample: %v", err)
+		}
+		pending, err := l.Pending(EpochAt(now))
+		if err != nil {
+			log.Printf("[!] Failed to list reward epochs: %v", err)
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+		var proofs *StorageProofs
+		latest := pending[len(pending)-1]
+		if _, end := EpochBounds(latest); now.Sub(end) <= maxSampleGap {
+			collected, err := CollectStorageProofs()
+			if err != nil {
+				log.Printf("[!] Failed to collect storage proofs: %v", err)
+				return
+			}
+			proofs = &collected
+		}
+		for _, epoch := range pending {
+			var epochProofs *StorageProofs
+			if epoch == latest {
+				epochProofs = proofs
+			}
+			e, err := l.Finalize(epoch, epochProofs)
+			if err != nil {
+				log.Printf("[!] %v", err)
+				return
+			}
+			log.Printf("[+] Finalized reward epoch %d: %d points (%d total)", e.Epoch, e.Points, e.CumulativePoints)
+			if onFinalize != nil {
+				onFinalize(e)
+			}
+		}
+		// Repeating the sample credits nothing but carries the new total into the running epoch.
+		if err := l.RecordSample(now, sample()); err != nil {
+			log.Printf("[!] Failed to record reward sample: %v", err)
+		}
+	}
+
+	go func() {
+		tick()
+		ticker := time.NewTicker(SampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tick()
+			}
+		}
+	}()
+}
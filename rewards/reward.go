@@ -1,11 +1,7 @@
 package rewards
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
-	"os"
-	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -66,62 +62,6 @@ func GetMultiplier(nodeType string) float64 {
 	}
 }
 
-var mu sync.Mutex
-var rewardFile = "rewards.json"
-
-// SaveRewards saves the rewards map (nodeID -> Reward) to disk as a JSON file.
-func SaveRewards(rewardsMap map[string]Reward) error {
-	mu.Lock()
-	defer mu.Unlock()
-
-	data, err := json.MarshalIndent(rewardsMap, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal rewards: %w", err)
-	}
-
-	err = os.WriteFile(rewardFile, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write rewards file: %w", err)
-	}
-
-	log.Println("[+] Rewards saved to disk.")
-	return nil
-}
-
-// LoadRewards loads the rewards map from disk.
-// If the file does not exist, it returns an empty map.
-func LoadRewards() (map[string]Reward, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	data, err := os.ReadFile(rewardFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// No rewards file yet; return an empty map.
-			return map[string]Reward{}, nil
-		}
-		return nil, fmt.Errorf("failed to read rewards file: %w", err)
-	}
-
-	var rewardsMap map[string]Reward
-	if err := json.Unmarshal(data, &rewardsMap); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal rewards: %w", err)
-	}
-
-	return rewardsMap, nil
-}
-
-// LoadNodeRewards returns the rewards map; if an error occurs (other than file not existing),
-// it logs the error and returns an empty map.
-func LoadNodeRewards() map[string]Reward {
-	rewardsMap, err := LoadRewards()
-	if err != nil {
-		log.Printf("[!] Error loading node rewards: %v", err)
-		return map[string]Reward{}
-	}
-	return rewardsMap
-}
-
 // GenerateRewardID generates a unique reward identifier. In production, you might use this to track reward entries.
 func GenerateRewardID() string {
 	return uuid.New().String()
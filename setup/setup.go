@@ -4,39 +4,109 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
+// Version is the DesVault node software version.
+const Version = "0.1.0"
+
 // Config holds basic configuration settings.
 type Config struct {
 	Region            string       `json:"region"`
 	WalletConfig      WalletConfig `json:"walletConfig"`
 	StorageAllocation int          `json:"storageAllocation"`
+	// BootstrapPeers are multiaddrs (including /p2p/<peer ID>) dialed at startup.
+	BootstrapPeers []string `json:"bootstrapPeers"`
+	// EnableRelayService lets a publicly reachable node relay traffic for peers behind NAT.
+	EnableRelayService bool `json:"enableRelayService"`
+	// EnableNATPortMap asks the local router to forward the listen port via UPnP or NAT-PMP.
+	EnableNATPortMap bool `json:"enableNATPortMap"`
+	// TCPPort and QUICPort are the libp2p listen ports; a QUICPort of 0 disables QUIC.
+	TCPPort  int `json:"tcpPort"`
+	QUICPort int `json:"quicPort"`
+	// EnableWebTransport adds a WebTransport listener on WebTransportPort for browser clients.
+	EnableWebTransport bool `json:"enableWebTransport"`
+	WebTransportPort   int  `json:"webTransportPort"`
+	// EnableIPv6 also listens on all IPv6 interfaces.
+	EnableIPv6 bool `json:"enableIPv6"`
+	// ConnLowWater and ConnHighWater bound the number of open connections; above
+	// the high watermark unprotected peers are trimmed down to the low one.
+	ConnLowWater  int `json:"connLowWater"`
+	ConnHighWater int `json:"connHighWater"`
+	// PrivateNetwork restricts the node to peers sharing ~/.desvault/swarm.key.
+	PrivateNetwork bool `json:"privateNetwork"`
+	// TLSCertFile and TLSKeyFile are optional operator-supplied PEM files for the
+	// QUIC shard service; the certificate must be self-signed with the node's
+	// identity key. When unset the node manages a certificate derived from
+	// its identity key in ~/.desvault/tls.
+	TLSCertFile string `json:"tlsCertFile"`
+	TLSKeyFile  string `json:"tlsKeyFile"`
+	// ShardServicePort is the UDP port of the QUIC shard service run by the
+	// node and announced to peers; 0 disables it.
+	ShardServicePort int `json:"shardServicePort"`
+	// NodeType is "cloud", "local" or "hybrid" and sets the reward multiplier.
+	NodeType string `json:"nodeType"`
 	// You could add endpoints, database settings, etc.
 }
 
 // WalletConfig holds configuration for wallet authentication.
 type WalletConfig struct {
 	APIKey string `json:"apiKey"`
+	// KeystoreDir is the keystore whose first account, unlocked with
+	// WALLET_PASSWORD, signs reward claims.
+	KeystoreDir string `json:"keystoreDir"`
+	// ChainID and RewardsContract bind reward claims to a chain and the
+	// contract that redeems them; both are optional.
+	ChainID         int64  `json:"chainId"`
+	RewardsContract string `json:"rewardsContract"`
 }
 
 // LoadConfig loads configuration from "config.json" if available,
 // otherwise falls back to defaults or environment variables.
+// Settings missing from the file keep their defaults, and peers listed in the
+// comma-separated DESVAULT_BOOTSTRAP_PEERS variable are added to BootstrapPeers.
+// DESVAULT_PRIVATE_NETWORK=1 enables private network mode, and
+// DESVAULT_TLS_CERT/DESVAULT_TLS_KEY override the TLS certificate files and
+// DESVAULT_WALLET_KEYSTORE the wallet keystore directory.
 func LoadConfig() (*Config, error) {
+	config := Config{
+		Region:            "us-east-1",
+		WalletConfig:      WalletConfig{APIKey: os.Getenv("WALLET_API_KEY")},
+		StorageAllocation: 100,
+		TCPPort:           4001,
+		QUICPort:          4001,
+		WebTransportPort:  4001,
+		EnableIPv6:        true,
+		ConnLowWater:      100,
+		ConnHighWater:     400,
+		ShardServicePort:  4242,
+	}
 	f, err := os.Open("config.json")
-	if err != nil {
-		// Fallback to defaults if config file is not found.
-		return &Config{
-			Region:            "us-east-1",
-			WalletConfig:      WalletConfig{APIKey: os.Getenv("WALLET_API_KEY")},
-			StorageAllocation: 100,
-		}, nil
+	if err == nil {
+		defer f.Close()
+		decoder := json.NewDecoder(f)
+		if err := decoder.Decode(&config); err != nil {
+			return nil, fmt.Errorf("error decoding config: %v", err)
+		}
+	}
+	if os.Getenv("DESVAULT_PRIVATE_NETWORK") == "1" {
+		config.PrivateNetwork = true
+	}
+	if certFile := os.Getenv("DESVAULT_TLS_CERT"); certFile != "" {
+		config.TLSCertFile = certFile
+	}
+	if keyFile := os.Getenv("DESVAULT_TLS_KEY"); keyFile != "" {
+		config.TLSKeyFile = keyFile
 	}
-	defer f.Close()
-	decoder := json.NewDecoder(f)
-	var config Config
-	if err := decoder.Decode(&config); err != nil {
-		return nil, fmt.Errorf("error decoding config: %v", err)
+	if dir := os.Getenv("DESVAULT_WALLET_KEYSTORE"); dir != "" {
+		config.WalletConfig.KeystoreDir = dir
+	}
+	for _, addr := range strings.Split(os.Getenv("DESVAULT_BOOTSTRAP_PEERS"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			config.BootstrapPeers = append(config.BootstrapPeers, addr)
+		}
 	}
 	return &config, nil
 }
@@ -83,6 +153,19 @@ func SetStorageAllocation(newAlloc int) error {
 	return nil
 }
 
+// GetDesVaultDir returns the node's data directory (~/.desvault), creating it if needed.
+func GetDesVaultDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %v", err)
+	}
+	dir := filepath.Join(home, ".desvault")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+	return dir, nil
+}
+
 // FirstTimeSetup performs any initial setup tasks.
 func FirstTimeSetup() {
 	fmt.Println("[INFO] Performing first-time setup...")
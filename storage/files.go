@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/auth"
+)
+
+// -----------------------------------------------------------------------------
+// File Metadata
+// -----------------------------------------------------------------------------
+
+// FileMetadataModel is the database record of a file uploaded to this node.
+type FileMetadataModel struct {
+	CID       string         `gorm:"column:cid;primaryKey;not null;size:255" json:"cid"`
+	FileName  string         `gorm:"size:255" json:"fileName"`
+	Note      string         `gorm:"size:255" json:"note"`
+	FileSize  string         `gorm:"size:255" json:"fileSize"`
+	Shards    datatypes.JSON `gorm:"type:jsonb" json:"shards"`
+	Owner     string         `gorm:"size:128;index" json:"owner"` // Principal ID of the uploader
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// LoadFileFor loads a file's metadata if the caller holds perm on it. Files
+// the caller may not access are reported as not found.
+func LoadFileFor(c *gin.Context, db *gorm.DB, access *auth.AccessControl, cid, perm string) (FileMetadataModel, bool) {
+	var model FileMetadataModel
+	err := db.First(&model, "cid = ?", cid).Error
+	if err == nil {
+		var allowed bool
+		if allowed, err = access.Allowed(auth.PrincipalFromContext(c), cid, model.Owner, perm); err == nil && !allowed {
+			err = gorm.ErrRecordNotFound
+		}
+	}
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": "File metadata not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": fmt.Sprintf("Database error: %v", err)})
+		}
+		return model, false
+	}
+	return model, true
+}
+
+// ReleaseShards deletes the local copies of a deleted file's shards that no
+// remaining file references and that no peer pushed to this node. untrack, if
+// set, is called for each released shard so the repair loop stops
+// maintaining it. The reprovider only announces shards stored locally, so
+// their DHT provider records expire.
+func ReleaseShards(db *gorm.DB, model FileMetadataModel, untrack func(shardID string)) {
+	var shards []Shard
+	if err := json.Unmarshal(model.Shards, &shards); err != nil {
+		log.Printf("[WARN] Failed to parse shards of %s: %v", model.CID, err)
+		return
+	}
+	var others []FileMetadataModel
+	if err := db.Select("cid", "shards").Where("cid <> ?", model.CID).Find(&others).Error; err != nil {
+		log.Printf("[WARN] Failed to check shard references of %s: %v", model.CID, err)
+		return
+	}
+	referenced := make(map[string]bool)
+	for _, other := range others {
+		var otherShards []Shard
+		if err := json.Unmarshal(other.Shards, &otherShards); err != nil {
+			// Keep everything rather than delete a shard another file may need.
+			log.Printf("[WARN] Failed to parse shards of %s: %v", other.CID, err)
+			return
+		}
+		for _, shard := range otherShards {
+			referenced[shard.ID] = true
+		}
+	}
+	released := 0
+	for _, shard := range shards {
+		// A peer may have pushed a shard with the same content; it still
+		// relies on this node to keep it.
+		if referenced[shard.ID] || HeldForPeers(shard.ID) {
+			continue
+		}
+		if err := DeleteLocalShard(shard.ID); err != nil {
+			log.Printf("[WARN] %v", err)
+			continue
+		}
+		if untrack != nil {
+			untrack(shard.ID)
+		}
+		released++
+	}
+	log.Printf("[INFO] Released %d of %d shards of %s", released, len(shards), model.CID)
+}
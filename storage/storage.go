@@ -12,9 +12,12 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	shell "github.com/ipfs/go-ipfs-api"
+
+	"github.com/ArguableExorcist8/desvault-storage-node/setup"
 )
 
 // -----------------------------------------------------------------------------
@@ -41,6 +44,11 @@ var (
 	// ShardMap tracks stored shards (for production, consider a persistent store).
 	ShardMap = make(map[string]bool)
 	mu       sync.Mutex // Mutex for thread-safe operations
+
+	// remoteShardFetcher returns encrypted shard data from other nodes when IPFS cannot.
+	remoteShardFetcher func(shardID string) ([]byte, error)
+	// shardStoredHook is called after a shard pushed by a peer is stored.
+	shardStoredHook func(shardID string)
 )
 
 // encryptionKey is a 32-byte key for AES-256 encryption.
@@ -256,8 +264,8 @@ func UploadFileWithMetadata(filePath string) (FileMetadata, error) {
 	return metadata, nil
 }
 
-// DownloadShardFromIPFS downloads a shard by its IPFS CID and decrypts it.
-func DownloadShardFromIPFS(cid string) ([]byte, error) {
+// FetchEncryptedShardFromIPFS retrieves the encrypted bytes of a shard by its IPFS CID.
+func FetchEncryptedShardFromIPFS(cid string) ([]byte, error) {
 	sh := ConnectToIPFS()
 
 	// Retrieve encrypted data from IPFS.
@@ -275,6 +283,15 @@ func DownloadShardFromIPFS(cid string) ([]byte, error) {
 	if len(encryptedData) == 0 {
 		return nil, fmt.Errorf("no data received for CID %s", cid)
 	}
+	return encryptedData, nil
+}
+
+// DownloadShardFromIPFS downloads a shard by its IPFS CID and decrypts it.
+func DownloadShardFromIPFS(cid string) ([]byte, error) {
+	encryptedData, err := FetchEncryptedShardFromIPFS(cid)
+	if err != nil {
+		return nil, err
+	}
 
 	// Decrypt the data.
 	plainData, err := DecryptData(encryptedData, encryptionKey)
@@ -294,9 +311,9 @@ func DownloadFile(shards []Shard, outputPath string) error {
 	defer outputFile.Close()
 
 	for _, shard := range shards {
-		data, err := DownloadShardFromIPFS(shard.CID)
+		data, err := downloadShard(shard)
 		if err != nil {
-			return fmt.Errorf("failed to download shard with CID %s: %w", shard.CID, err)
+			return err
 		}
 		if _, err := outputFile.Write(data); err != nil {
 			return fmt.Errorf("failed to write shard %s to output: %w", shard.ID, err)
@@ -306,6 +323,51 @@ func DownloadFile(shards []Shard, outputPath string) error {
 	return nil
 }
 
+// SetShardStoredHook registers a function called with the ID of every shard
+// stored by WriteLocalShard, for example to announce it to other nodes.
+func SetShardStoredHook(hook func(shardID string)) {
+	mu.Lock()
+	shardStoredHook = hook
+	mu.Unlock()
+}
+
+// SetRemoteShardFetcher registers the function used to fetch shards from other
+// nodes when neither a local copy nor IPFS can serve them.
+func SetRemoteShardFetcher(fetcher func(shardID string) ([]byte, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	remoteShardFetcher = fetcher
+}
+
+// downloadShard returns the decrypted data of a shard from the local store,
+// IPFS or, failing both, the registered remote fetcher.
+func downloadShard(shard Shard) ([]byte, error) {
+	if HasLocalShard(shard.ID) {
+		if encrypted, err := ReadLocalShard(shard.ID); err == nil {
+			if data, err := DecryptData(encrypted, encryptionKey); err == nil {
+				return data, nil
+			}
+		}
+	}
+	data, ipfsErr := DownloadShardFromIPFS(shard.CID)
+	if ipfsErr == nil {
+		return data, nil
+	}
+
+	mu.Lock()
+	fetcher := remoteShardFetcher
+	mu.Unlock()
+	if fetcher == nil {
+		return nil, fmt.Errorf("failed to download shard with CID %s: %w", shard.CID, ipfsErr)
+	}
+	log.Printf("[WARN] IPFS could not serve shard %s, trying network providers: %v", shard.ID, ipfsErr)
+	encrypted, err := fetcher(shard.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download shard %s from IPFS (%v) or network: %w", shard.ID, ipfsErr, err)
+	}
+	return DecryptData(encrypted, encryptionKey)
+}
+
 // ListFiles retrieves pinned files from IPFS.
 func ListFiles() ([]map[string]interface{}, error) {
 	sh := ConnectToIPFS()
@@ -336,6 +398,193 @@ func GetFilePath(cid string) (string, error) {
 	return filepath.Join(GetStorageDir(), cid+".bin"), nil
 }
 
+// -----------------------------------------------------------------------------
+// Local Shard Store
+// -----------------------------------------------------------------------------
+
+// localShardPath returns the path of the permanent local copy of a shard.
+func localShardPath(shardID string) (string, error) {
+	// Shard IDs are hex-encoded SHA-256 digests; reject anything that could escape the storage directory.
+	if shardID == "" || strings.ContainsAny(shardID, `/\.`) {
+		return "", fmt.Errorf("invalid shard ID %q", shardID)
+	}
+	return filepath.Join(GetStorageDir(), shardID+".bin"), nil
+}
+
+// HasLocalShard reports whether an encrypted copy of the shard is stored locally.
+func HasLocalShard(shardID string) bool {
+	path, err := localShardPath(shardID)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// ReadLocalShard returns the encrypted bytes of a locally stored shard.
+func ReadLocalShard(shardID string) ([]byte, error) {
+	path, err := localShardPath(shardID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shard %s: %w", shardID, err)
+	}
+	return data, nil
+}
+
+// heldMarkerSuffix marks shards that peers pushed to this node.
+const heldMarkerSuffix = ".held"
+
+// WriteLocalShard stores the encrypted bytes of a shard pushed by a peer after
+// verifying them, and marks it as held for peers.
+func WriteLocalShard(shardID string, encryptedData []byte) error {
+	if err := VerifyShard(shardID, encryptedData); err != nil {
+		return err
+	}
+	path, err := localShardPath(shardID)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, encryptedData, 0644); err != nil {
+		return fmt.Errorf("failed to write shard %s: %w", shardID, err)
+	}
+	if err := os.WriteFile(strings.TrimSuffix(path, ".bin")+heldMarkerSuffix, nil, 0644); err != nil {
+		return fmt.Errorf("failed to mark shard %s as held: %w", shardID, err)
+	}
+	mu.Lock()
+	ShardMap[shardID] = true
+	hook := shardStoredHook
+	mu.Unlock()
+	if hook != nil {
+		hook(shardID)
+	}
+	return nil
+}
+
+// HeldForPeers reports whether a peer pushed the shard to this node, so it is
+// stored on the peer's behalf and not only for files uploaded here.
+func HeldForPeers(shardID string) bool {
+	path, err := localShardPath(shardID)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(strings.TrimSuffix(path, ".bin") + heldMarkerSuffix)
+	return err == nil
+}
+
+// DeleteLocalShard removes the local copy of a shard and its held marker.
+// Deleting a shard that is not stored is not an error.
+func DeleteLocalShard(shardID string) error {
+	path, err := localShardPath(shardID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete shard %s: %w", shardID, err)
+	}
+	if err := os.Remove(strings.TrimSuffix(path, ".bin") + heldMarkerSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete shard %s: %w", shardID, err)
+	}
+	mu.Lock()
+	delete(ShardMap, shardID)
+	mu.Unlock()
+	return nil
+}
+
+// EncryptShard encrypts shard content for storage and returns the shard ID
+// and the encrypted bytes peers store and serve.
+func EncryptShard(plaintext []byte) (string, []byte, error) {
+	encrypted, err := EncryptData(plaintext, encryptionKey)
+	if err != nil {
+		return "", nil, err
+	}
+	hash := sha256.Sum256(plaintext)
+	return hex.EncodeToString(hash[:]), encrypted, nil
+}
+
+// VerifyShard checks that encrypted shard data decrypts to content matching the shard ID.
+func VerifyShard(shardID string, encryptedData []byte) error {
+	plainData, err := DecryptData(encryptedData, encryptionKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt shard %s: %w", shardID, err)
+	}
+	hash := sha256.Sum256(plainData)
+	if hex.EncodeToString(hash[:]) != shardID {
+		return fmt.Errorf("shard %s failed integrity check", shardID)
+	}
+	return nil
+}
+
+// ListLocalShards returns the IDs of all shards stored locally.
+func ListLocalShards() ([]string, error) {
+	entries, err := os.ReadDir(GetStorageDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage directory: %w", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".bin") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, ".bin"))
+	}
+	return ids, nil
+}
+
+// GetUsedBytes returns the total size of the shards stored locally.
+func GetUsedBytes() int64 {
+	entries, err := os.ReadDir(GetStorageDir())
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".bin") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// reservedBytes counts space promised to shard uploads still in progress.
+var (
+	reservedBytes int64
+	reserveMu     sync.Mutex
+)
+
+// ReserveSpace claims size bytes of the node's storage allocation for a shard
+// upload, counting both stored shards and other uploads in progress. The
+// returned release function must be called once the shard is written or the
+// upload is abandoned.
+func ReserveSpace(size int64) (release func(), err error) {
+	storageGB, err := setup.ReadStorageAllocation()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage allocation: %v", err)
+	}
+	limit := int64(storageGB) << 30
+	reserveMu.Lock()
+	defer reserveMu.Unlock()
+	used := GetUsedBytes() + reservedBytes
+	if used+size > limit {
+		return nil, fmt.Errorf("storing %d bytes would exceed the %d GB allocation (%d bytes in use)", size, storageGB, used)
+	}
+	reservedBytes += size
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			reserveMu.Lock()
+			reservedBytes -= size
+			reserveMu.Unlock()
+		})
+	}, nil
+}
+
 // GetShardCount returns the number of stored shards.
 func GetShardCount() int {
 	mu.Lock()